@@ -0,0 +1,410 @@
+// Package bench 测量tinyrpc的吞吐量和延迟
+// 可以在进程内启动服务端，也可以压测已经注册了Echo服务的服务端，结果以JSON保存，便于不同版本之间比较
+package bench
+
+import (
+	"context"
+	"errors"
+	"github.com/Asolmn/tinyrpc"
+	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/xclient"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pattern 压测的调用方式
+type Pattern string
+
+const (
+	PatternCall      Pattern = "call"      // 每个并发同步调用Client.Call
+	PatternGo        Pattern = "go"        // 每个并发通过Client.Go保持Pipeline个未完成的调用
+	PatternBroadcast Pattern = "broadcast" // 每个并发通过XClient广播到所有服务端
+)
+
+// Config 压测配置
+type Config struct {
+	Addrs       []string      `json:"addrs,omitempty"` // 压测的服务端地址，为空时在进程内启动Servers个服务端
+	Servers     int           `json:"servers"`         // 进程内启动的服务端数量，默认为1
+	Codec       codec.Type    `json:"codec"`           // 编解码方式，默认为gob
+	Pattern     Pattern       `json:"pattern"`         // 调用方式，默认为call
+	Concurrency int           `json:"concurrency"`     // 并发数，默认为GOMAXPROCS
+	Connections int           `json:"connections"`     // 每个服务端的连接数，并发平均分配到各个连接，默认为1
+	Pipeline    int           `json:"pipeline"`        // PatternGo时每个并发未完成的调用数，默认为8
+	PayloadSize int           `json:"payload_size"`    // 请求和响应的负载大小，单位为字节
+	Requests    int           `json:"requests"`        // 总请求数，为0时按照Duration运行
+	Duration    time.Duration `json:"duration"`        // 运行时间，Requests和Duration都为0时运行10s
+	Warmup      time.Duration `json:"warmup"`          // 预热时间，预热期间的调用不计入结果
+}
+
+// Result 压测结果，延迟的单位为纳秒
+type Result struct {
+	Label      string    `json:"label,omitempty"` // 调用方设置的标签，例如版本号或者提交
+	Config     Config    `json:"config"`
+	GoVersion  string    `json:"go_version"`
+	GOOS       string    `json:"goos"`
+	GOARCH     string    `json:"goarch"`
+	NumCPU     int       `json:"num_cpu"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Start      time.Time `json:"start"`
+
+	Requests    int64         `json:"requests"` // 完成的调用数，包括失败的调用
+	Errors      int64         `json:"errors"`   // 失败的调用数
+	FirstError  string        `json:"first_error,omitempty"`
+	Elapsed     time.Duration `json:"elapsed"`       // 实际运行时间
+	QPS         float64       `json:"qps"`           // 每秒完成的调用数
+	Latency     Latency       `json:"latency"`       // 调用延迟
+	AllocsPerOp float64       `json:"allocs_per_op"` // 每次调用的内存分配次数，进程内启动服务端时包括服务端的分配
+	BytesPerOp  float64       `json:"bytes_per_op"`  // 每次调用分配的字节数
+}
+
+// Latency 延迟分布
+type Latency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// Payload Echo服务的请求和响应
+type Payload struct {
+	Data []byte
+}
+
+// Echo 压测使用的服务，原样返回请求，压测已有的服务端时需要注册该服务
+type Echo int
+
+// Echo 原样返回请求
+func (e *Echo) Echo(args Payload, reply *Payload) error {
+	reply.Data = args.Data
+	return nil
+}
+
+const echoMethod = "Echo.Echo"
+
+// withDefaults 返回填充了默认值的配置
+func (c Config) withDefaults() Config {
+	if c.Servers <= 0 {
+		c.Servers = 1
+	}
+	if c.Codec == "" {
+		c.Codec = codec.GobType
+	}
+	if c.Pattern == "" {
+		c.Pattern = PatternCall
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	if c.Connections <= 0 {
+		c.Connections = 1
+	}
+	if c.Pipeline <= 0 {
+		c.Pipeline = 8
+	}
+	if c.Requests <= 0 && c.Duration <= 0 {
+		c.Duration = 10 * time.Second
+	}
+	return c
+}
+
+// Run 按照配置运行压测，ctx取消时提前结束并返回已经完成的结果
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	cfg = cfg.withDefaults()
+	switch cfg.Pattern {
+	case PatternCall, PatternGo, PatternBroadcast:
+	default:
+		return nil, errors.New("rpc bench: unknown pattern " + string(cfg.Pattern))
+	}
+
+	addrs := cfg.Addrs
+	if len(addrs) == 0 {
+		var stop func()
+		var err error
+		if addrs, stop, err = startServers(cfg.Servers); err != nil {
+			return nil, err
+		}
+		defer stop()
+	}
+
+	r := &runner{cfg: cfg, payload: Payload{Data: make([]byte, cfg.PayloadSize)}}
+	if err := r.dial(addrs); err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	// 预热期间建立连接、填充缓存，不记录结果
+	if cfg.Warmup > 0 {
+		wctx, cancel := context.WithTimeout(ctx, cfg.Warmup)
+		r.run(wctx, 0, false)
+		cancel()
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	runCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	start := time.Now()
+	latencies := r.run(runCtx, int64(cfg.Requests), true)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	res := &Result{
+		Config:     cfg,
+		GoVersion:  runtime.Version(),
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Start:      start,
+		Requests:   int64(len(latencies)),
+		Errors:     r.errors,
+		Elapsed:    elapsed,
+		Latency:    summarize(latencies),
+	}
+	if r.firstErr != nil {
+		res.FirstError = r.firstErr.Error()
+	}
+	if elapsed > 0 {
+		res.QPS = float64(res.Requests) / elapsed.Seconds()
+	}
+	if res.Requests > 0 {
+		res.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(res.Requests)
+		res.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Requests)
+	}
+	return res, nil
+}
+
+// startServers 在进程内启动n个注册了Echo服务的服务端
+func startServers(n int) ([]string, func(), error) {
+	var addrs []string
+	var servers []*tinyrpc.Server
+	stop := func() {
+		for _, server := range servers {
+			_ = server.Shutdown(context.Background())
+		}
+	}
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+		server := tinyrpc.NewServer()
+		_ = server.Register(new(Echo))
+		go server.Accept(l)
+		servers = append(servers, server)
+		addrs = append(addrs, "tcp@"+l.Addr().String())
+	}
+	return addrs, stop, nil
+}
+
+// runner 保存压测过程中的连接和统计
+type runner struct {
+	cfg      Config
+	payload  Payload
+	clients  []*tinyrpc.Client // PatternCall和PatternGo使用的连接
+	xclients []*xclient.XClient
+	issued   int64 // 已经发起的调用数，用于Requests限制
+	errors   int64 // 失败的调用数
+	mu       sync.Mutex
+	firstErr error // 第一个错误
+}
+
+// dial 为每个服务端建立Connections个连接
+func (r *runner) dial(addrs []string) error {
+	opt := &tinyrpc.Option{CodecType: r.cfg.Codec, ConnectTimeout: 10 * time.Second}
+	for i := 0; i < r.cfg.Connections; i++ {
+		if r.cfg.Pattern == PatternBroadcast {
+			d := xclient.NewMultiServerDiscovery(addrs)
+			r.xclients = append(r.xclients, xclient.NewXClient(d, xclient.RoundRobinSelect, opt))
+			continue
+		}
+		for _, addr := range addrs {
+			client, err := tinyrpc.XDial(addr, opt)
+			if err != nil {
+				r.close()
+				return errors.New("rpc bench: dial " + addr + ": " + err.Error())
+			}
+			r.clients = append(r.clients, client)
+		}
+	}
+	return nil
+}
+
+// close 关闭所有连接
+func (r *runner) close() {
+	for _, client := range r.clients {
+		_ = client.Close()
+	}
+	for _, xc := range r.xclients {
+		_ = xc.Close()
+	}
+}
+
+// acquire 申请发起一次调用，达到请求数限制或者ctx结束时返回false
+func (r *runner) acquire(ctx context.Context, limit int64) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return limit <= 0 || atomic.AddInt64(&r.issued, 1) <= limit
+}
+
+// record 记录一次调用的结果
+func (r *runner) record(err error) {
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&r.errors, 1)
+	r.mu.Lock()
+	if r.firstErr == nil {
+		r.firstErr = err
+	}
+	r.mu.Unlock()
+}
+
+// run 启动Concurrency个并发运行到ctx结束或者达到limit个请求，返回所有调用的延迟
+func (r *runner) run(ctx context.Context, limit int64, record bool) []time.Duration {
+	atomic.StoreInt64(&r.issued, 0)
+	results := make([][]time.Duration, r.cfg.Concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var latencies []time.Duration
+			switch r.cfg.Pattern {
+			case PatternCall:
+				latencies = r.runCall(ctx, r.clients[i%len(r.clients)], limit)
+			case PatternGo:
+				latencies = r.runGo(ctx, r.clients[i%len(r.clients)], limit)
+			case PatternBroadcast:
+				latencies = r.runBroadcast(ctx, r.xclients[i%len(r.xclients)], limit)
+			}
+			results[i] = latencies
+		}(i)
+	}
+	wg.Wait()
+
+	if !record {
+		atomic.StoreInt64(&r.errors, 0)
+		r.firstErr = nil
+		return nil
+	}
+	var all []time.Duration
+	for _, latencies := range results {
+		all = append(all, latencies...)
+	}
+	return all
+}
+
+// runCall 同步调用
+func (r *runner) runCall(ctx context.Context, client *tinyrpc.Client, limit int64) []time.Duration {
+	var latencies []time.Duration
+	for r.acquire(ctx, limit) {
+		var reply Payload
+		start := time.Now()
+		err := client.Call(context.Background(), echoMethod, r.payload, &reply)
+		latencies = append(latencies, time.Since(start))
+		r.record(err)
+	}
+	return latencies
+}
+
+// runGo 异步调用，保持Pipeline个未完成的调用
+func (r *runner) runGo(ctx context.Context, client *tinyrpc.Client, limit int64) []time.Duration {
+	var latencies []time.Duration
+	done := make(chan *tinyrpc.Call, r.cfg.Pipeline)
+	starts := make(map[*tinyrpc.Call]time.Time, r.cfg.Pipeline)
+
+	issue := func() bool {
+		if !r.acquire(ctx, limit) {
+			return false
+		}
+		start := time.Now()
+		call := client.Go(echoMethod, r.payload, new(Payload), done)
+		starts[call] = start
+		return true
+	}
+	for i := 0; i < r.cfg.Pipeline && issue(); i++ {
+	}
+	for len(starts) > 0 {
+		call := <-done
+		latencies = append(latencies, time.Since(starts[call]))
+		delete(starts, call)
+		r.record(call.Error)
+		issue()
+	}
+	return latencies
+}
+
+// runBroadcast 广播调用所有服务端
+func (r *runner) runBroadcast(ctx context.Context, xc *xclient.XClient, limit int64) []time.Duration {
+	var latencies []time.Duration
+	for r.acquire(ctx, limit) {
+		var reply Payload
+		start := time.Now()
+		err := xc.Broadcaset(context.Background(), echoMethod, r.payload, &reply)
+		latencies = append(latencies, time.Since(start))
+		r.record(err)
+	}
+	return latencies
+}
+
+// summarize 计算延迟分布，会对latencies排序
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	return Latency{
+		Min:  latencies[0],
+		Mean: total / time.Duration(len(latencies)),
+		P50:  percentile(latencies, 0.50),
+		P90:  percentile(latencies, 0.90),
+		P99:  percentile(latencies, 0.99),
+		P999: percentile(latencies, 0.999),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// percentile 返回已排序的延迟中第p分位的值
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// ParseAddrs 解析以逗号分隔的服务端地址
+func ParseAddrs(addrs string) []string {
+	var parsed []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			parsed = append(parsed, addr)
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,47 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/codec"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, cfg := range []Config{
+		{Pattern: PatternCall, Concurrency: 4, Requests: 200, PayloadSize: 64},
+		{Pattern: PatternGo, Concurrency: 2, Pipeline: 4, Requests: 200, Codec: codec.JsonType},
+		{Pattern: PatternBroadcast, Servers: 3, Concurrency: 2, Requests: 50},
+		{Pattern: PatternCall, Concurrency: 2, Duration: 100 * time.Millisecond, Warmup: 20 * time.Millisecond},
+	} {
+		res, err := Run(context.Background(), cfg)
+		_assert(err == nil, "%s: run failed: %v", cfg.Pattern, err)
+		_assert(res.Errors == 0, "%s: unexpected errors: %s", cfg.Pattern, res.FirstError)
+		if cfg.Requests > 0 {
+			_assert(res.Requests == int64(cfg.Requests), "%s: expect %d requests, but got %d", cfg.Pattern, cfg.Requests, res.Requests)
+		} else {
+			_assert(res.Requests > 0, "%s: no requests in %s", cfg.Pattern, cfg.Duration)
+		}
+		l := res.Latency
+		_assert(l.Min <= l.P50 && l.P50 <= l.P99 && l.P99 <= l.Max, "%s: unordered percentiles %+v", cfg.Pattern, l)
+		_assert(res.QPS > 0 && res.AllocsPerOp > 0, "%s: missing qps or allocations", cfg.Pattern)
+	}
+
+	_, err := Run(context.Background(), Config{Pattern: "foo"})
+	_assert(err != nil, "unknown pattern should fail")
+}
+
+func TestCompare(t *testing.T) {
+	base := &Result{QPS: 1000, Latency: Latency{P50: time.Millisecond}}
+	current := &Result{QPS: 1500, Latency: Latency{P50: 2 * time.Millisecond}}
+	changes := Compare(base, current)
+	_assert(changes[0].Metric == "qps" && changes[0].Delta == 50 && changes[0].Better, "qps should improve by 50%%")
+	_assert(changes[2].Metric == "latency_p50" && changes[2].Delta == 100 && !changes[2].Better, "p50 should regress by 100%%")
+}
@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+)
+
+// Change 两次压测结果中一个指标的变化
+type Change struct {
+	Metric  string  `json:"metric"`
+	Base    float64 `json:"base"`
+	Current float64 `json:"current"`
+	Delta   float64 `json:"delta"`  // 相对变化的百分比，base为0时为0
+	Better  bool    `json:"better"` // 变化是否为改进，QPS越大越好，其余指标越小越好
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%-14s %14.2f %14.2f %+8.2f%%", c.Metric, c.Base, c.Current, c.Delta)
+}
+
+// Compare 比较两次压测结果，延迟的单位为微秒
+func Compare(base, current *Result) []Change {
+	us := func(d time.Duration) float64 { return float64(d) / float64(time.Microsecond) }
+	changes := []Change{
+		newChange("qps", base.QPS, current.QPS, true),
+		newChange("latency_mean", us(base.Latency.Mean), us(current.Latency.Mean), false),
+		newChange("latency_p50", us(base.Latency.P50), us(current.Latency.P50), false),
+		newChange("latency_p90", us(base.Latency.P90), us(current.Latency.P90), false),
+		newChange("latency_p99", us(base.Latency.P99), us(current.Latency.P99), false),
+		newChange("latency_p999", us(base.Latency.P999), us(current.Latency.P999), false),
+		newChange("allocs_per_op", base.AllocsPerOp, current.AllocsPerOp, false),
+		newChange("bytes_per_op", base.BytesPerOp, current.BytesPerOp, false),
+	}
+	return changes
+}
+
+// newChange 计算指标的变化，higherIsBetter表示指标是否越大越好
+func newChange(metric string, base, current float64, higherIsBetter bool) Change {
+	c := Change{Metric: metric, Base: base, Current: current}
+	if base != 0 {
+		c.Delta = (current - base) / base * 100
+	}
+	c.Better = (current > base) == higherIsBetter && current != base
+	return c
+}
@@ -7,8 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/tracing"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"strings"
@@ -19,15 +20,20 @@ import (
 // Call 为call操作的实例
 // call指的是客户端应用程序向服务器应用程序发送请求并等待响应的操作
 type Call struct {
-	Seq           uint64      // 请求序列号
-	ServiceMethod string      // <service>.<method>
-	Args          interface{} // 函数参数
-	Reply         interface{} // 函数的回复
-	Error         error       // 如果发生错误，则进行设置
-	Done          chan *Call  // 调用结束时，使用call.done()通知调用方
+	Seq           uint64            // 请求序列号
+	ServiceMethod string            // <service>.<method>
+	Args          interface{}       // 函数参数
+	Reply         interface{}       // 函数的回复
+	Error         error             // 如果发生错误，则进行设置
+	Done          chan *Call        // 调用结束时，使用call.done()通知调用方
+	Metadata      map[string]string // 随请求头发送的元数据
+	metrics       *callMetrics      // 启用指标时记录调用的结果
 }
 
 func (call *Call) done() {
+	if call.metrics != nil {
+		call.metrics.done(call.ServiceMethod, callCode(call.Error))
+	}
 	call.Done <- call
 }
 
@@ -43,6 +49,8 @@ type Client struct {
 	mu      sync.Mutex       // Client实例互斥
 	seq     uint64           // 用于给发送的请求编号
 	pending map[uint64]*Call // 存储未处理完的请求
+	target  string           // 服务端地址，作为指标的target标签
+	metrics *clientMetrics   // 客户端的指标，为nil表示不记录
 
 	// closing, shutdown任意一个值为true，则表示Client处于不可用状态
 	// closing是用户主动关闭，即调用Close()
@@ -56,6 +64,28 @@ var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// ServerError 服务端处理请求时返回的错误，即响应头中的Error
+// 与连接断开、编解码失败、调用取消等客户端错误不同，说明服务端仍然在读取和处理请求
+// 可以通过errors.Is判断是否为ErrServiceNotFound、ErrServerShutdown等服务端错误
+type ServerError string
+
+func (e ServerError) Error() string { return string(e) }
+
+// serverErrors 服务端返回的可以通过errors.Is识别的错误
+var serverErrors = []error{ErrServerShutdown, ErrIllFormedRequest, ErrServiceNotFound, ErrMethodNotFound, ErrHandleTimeout}
+
+// Is 判断服务端返回的错误是否为target，服务端错误的消息以target的消息开头
+func (e ServerError) Is(target error) bool {
+	for _, se := range serverErrors {
+		if target != se {
+			continue
+		}
+		msg := se.Error()
+		return string(e) == msg || strings.HasPrefix(string(e), msg+" ") || strings.HasPrefix(string(e), msg+":")
+	}
+	return false
+}
+
 // Close 关闭链接
 func (client *Client) Close() error {
 	client.mu.Lock()
@@ -138,10 +168,10 @@ func (client *Client) receive() {
 		call := client.removeCall(h.Seq)
 
 		switch {
-		case call == nil: // call不存在
-			err = client.cc.ReadBody(h.Error)
+		case call == nil: // call不存在，通常是调用已经被取消，丢弃响应体
+			err = client.cc.ReadBody(nil)
 		case h.Error != "": // call存在，但服务端处理错误，即h.Error不为空
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = ServerError(h.Error)
 			err = client.cc.ReadBody(nil)
 			call.done() // 通知调用方
 		default: // call存在，服务端处理正常，所以需要从body中读取reply的值
@@ -161,6 +191,9 @@ func (client *Client) send(call *Call) {
 	client.sending.Lock()
 	defer client.sending.Unlock()
 
+	if client.metrics != nil {
+		call.metrics = client.metrics.begin(client.target)
+	}
 	// 注册call
 	seq, err := client.registerCall(call)
 	if err != nil {
@@ -173,6 +206,7 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.Metadata
 
 	// Write设置header与body并发送
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -206,13 +240,20 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	return opt, nil
 }
 
+// log 返回客户端输出日志使用的Logger
+func (opt *Option) log() logging.Logger {
+	return logging.Or(opt.Logger)
+}
+
 // Go 异步调用函数。
 // 它返回表示调用的Call结构。
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil { // 检查done通道为空
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 { // 检查done通道的缓存区间是否为0
-		log.Panic("rpc client: done channel is unbuffered")
+		// 无缓冲的done通道会阻塞接收响应的goroutine，属于调用方的编程错误
+		client.opt.log().Error("rpc client: done channel is unbuffered")
+		panic("rpc client: done channel is unbuffered")
 	}
 
 	call := &Call{
@@ -228,11 +269,31 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 
 // Call 调用命名函数，等待它完成，
 // 并返回其错误状态
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+// 启用追踪时创建调用方的span，ctx中的span作为父span，span上下文随元数据传给服务端
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	ctx, span := client.opt.Tracer.Start(ctx, serviceMethod, tracing.KindClient)
+	defer func() { span.End(err) }()
+
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		Metadata:      outgoingMetadata(ctx), // 携带WithMetadata设置的元数据
+	}
+	if span != nil {
+		span.SetAttribute(tracing.AttrMethod, serviceMethod)
+		span.SetAttribute(tracing.AttrPeer, client.target)
+		span.SetAttribute(tracing.AttrCodec, string(client.opt.CodecType))
+		call.Metadata = injectSpan(call.Metadata, span)
+	}
+	client.send(call)
+	span.AddEvent(eventSent)
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
+		if call := client.removeCall(call.Seq); call != nil && call.metrics != nil {
+			call.metrics.done(call.ServiceMethod, codeCanceled)
+		}
 		return errors.New("rpc client: call failed: " + ctx.Err().Error())
 	case call := <-(call.Done):
 		return call.Error
@@ -245,14 +306,14 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	var f codec.NewCodecFunc = codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error:", err)
+		opt.log().Error("rpc client: codec error", "err", err)
 		return nil, err
 	}
 
 	// json方式格式化Option信息，进行协议交换
 	// 发送option给server
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		opt.log().Error("rpc client: options error", "err", err)
 		_ = conn.Close()
 		return nil, err
 	}
@@ -261,7 +322,9 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	// 返回完成编解码器与序列号，pending队列初始化的Client
 	// newClientCodec(NewGobCodec(conn), opt)
 	// f(conn)，会返回一个初始化好的GobCodec实例指针
-	return newClientCodec(f(conn), opt), nil
+	client := newClientCodec(newCodec(f, conn, opt.log()), opt)
+	client.target = conn.RemoteAddr().Network() + "@" + conn.RemoteAddr().String()
+	return client, nil
 }
 
 // 指定Client的编解码器，还有初始化pending队列以及初始化序列号
@@ -269,8 +332,12 @@ func newClientCodec(cc codec.Codec, option *Option) *Client {
 	client := &Client{
 		seq:     1, // seq以1开头，0表示无效调用
 		cc:      cc,
+		opt:     option,
 		pending: make(map[uint64]*Call),
 	}
+	if option.Metrics != nil {
+		client.metrics = newClientMetrics(option.Metrics)
+	}
 	go client.receive()
 	return client
 }
@@ -283,23 +350,33 @@ type clientResult struct {
 
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
-func dialTimeout(newClient newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+// dialTimeout 建立连接并完成协议交换，ctx被取消时放弃连接
+// 启用追踪时创建span，connected事件之前为建立连接的时间，之后为协议交换的时间
+func dialTimeout(ctx context.Context, newClient newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
 
 	opt, err := parseOptions(opts...) // 解析Option
 	if err != nil {
 		return
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout) // 建立连接
+	_, span := opt.Tracer.Start(ctx, "tinyrpc.Dial", tracing.KindClient)
+	span.SetAttribute(tracing.AttrPeer, network+"@"+address)
+	span.SetAttribute(tracing.AttrCodec, string(opt.CodecType))
+	defer func() { span.End(err) }()
+
+	dialer := net.Dialer{Timeout: opt.ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, network, address) // 建立连接
 	if err != nil {
 		return
 	}
+	span.AddEvent(eventConnected)
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
 		}
 	}()
 
-	ch := make(chan clientResult)
+	// 信道带有缓冲，超时或者取消之后，子协程仍然可以退出
+	ch := make(chan clientResult, 1)
 
 	// 通过子协程创建执行NewClient或NewHTTPClient，执行完成后，通过信道ch发送结果
 	go func() {
@@ -307,15 +384,17 @@ func dialTimeout(newClient newClientFunc, network, address string, opts ...*Opti
 		ch <- clientResult{client: client, err: err}
 	}()
 
-	// 如果连接超时时间设置为0，则直接返回NewClient的执行结果
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
+	// 如果连接超时时间设置为0，则只在ctx被取消时放弃
+	var timeout <-chan time.Time
+	if opt.ConnectTimeout != 0 {
+		timeout = time.After(opt.ConnectTimeout)
 	}
 
 	select {
-	case <-time.After(opt.ConnectTimeout): // time.After信道先收到消息，说明NewClient执行超时
+	case <-timeout: // time.After信道先收到消息，说明NewClient执行超时
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-ctx.Done():
+		return nil, errors.New("rpc client: dial failed: " + ctx.Err().Error())
 	case result := <-ch: // 从ch信道获取NewClient执行的结果
 		return result.client, result.err
 	}
@@ -323,7 +402,7 @@ func dialTimeout(newClient newClientFunc, network, address string, opts ...*Opti
 
 // Dial 连接到指定网络地址的RPC服务器
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	return dialTimeout(context.Background(), NewClient, network, address, opts...)
 }
 
 // NewHTTPClient 通过HTTP作为传输协议新建客户端
@@ -346,7 +425,7 @@ func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 // DialHTTP 连接到指定的网络地址的HTTP RPC服务器
 // 监听默认的HTTP RPC路径
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	return dialTimeout(context.Background(), NewHTTPClient, network, address, opts...)
 }
 
 // XDial 调用不同的函数连接到RPC服务器
@@ -354,16 +433,29 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 // rpcAddr是一种通用格式（protocol@addr）表示rpc服务器
 // 例如:http@localhost:5000, tcp@localhost:5000
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
+	return XDialContext(context.Background(), rpcAddr, opts...)
+}
+
+// XDialContext 与XDial相同，ctx被取消时放弃连接，ctx中的span作为建立连接的span的父span
+func XDialContext(ctx context.Context, rpcAddr string, opts ...*Option) (*Client, error) {
 	// 以@作为分割
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rcp client err: wrong format '%s', expect protocol@addr", rpcAddr)
 	}
 	protocol, addr := parts[0], parts[1]
+	var client *Client
+	var err error
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts...)
+		client, err = dialTimeout(ctx, NewHTTPClient, "tcp", addr, opts...)
 	default:
-		return Dial(protocol, addr, opts...)
+		client, err = dialTimeout(ctx, NewClient, protocol, addr, opts...)
+	}
+	if err != nil {
+		return nil, err
 	}
+	// 指标中使用与XClient相同的地址
+	client.target = rpcAddr
+	return client, nil
 }
@@ -2,6 +2,7 @@ package tinyrpc
 
 import (
 	"context"
+	"errors"
 	"net"
 	"os"
 	"strings"
@@ -12,7 +13,9 @@ import (
 func TestClient_dialTimeout(t *testing.T) {
 	t.Parallel()
 
-	l, _ := net.Listen("tcp", ":5000")
+	// 使用随机端口，与其他并行的测试互不影响
+	l, err := net.Listen("tcp", ":0")
+	_assert(err == nil, "failed to listen: %v", err)
 
 	f := func(conn net.Conn, opt *Option) (client *Client, err error) {
 		_ = conn.Close()
@@ -21,14 +24,21 @@ func TestClient_dialTimeout(t *testing.T) {
 	}
 
 	t.Run("timeout", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+		_, err := dialTimeout(context.Background(), f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
 		_assert(err != nil && strings.Contains(err.Error(), "connect timeout"), "expect a timeout error")
 	})
 
 	t.Run("0", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
+		_, err := dialTimeout(context.Background(), f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
 		_assert(err == nil, "0 means no limit")
 	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := dialTimeout(ctx, f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
+		_assert(err != nil && strings.Contains(err.Error(), "dial failed"), "expect a canceled error")
+	})
 }
 
 type Bar int
@@ -41,7 +51,10 @@ func (b Bar) Timeout(argv int, reply *int) error {
 func startServer(add chan string) {
 	var b Bar
 	_ = Register(&b)
-	l, _ := net.Listen("tcp", ":5000")
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		panic(err)
+	}
 	add <- l.Addr().String()
 	Accept(l)
 }
@@ -56,7 +69,9 @@ func TestClient_Call(t *testing.T) {
 
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		// 调用cancel释放计时器，go vet要求不能丢弃cancel
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
@@ -69,7 +84,41 @@ func TestClient_Call(t *testing.T) {
 		var reply int
 		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+		_assert(errors.Is(err, ErrHandleTimeout), "expect ErrHandleTimeout, but got %v", err)
 	})
+	t.Run("server error", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		var reply int
+		err := client.Call(context.Background(), "Baz.Timeout", 1, &reply)
+		var serverErr ServerError
+		_assert(errors.As(err, &serverErr), "expect a ServerError, but got %T %v", err, err)
+		_assert(errors.Is(err, ErrServiceNotFound) && !errors.Is(err, ErrMethodNotFound), "expect ErrServiceNotFound, but got %v", err)
+		err = client.Call(context.Background(), "Bar.Baz", 1, &reply)
+		_assert(errors.Is(err, ErrMethodNotFound) && !errors.Is(err, ErrServiceNotFound), "expect ErrMethodNotFound, but got %v", err)
+	})
+}
+
+func TestClient_Logger(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+
+	logger := new(recordLogger)
+	client, err := Dial("tcp", <-addrCh, &Option{Logger: logger})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	func() {
+		defer func() { _assert(recover() != nil, "an unbuffered done channel should panic") }()
+		client.Go("Bar.Timeout", 1, &reply, make(chan *Call))
+	}()
+	_assert(logger.has("ERROR rpc client: done channel is unbuffered"), "unbuffered done channel should be logged")
+
+	// 编解码器的编码错误输出到客户端配置的Logger，而不是logging.Default()
+	err = client.Call(context.Background(), "Bar.Timeout", make(chan int), &reply)
+	_assert(err != nil, "expect an encoding error")
+	_assert(logger.has("ERROR rpc codec: gob error encoding body"), "codec error should be logged by the client logger")
 }
 
 func TestXDial(t *testing.T) {
@@ -0,0 +1,120 @@
+// tinyrpc-bench 压测tinyrpc的吞吐量和延迟，结果可以保存为JSON，并与之前的结果比较
+//
+//	tinyrpc-bench -c 64 -d 30s -size 1024 -json -o v1.json -label v1
+//	tinyrpc-bench -c 64 -d 30s -size 1024 -baseline v1.json
+//	tinyrpc-bench -addr tcp@10.0.0.2:9999 -pattern go -pipeline 16
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/bench"
+	"github.com/Asolmn/tinyrpc/codec"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	var cfg bench.Config
+	addrs := flag.String("addr", "", "压测的服务端地址，以逗号分隔，服务端需要注册bench.Echo；为空时在进程内启动服务端")
+	flag.IntVar(&cfg.Servers, "servers", 1, "进程内启动的服务端数量")
+	codecName := flag.String("codec", "gob", "编解码方式，gob或者json")
+	pattern := flag.String("pattern", "call", "调用方式，call、go或者broadcast")
+	flag.IntVar(&cfg.Concurrency, "c", 0, "并发数，默认为GOMAXPROCS")
+	flag.IntVar(&cfg.Connections, "conns", 1, "每个服务端的连接数")
+	flag.IntVar(&cfg.Pipeline, "pipeline", 8, "go方式下每个并发未完成的调用数")
+	flag.IntVar(&cfg.PayloadSize, "size", 0, "负载大小，单位为字节")
+	flag.IntVar(&cfg.Requests, "n", 0, "总请求数，为0时按照-d运行")
+	flag.DurationVar(&cfg.Duration, "d", 10*time.Second, "运行时间")
+	flag.DurationVar(&cfg.Warmup, "warmup", time.Second, "预热时间")
+	label := flag.String("label", "", "结果的标签，例如版本号或者提交")
+	asJSON := flag.Bool("json", false, "以JSON格式输出结果")
+	output := flag.String("o", "", "将JSON结果写入文件")
+	baseline := flag.String("baseline", "", "与之前保存的JSON结果比较")
+	verbose := flag.Bool("v", false, "输出tinyrpc的日志")
+	flag.Parse()
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	cfg.Addrs = bench.ParseAddrs(*addrs)
+	cfg.Pattern = bench.Pattern(*pattern)
+	switch *codecName {
+	case "gob":
+		cfg.Codec = codec.GobType
+	case "json":
+		cfg.Codec = codec.JsonType
+	default:
+		cfg.Codec = codec.Type(*codecName)
+	}
+	if cfg.Requests > 0 {
+		cfg.Duration = 0
+	}
+
+	// Ctrl+C提前结束压测，仍然输出已经完成的结果
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := bench.Run(ctx, cfg)
+	if err != nil {
+		fatal(err)
+	}
+	res.Label = *label
+
+	if *output != "" {
+		data, _ := json.MarshalIndent(res, "", "  ")
+		if err = os.WriteFile(*output, append(data, '\n'), 0644); err != nil {
+			fatal(err)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(res)
+	} else {
+		printResult(res)
+	}
+
+	if *baseline != "" {
+		data, err := os.ReadFile(*baseline)
+		if err != nil {
+			fatal(err)
+		}
+		var base bench.Result
+		if err = json.Unmarshal(data, &base); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("\ncompared with %s %s\n", *baseline, base.Label)
+		fmt.Printf("%-14s %14s %14s %9s\n", "metric", "base", "current", "delta")
+		for _, c := range bench.Compare(&base, res) {
+			fmt.Println(c)
+		}
+	}
+}
+
+// printResult 以文本格式输出结果
+func printResult(res *bench.Result) {
+	c := res.Config
+	servers := len(c.Addrs)
+	if servers == 0 {
+		servers = c.Servers
+	}
+	fmt.Printf("pattern=%s codec=%s concurrency=%d conns=%d payload=%dB servers=%d\n",
+		c.Pattern, c.Codec, c.Concurrency, c.Connections, c.PayloadSize, servers)
+	fmt.Printf("requests=%d errors=%d elapsed=%s qps=%.0f\n", res.Requests, res.Errors, res.Elapsed.Round(time.Millisecond), res.QPS)
+	if res.FirstError != "" {
+		fmt.Println("first error:", res.FirstError)
+	}
+	l := res.Latency
+	fmt.Printf("latency min=%s mean=%s p50=%s p90=%s p99=%s p999=%s max=%s\n", l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	fmt.Printf("allocs/op=%.1f bytes/op=%.0f\n", res.AllocsPerOp, res.BytesPerOp)
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "tinyrpc-bench:", err)
+	os.Exit(1)
+}
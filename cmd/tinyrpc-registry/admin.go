@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/registry"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// adminClient 通过REST API管理注册中心，请求失败时依次尝试下一个注册中心
+type adminClient struct {
+	registries []string
+	client     *http.Client
+	json       bool      // 以JSON格式输出
+	stdout     io.Writer // 命令的输出
+}
+
+// admin 管理子命令，返回进程的退出码
+func admin(arguments []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("registry", "http://localhost:9000/_tinyrpc_/registry", "注册中心地址，多个地址以逗号分隔")
+	timeout := fs.Duration("timeout", 5*time.Second, "请求超时时间")
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: tinyrpc-registry admin [flags] <command>")
+		_, _ = fmt.Fprintln(stderr, "\ncommands:")
+		_, _ = fmt.Fprintln(stderr, "  list [service[@version]]   列出服务实例")
+		_, _ = fmt.Fprintln(stderr, "  services                   列出服务以及版本")
+		_, _ = fmt.Fprintln(stderr, "  deregister addr...         注销服务实例")
+		_, _ = fmt.Fprintln(stderr, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(arguments); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	c := &adminClient{
+		registries: registry.ParseRegistries(*addr),
+		client:     &http.Client{Timeout: *timeout},
+		json:       *asJSON,
+		stdout:     stdout,
+	}
+	var err error
+	switch cmd, rest := fs.Arg(0), fs.Args(); cmd {
+	case "list":
+		err = c.list(rest[1:])
+	case "services":
+		err = c.services()
+	case "deregister":
+		err = c.deregister(rest[1:])
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "tinyrpc-registry:", err)
+		return 1
+	}
+	return 0
+}
+
+// list 列出服务实例，可以指定service[@version]过滤
+func (c *adminClient) list(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: list [service[@version]]")
+	}
+	query := url.Values{}
+	if len(args) == 1 {
+		name, version := registry.SplitService(args[0])
+		query.Set("service", name)
+		if version != "" {
+			query.Set("version", version)
+		}
+	}
+	var body struct {
+		Instances []*registry.Instance `json:"instances"`
+	}
+	resource := "instances"
+	if len(query) > 0 {
+		resource += "?" + query.Encode()
+	}
+	if err := c.do("GET", resource, http.StatusOK, &body); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(c.stdout, body.Instances)
+	}
+	printInstances(c.stdout, body.Instances)
+	return nil
+}
+
+// services 列出服务以及版本
+func (c *adminClient) services() error {
+	var body struct {
+		Services []registry.ServiceInfo `json:"services"`
+	}
+	if err := c.do("GET", "services", http.StatusOK, &body); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(c.stdout, body.Services)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVICE\tVERSIONS\tINSTANCES")
+	for _, s := range body.Services {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\n", s.Name, strings.Join(s.Versions, ","), s.Instances)
+	}
+	return w.Flush()
+}
+
+// deregister 注销服务实例
+func (c *adminClient) deregister(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: deregister addr...")
+	}
+	for _, rpcAddr := range args {
+		if err := c.do("DELETE", "instances/"+url.PathEscape(rpcAddr), http.StatusNoContent, nil); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.stdout, "deregistered", rpcAddr)
+	}
+	return nil
+}
+
+// do 发送REST API请求，状态码不是want时返回错误，out不为nil时解析响应体
+// 连接失败或者5xx时尝试下一个注册中心
+func (c *adminClient) do(method, resource string, want int, out interface{}) error {
+	err := errors.New("rpc registry: no registry address")
+	for _, addr := range c.registries {
+		var retry bool
+		if retry, err = c.doOne(addr, method, resource, want, out); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// doOne 向一个注册中心发送请求，返回的bool表示是否可以尝试下一个注册中心
+func (c *adminClient) doOne(addr, method, resource string, want int, out interface{}) (bool, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+"/v1/"+resource, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != want {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		msg := resp.Status
+		if body.Error != "" {
+			msg += ": " + body.Error
+		}
+		return resp.StatusCode >= 500, errors.New(method + " " + resource + ": " + msg)
+	}
+	if out != nil {
+		return false, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return false, nil
+}
+
+// printInstances 以表格输出服务实例
+func printInstances(out io.Writer, instances []*registry.Instance) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ADDR\tSERVICES\tMETADATA\tLAST HEARTBEAT")
+	for _, inst := range instances {
+		keys := make([]string, 0, len(inst.Metadata))
+		for k := range inst.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		md := make([]string, 0, len(keys))
+		for _, k := range keys {
+			md = append(md, k+"="+inst.Metadata[k])
+		}
+		heartbeat := "-"
+		if !inst.LastHeartbeat.IsZero() {
+			heartbeat = time.Since(inst.LastHeartbeat).Truncate(time.Second).String() + " ago"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", inst.Addr, strings.Join(inst.Services, ","), strings.Join(md, ","), heartbeat)
+	}
+	_ = w.Flush()
+}
+
+// printJSON 以缩进的JSON格式输出v
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
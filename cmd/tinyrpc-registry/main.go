@@ -0,0 +1,224 @@
+// tinyrpc-registry 独立运行的注册中心，支持持久化、集群复制和主动健康检查
+//
+//	tinyrpc-registry -listen :9000 -persist /var/lib/tinyrpc/registry.json -peers http://10.0.0.2:9000/_tinyrpc_/registry
+//	tinyrpc-registry admin -registry http://localhost:9000/_tinyrpc_/registry list
+//	tinyrpc-registry admin -registry http://localhost:9000/_tinyrpc_/registry deregister tcp@10.0.0.3:8001
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/registry"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// config 注册中心的启动参数
+type config struct {
+	listen            string
+	path              string
+	ttl               time.Duration
+	persist           string
+	snapshotInterval  time.Duration
+	peers             string
+	replicateInterval time.Duration
+	healthCheck       time.Duration
+	prober            string
+	logFile           string
+	quiet             bool
+	logLevel          string
+	accessLog         bool
+	shutdownTimeout   time.Duration
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// errUsage 命令行参数错误，已经输出了用法
+var errUsage = errors.New("invalid usage")
+
+// run 解析命令行参数，启动注册中心或者执行admin子命令，返回进程的退出码
+func run(arguments []string, stdout, stderr io.Writer) int {
+	if len(arguments) > 0 && arguments[0] == "admin" {
+		return admin(arguments[1:], stdout, stderr)
+	}
+
+	c, err := parseArgs(arguments, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if err := serve(c); err != nil {
+		_, _ = fmt.Fprintln(stderr, "tinyrpc-registry:", err)
+		return 1
+	}
+	return 0
+}
+
+// parseArgs 解析注册中心的启动参数，参数错误时向output输出用法并返回错误
+func parseArgs(arguments []string, output io.Writer) (*config, error) {
+	c := new(config)
+	fs := flag.NewFlagSet("tinyrpc-registry", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.listen, "listen", ":9000", "监听地址")
+	fs.StringVar(&c.path, "path", "/_tinyrpc_/registry", "注册中心的HTTP路径")
+	fs.DurationVar(&c.ttl, "ttl", 5*time.Minute, "服务实例没有心跳之后的过期时间，0表示不过期")
+	fs.StringVar(&c.persist, "persist", "", "快照文件，为空时不持久化，日志文件为快照文件加上.log后缀")
+	fs.DurationVar(&c.snapshotInterval, "snapshot-interval", time.Minute, "生成快照的时间间隔，0表示只在关闭时生成")
+	fs.StringVar(&c.peers, "peers", "", "集群中其它注册中心的地址，以逗号分隔")
+	fs.DurationVar(&c.replicateInterval, "replicate-interval", 0, "从其它注册中心同步的时间间隔，0使用默认值")
+	fs.DurationVar(&c.healthCheck, "health-check", 0, "主动健康检查的时间间隔，0表示不启用")
+	fs.StringVar(&c.prober, "health-prober", "handshake", "健康检查的探测方式，handshake或者health")
+	fs.StringVar(&c.logFile, "log", "", "日志文件，为空时输出到标准错误")
+	fs.BoolVar(&c.quiet, "quiet", false, "不输出日志")
+	fs.StringVar(&c.logLevel, "log-level", "info", "日志级别，debug、info、warn或者error，debug时输出每一次心跳")
+	fs.BoolVar(&c.accessLog, "access-log", false, "记录每一个HTTP请求")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 10*time.Second, "关闭时等待请求处理完成的最长时间")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "usage: tinyrpc-registry [flags]")
+		_, _ = fmt.Fprintln(output, "       tinyrpc-registry admin [-registry addr] <list [service] | services | deregister addr...>")
+		_, _ = fmt.Fprintln(output, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(arguments); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, errUsage
+	}
+	// 去掉末尾的/，避免REST API的路径变为//v1/
+	c.path = strings.TrimSuffix(c.path, "/")
+	return c, nil
+}
+
+// serve 启动注册中心，收到SIGINT或者SIGTERM之后优雅关闭
+func serve(c *config) error {
+	level, err := logging.ParseLevel(c.logLevel)
+	if err != nil {
+		return err
+	}
+	logging.SetDefault(logging.Std(level))
+
+	switch {
+	case c.quiet:
+		log.SetOutput(io.Discard)
+		logging.SetDefault(logging.Nop())
+	case c.logFile != "":
+		f, err := os.OpenFile(c.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		log.SetOutput(f)
+	}
+
+	r := registry.New(c.ttl)
+	if c.persist != "" {
+		if err := r.Persist(c.persist, c.snapshotInterval); err != nil {
+			return err
+		}
+	}
+	if c.peers != "" {
+		if err := r.Replicate(registry.ParseRegistries(c.peers), c.replicateInterval); err != nil {
+			_ = r.Close()
+			return err
+		}
+	}
+	if c.healthCheck > 0 {
+		opt := *registry.DefaultHealthCheckOption
+		opt.Interval = c.healthCheck
+		switch c.prober {
+		case "handshake":
+			opt.Prober = registry.HandshakeProber
+		case "health":
+			opt.Prober = registry.HealthServiceProber
+		default:
+			_ = r.Close()
+			return errors.New("unknown health prober " + c.prober)
+		}
+		if err := r.EnableHealthCheck(&opt); err != nil {
+			_ = r.Close()
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", c.listen)
+	if err != nil {
+		_ = r.Close()
+		return err
+	}
+	srv := &http.Server{Handler: handler(c, r)}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+	log.Printf("tinyrpc-registry: serving on %s%s", l.Addr(), c.path)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Println("tinyrpc-registry: received", s, "shutting down")
+	case err = <-errc:
+	}
+
+	// 先停止接受请求，等待watch等长轮询请求结束，再停止后台任务并生成快照
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+	if e := srv.Shutdown(ctx); e != nil {
+		log.Println("tinyrpc-registry: shutdown:", e)
+	}
+	if e := r.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// handler 返回注册中心的HTTP处理器，REST API挂载在注册中心路径之下，c.path不以/结尾
+func handler(c *config, r *registry.TinyRegistry) http.Handler {
+	r.SetPath(c.path)
+	mux := http.NewServeMux()
+	if c.path == "" { // 注册中心挂载在根路径
+		mux.Handle("/", r)
+	} else {
+		mux.Handle(c.path, r)
+		mux.Handle(c.path+"/v1/", r)
+	}
+	if c.accessLog {
+		return accessLog(mux)
+	}
+	return mux
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// accessLog 记录每一个HTTP请求的方法、路径、状态码和耗时
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req)
+		log.Printf("%s %s %s %d %s", req.RemoteAddr, req.Method, req.URL.RequestURI(), rec.status, time.Since(start))
+	})
+}
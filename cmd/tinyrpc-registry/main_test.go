@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// startRegistry 使用命令的HTTP处理器启动一个注册中心，注册tcp@a和tcp@b，返回注册中心的地址
+func startRegistry(t *testing.T, path string) string {
+	c, err := parseArgs([]string{"-path", path}, new(bytes.Buffer))
+	_assert(err == nil, "parse failed: %v", err)
+	r := registry.New(time.Minute)
+	ts := httptest.NewServer(handler(c, r))
+	t.Cleanup(func() {
+		ts.Close()
+		_ = r.Close()
+	})
+
+	addr := ts.URL + c.path
+	a := registry.HeartbeatInstance(addr, &registry.Instance{Addr: "tcp@a", Services: []string{"Foo@v1"}, Metadata: map[string]string{registry.MetaZone: "z1"}}, time.Minute)
+	b := registry.Heartbeat(addr, "tcp@b", time.Minute, "Foo@v2", "Bar")
+	t.Cleanup(a.Stop)
+	t.Cleanup(b.Stop)
+	return addr
+}
+
+// runAdmin 执行admin子命令，返回退出码和输出
+func runAdmin(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"admin"}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestParseArgs(t *testing.T) {
+	var out bytes.Buffer
+	c, err := parseArgs([]string{"-listen", ":9001", "-path", "/registry/", "-ttl", "1m", "-health-check", "5s"}, &out)
+	_assert(err == nil, "parse failed: %v", err)
+	_assert(c.listen == ":9001" && c.ttl == time.Minute && c.healthCheck == 5*time.Second, "unexpected flags %+v", c)
+	_assert(c.path == "/registry", "trailing slash should be trimmed, but got %q", c.path)
+
+	_assert(run([]string{"-h"}, &out, &out) == 0, "-h should exit with 0")
+	_assert(run([]string{"-ttl", "x"}, &out, &out) == 2, "invalid flag should exit with 2")
+	_assert(run([]string{"extra"}, &out, &out) == 2, "unexpected argument should exit with 2")
+	_assert(strings.Contains(out.String(), "usage: tinyrpc-registry"), "usage should be printed, but got %q", out.String())
+}
+
+func TestHandler(t *testing.T) {
+	for _, path := range []string{"/_tinyrpc_/registry", "/registry/", "/"} {
+		addr := startRegistry(t, path)
+		resp, err := http.Get(addr + "/v1/services")
+		_assert(err == nil && resp.StatusCode == http.StatusOK, "path %q: GET /v1/services failed: %v %v", path, resp, err)
+		_ = resp.Body.Close()
+		resp, err = http.Get(addr)
+		_assert(err == nil && resp.Header.Get("X-Tinyrpc-Servers") == "tcp@a,tcp@b", "path %q: unexpected servers %v %v", path, resp, err)
+		_ = resp.Body.Close()
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	addr := startRegistry(t, "/_tinyrpc_/registry")
+
+	t.Run("list", func(t *testing.T) {
+		code, out, errOut := runAdmin("-registry", addr, "list")
+		_assert(code == 0, "list failed: %d %s", code, errOut)
+		_assert(strings.HasPrefix(out, "ADDR") && strings.Contains(out, "tcp@a") && strings.Contains(out, "zone=z1") && strings.Contains(out, "tcp@b"), "unexpected output %q", out)
+
+		code, out, _ = runAdmin("-registry", addr, "list", "Foo@v2")
+		_assert(code == 0 && strings.Contains(out, "tcp@b") && !strings.Contains(out, "tcp@a"), "expect only tcp@b, but got %d %q", code, out)
+
+		code, out, _ = runAdmin("-registry", addr, "-json", "list", "Bar")
+		var instances []*registry.Instance
+		_assert(code == 0 && json.Unmarshal([]byte(out), &instances) == nil, "expect JSON output, but got %d %q", code, out)
+		_assert(len(instances) == 1 && instances[0].Addr == "tcp@b", "expect only tcp@b, but got %+v", instances)
+
+		code, _, errOut = runAdmin("-registry", addr, "list", "Foo", "Bar")
+		_assert(code == 1 && strings.Contains(errOut, "usage: list"), "expect a usage error, but got %d %q", code, errOut)
+	})
+
+	t.Run("services", func(t *testing.T) {
+		code, out, errOut := runAdmin("-registry", addr, "services")
+		_assert(code == 0, "services failed: %d %s", code, errOut)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		_assert(len(lines) == 3 && strings.HasPrefix(lines[0], "SERVICE"), "expect a header and 2 services, but got %q", out)
+		_assert(strings.Fields(lines[1])[0] == "Bar" && strings.Join(strings.Fields(lines[2]), " ") == "Foo v1,v2 2", "unexpected services %q", out)
+	})
+
+	t.Run("deregister", func(t *testing.T) {
+		code, out, errOut := runAdmin("-registry", addr, "deregister", "tcp@a")
+		_assert(code == 0 && out == "deregistered tcp@a\n", "deregister failed: %d %q %s", code, out, errOut)
+		_, out, _ = runAdmin("-registry", addr, "list")
+		_assert(!strings.Contains(out, "tcp@a") && strings.Contains(out, "tcp@b"), "tcp@a should be removed, but got %q", out)
+
+		code, _, errOut = runAdmin("-registry", addr, "deregister", "tcp@a")
+		_assert(code == 1 && strings.Contains(errOut, "404"), "deregistering twice should fail, but got %d %q", code, errOut)
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		code, _, errOut := runAdmin("-registry", addr)
+		_assert(code == 2 && strings.Contains(errOut, "usage: tinyrpc-registry admin"), "expect usage, but got %d %q", code, errOut)
+		code, _, _ = runAdmin("-registry", addr, "unknown")
+		_assert(code == 2, "unknown command should exit with 2, but got %d", code)
+	})
+}
@@ -0,0 +1,309 @@
+// tinyrpc 调试用的命令行客户端，通过服务端的反射服务列出服务、查看方法的参数描述，并使用JSON参数调用方法
+//
+//	tinyrpc -addr tcp@localhost:9999 list
+//	tinyrpc -addr tcp@localhost:9999 describe Foo.Sum
+//	tinyrpc -registry http://localhost:9000/_tinyrpc_/registry -H trace=1 call Foo.Sum '{"Num1":1,"Num2":2}'
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Asolmn/tinyrpc"
+	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/xclient"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: tinyrpc [flags] <command> [arguments]
+
+commands:
+  list [service]                列出服务，或者服务的所有方法
+  describe service[.method]     以JSON输出方法参数和返回值的类型描述
+  call service.method [json]    使用JSON参数调用方法，参数为"-"或者省略时从标准输入读取
+
+flags:
+`
+
+// metadataFlag 可以重复的-H key=value参数
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string { return fmt.Sprint(map[string]string(m)) }
+
+func (m metadataFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("metadata must be key=value")
+	}
+	m[k] = v
+	return nil
+}
+
+// cli 命令行参数
+type cli struct {
+	addr     string
+	registry string
+	codec    string
+	timeout  time.Duration
+	metadata metadataFlag
+	verbose  bool
+
+	stdin  io.Reader // call的参数为"-"或者省略时从stdin读取
+	stdout io.Writer // 命令的输出
+}
+
+// errUsage 命令行参数错误，已经输出了用法
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run 解析命令行参数并执行命令，返回进程的退出码
+func run(arguments []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c, args, err := parseArgs(arguments, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	c.stdin, c.stdout = stdin, stdout
+	if !c.verbose {
+		logging.SetDefault(logging.Nop())
+	}
+
+	switch args[0] {
+	case "list":
+		err = c.list(args[1:])
+	case "describe":
+		err = c.describe(args[1:])
+	case "call":
+		err = c.call(args[1:])
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "tinyrpc:", err)
+		return 1
+	}
+	return 0
+}
+
+// parseArgs 解析命令行参数，返回命令和命令的参数，参数错误时向output输出用法并返回错误
+func parseArgs(arguments []string, output io.Writer) (*cli, []string, error) {
+	c := &cli{metadata: make(metadataFlag)}
+	fs := flag.NewFlagSet("tinyrpc", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.addr, "addr", "", "服务端地址，格式为protocol@addr，例如tcp@localhost:9999")
+	fs.StringVar(&c.registry, "registry", "", "注册中心地址，多个地址以逗号分隔，没有指定-addr时从注册中心选择服务实例")
+	fs.StringVar(&c.codec, "codec", "json", "编解码方式，json或者gob")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "连接和调用的超时时间")
+	fs.Var(c.metadata, "H", "请求元数据key=value，可以重复")
+	fs.BoolVar(&c.verbose, "v", false, "输出tinyrpc的日志")
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(output, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(arguments); err != nil {
+		return nil, nil, err
+	}
+
+	switch fs.Arg(0) {
+	case "list", "describe", "call":
+		return c, fs.Args(), nil
+	default:
+		fs.Usage()
+		return nil, nil, errUsage
+	}
+}
+
+// list 列出服务名，指定服务时列出服务的所有方法
+func (c *cli) list(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: list [service]")
+	}
+	if len(args) == 0 {
+		var services []string
+		if err := c.invoke("", reflectionMethod("Services"), tinyrpc.ReflectionRequest{}, &services); err != nil {
+			return err
+		}
+		for _, name := range services {
+			_, _ = fmt.Fprintln(c.stdout, name)
+		}
+		return nil
+	}
+
+	schemas, err := c.schemas(args[0], "")
+	if err != nil {
+		return err
+	}
+	for _, m := range schemas[0].Methods {
+		_, _ = fmt.Fprintf(c.stdout, "%s.%s(%s) %s\n", schemas[0].Name, m.Name, m.Arg.Name, m.Reply.Name)
+	}
+	return nil
+}
+
+// describe 以JSON输出服务或者方法的描述
+func (c *cli) describe(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: describe service[.method]")
+	}
+	service, method := splitServiceMethod(args[0])
+	schemas, err := c.schemas(service, method)
+	if err != nil {
+		return err
+	}
+	return c.printJSON(schemas[0])
+}
+
+// call 使用JSON参数调用方法，以JSON输出返回值
+func (c *cli) call(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: call service.method [json]")
+	}
+	service, method := splitServiceMethod(args[0])
+	if method == "" {
+		return errors.New("expect service.method, but got " + args[0])
+	}
+
+	var data []byte
+	if len(args) == 1 || args[1] == "-" {
+		var err error
+		if data, err = io.ReadAll(c.stdin); err != nil {
+			return err
+		}
+	} else {
+		data = []byte(args[1])
+	}
+	if !json.Valid(data) {
+		return errors.New("arguments are not valid JSON")
+	}
+
+	// 服务端使用JSON编解码时，直接发送原始的JSON
+	if c.codecType() == codec.JsonType {
+		var reply json.RawMessage
+		if err := c.invoke(service, args[0], json.RawMessage(data), &reply); err != nil {
+			return err
+		}
+		return c.printJSON(reply)
+	}
+
+	// 其它编解码方式，根据反射服务返回的类型描述构造参数和返回值
+	schemas, err := c.schemas(service, method)
+	if err != nil {
+		return err
+	}
+	m := schemas[0].Methods[0]
+	argv, err := newValue(m.Arg)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, argv.Interface()); err != nil {
+		return err
+	}
+	replyv, err := newValue(m.Reply.Elem)
+	if err != nil {
+		return err
+	}
+	if err = c.invoke(service, args[0], argv.Interface(), replyv.Interface()); err != nil {
+		return err
+	}
+	return c.printJSON(replyv.Interface())
+}
+
+// schemas 通过反射服务获取服务的描述，method不为空时只包含该方法
+func (c *cli) schemas(service, method string) ([]*tinyrpc.ServiceSchema, error) {
+	var schemas []*tinyrpc.ServiceSchema
+	req := tinyrpc.ReflectionRequest{Service: service, Method: method}
+	if err := c.invoke(service, reflectionMethod("Describe"), req, &schemas); err != nil {
+		return nil, err
+	}
+	if len(schemas) != 1 {
+		return nil, errors.New("unexpected reflection reply")
+	}
+	return schemas, nil
+}
+
+// invoke 连接提供service的服务实例并发起调用，service为空表示任意服务实例
+func (c *cli) invoke(service, serviceMethod string, args, reply interface{}) error {
+	addr, err := c.server(service)
+	if err != nil {
+		return err
+	}
+	client, err := tinyrpc.XDial(addr, &tinyrpc.Option{CodecType: c.codecType(), ConnectTimeout: c.timeout})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if len(c.metadata) > 0 {
+		ctx = tinyrpc.WithMetadata(ctx, c.metadata)
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+// server 返回服务端地址，没有指定-addr时从注册中心随机选择一个提供service的服务实例
+func (c *cli) server(service string) (string, error) {
+	if c.addr != "" {
+		return c.addr, nil
+	}
+	if c.registry == "" {
+		return "", errors.New("either -addr or -registry is required")
+	}
+
+	d := xclient.NewTinyRegistryDiscovery(c.registry, 0)
+	var servers []string
+	var err error
+	if service == "" {
+		servers, err = d.GetAll()
+	} else {
+		servers, err = d.GetService(service)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errors.New("no available servers for " + service)
+	}
+	return servers[rand.Intn(len(servers))], nil
+}
+
+// codecType 将-codec参数转换为编解码类型，也可以直接使用完整的类型名
+func (c *cli) codecType() codec.Type {
+	switch c.codec {
+	case "json":
+		return codec.JsonType
+	case "gob":
+		return codec.GobType
+	default:
+		return codec.Type(c.codec)
+	}
+}
+
+// reflectionMethod 返回反射服务的方法名
+func reflectionMethod(method string) string {
+	return tinyrpc.ReflectionServiceName + "." + method
+}
+
+// splitServiceMethod 将service.method拆分为服务名和方法名，没有方法名时method为空
+func splitServiceMethod(serviceMethod string) (service, method string) {
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		return serviceMethod[:dot], serviceMethod[dot+1:]
+	}
+	return serviceMethod, ""
+}
+
+// printJSON 以缩进的JSON格式输出v
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
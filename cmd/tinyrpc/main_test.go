@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Asolmn/tinyrpc"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/registry"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Foo int
+
+type Args struct {
+	Num1, Num2 int
+}
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Metadata 返回请求的元数据
+func (f Foo) Metadata(ctx context.Context, _ int, reply *map[string]string) error {
+	*reply, _ = tinyrpc.MetadataFromContext(ctx)
+	return nil
+}
+
+// startServer 启动一个注册了Foo服务并启用了反射服务的服务端，返回tcp@addr格式的地址
+func startServer(t *testing.T) string {
+	var foo Foo
+	server := tinyrpc.NewServer()
+	_ = server.Register(&foo)
+	server.EnableReflection()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	return "tcp@" + l.Addr().String()
+}
+
+func TestParseArgs(t *testing.T) {
+	var out bytes.Buffer
+	c, args, err := parseArgs([]string{"-addr", "tcp@a:1", "-codec", "gob", "-timeout", "3s", "-H", "a=1", "-H", "b=x=y", "call", "Foo.Sum", "{}"}, &out)
+	_assert(err == nil, "parse failed: %v", err)
+	_assert(c.addr == "tcp@a:1" && c.codecType() == "application/gob" && c.timeout == 3*time.Second, "unexpected flags %+v", c)
+	_assert(len(c.metadata) == 2 && c.metadata["a"] == "1" && c.metadata["b"] == "x=y", "unexpected metadata %v", c.metadata)
+	_assert(strings.Join(args, " ") == "call Foo.Sum {}", "unexpected arguments %v", args)
+
+	c, _, err = parseArgs([]string{"list"}, &out)
+	_assert(err == nil && c.codecType() == "application/json" && c.timeout == 10*time.Second, "unexpected defaults %+v %v", c, err)
+
+	for _, arguments := range [][]string{nil, {"nope"}, {"-H", "novalue", "list"}, {"-timeout", "x", "list"}} {
+		out.Reset()
+		_, _, err = parseArgs(arguments, &out)
+		_assert(err != nil && out.Len() > 0, "%v: expect an error with usage, but got %v", arguments, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	addr := startServer(t)
+	exec := func(stdin string, arguments ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(arguments, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := exec("", "-addr", addr, "list")
+	_assert(code == 0 && strings.Contains(out, "Foo\n"), "list: unexpected output %d %q", code, out)
+	// 没有-v时不输出tinyrpc的日志
+	_assert(logging.Default() == logging.Nop(), "library logs should be discarded without -v")
+	code, out, _ = exec("", "-addr", addr, "list", "Foo")
+	_assert(code == 0 && strings.Contains(out, "Foo.Sum(main.Args) *int"), "list Foo: unexpected output %d %q", code, out)
+	code, out, _ = exec("", "-addr", addr, "describe", "Foo.Sum")
+	_assert(code == 0 && strings.Contains(out, `"name": "Sum"`), "describe: unexpected output %d %q", code, out)
+
+	for _, c := range []string{"json", "gob"} {
+		code, out, _ = exec("", "-addr", addr, "-codec", c, "call", "Foo.Sum", `{"Num1":1,"Num2":2}`)
+		_assert(code == 0 && out == "3\n", "call with %s: unexpected output %d %q", c, code, out)
+		code, out, _ = exec(`{"Num1":3,"Num2":4}`, "-addr", addr, "-codec", c, "call", "Foo.Sum")
+		_assert(code == 0 && out == "7\n", "call with %s from stdin: unexpected output %d %q", c, code, out)
+	}
+	code, out, _ = exec("", "-addr", addr, "-H", "trace=1", "call", "Foo.Metadata", "0")
+	_assert(code == 0 && strings.Contains(out, `"trace": "1"`), "metadata: unexpected output %d %q", code, out)
+
+	// 通过注册中心选择服务实例
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	reg := ts.URL + "/_tinyrpc_/registry"
+	registry.Heartbeat(reg, addr, time.Minute, "Foo")
+	code, out, _ = exec("", "-registry", reg, "call", "Foo.Sum", `{"Num1":5,"Num2":5}`)
+	_assert(code == 0 && out == "10\n", "call through registry: unexpected output %d %q", code, out)
+
+	code, _, errOut := exec("", "-addr", addr, "call", "Foo.Nope", "{}")
+	_assert(code == 1 && strings.HasPrefix(errOut, "tinyrpc: "), "unknown method: unexpected result %d %q", code, errOut)
+	code, _, errOut = exec("", "-addr", addr, "call", "Foo.Sum", "{")
+	_assert(code == 1 && strings.Contains(errOut, "not valid JSON"), "invalid JSON: unexpected result %d %q", code, errOut)
+	code, _, _ = exec("", "-addr", addr, "nope")
+	_assert(code == 2, "unknown command should exit with 2, but got %d", code)
+}
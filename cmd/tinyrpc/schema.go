@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"github.com/Asolmn/tinyrpc"
+	"reflect"
+)
+
+// basicTypes 按reflect.Kind的名称索引的基本类型
+var basicTypes = make(map[string]reflect.Type)
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+	} {
+		t := reflect.TypeOf(v)
+		basicTypes[t.Kind().String()] = t
+	}
+}
+
+// newValue 根据类型描述创建一个指向零值的指针，用于JSON和gob的编解码
+func newValue(schema *tinyrpc.TypeSchema) (reflect.Value, error) {
+	if schema == nil {
+		return reflect.Value{}, errors.New("missing type schema")
+	}
+	t, err := typeOf(schema)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if t == nil {
+		return reflect.Value{}, errors.New("unsupported recursive type " + schema.Name)
+	}
+	return reflect.New(t), nil
+}
+
+// typeOf 根据类型描述构造结构相同的类型，gob按字段名编解码，不需要原来的类型名
+// 递归引用的结构体返回nil，所在的字段被忽略；嵌入字段作为普通字段，JSON中需要以类型名嵌套
+func typeOf(schema *tinyrpc.TypeSchema) (reflect.Type, error) {
+	if t, ok := basicTypes[schema.Kind]; ok {
+		return t, nil
+	}
+
+	switch schema.Kind {
+	case reflect.Ptr.String(), reflect.Slice.String(), reflect.Array.String():
+		elem, err := typeOf(schema.Elem)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		switch schema.Kind {
+		case reflect.Ptr.String():
+			return reflect.PtrTo(elem), nil
+		case reflect.Slice.String():
+			return reflect.SliceOf(elem), nil
+		default:
+			return reflect.ArrayOf(schema.Len, elem), nil
+		}
+	case reflect.Map.String():
+		key, err := typeOf(schema.Key)
+		if key == nil || err != nil {
+			return nil, err
+		}
+		elem, err := typeOf(schema.Elem)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case reflect.Struct.String():
+		if schema.Recursive {
+			return nil, nil
+		}
+		fields := make([]reflect.StructField, 0, len(schema.Fields))
+		for _, f := range schema.Fields {
+			t, err := typeOf(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				continue
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: t, Tag: reflect.StructTag(f.Tag)})
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, errors.New("unsupported type " + schema.Name + " of kind " + schema.Kind)
+}
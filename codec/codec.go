@@ -1,12 +1,18 @@
 package codec
 
-import "io"
+import (
+	"github.com/Asolmn/tinyrpc/logging"
+	"io"
+)
 
 // 头部信息
 type Header struct {
 	ServiceMethod string // 服务名和方法名
 	Seq           uint64 // 请求序列号
 	Error         string
+	// Metadata 请求的元数据，由调用方通过WithMetadata设置，响应中为空
+	// 为空时gob不编码该字段的值，json省略该字段；旧版本解码时忽略该字段，因此新旧版本的客户端和服务端可以互通
+	Metadata map[string]string `json:",omitempty"`
 }
 
 // 编解码器的接口，抽象出接口实现不同的编解码器实例
@@ -17,6 +23,31 @@ type Codec interface {
 	Write(*Header, interface{}) error // 将rpc响应写入连接
 }
 
+// ByteCounter 编解码器可选实现的接口，返回已经从连接读取和写入连接的字节数
+// 读取的字节数只包含已经解码的消息，不包含预读的数据，用于统计请求和响应的大小
+type ByteCounter interface {
+	BytesRead() int64
+	BytesWritten() int64
+}
+
+// LoggerSetter 编解码器可选实现的接口，设置输出日志使用的Logger，为nil时使用logging.Default()
+// 客户端和服务端创建编解码器后传入各自配置的Logger
+type LoggerSetter interface {
+	SetLogger(logging.Logger)
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // 抽象出Codec的构造函数,客户端和服务端可以通过Codec的Type获取构造函数，从而创建Codec实例
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
@@ -34,4 +65,5 @@ func init() {
 	// 根据Type的不同设置对应的实例
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
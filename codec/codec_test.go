@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// legacyHeader 增加Metadata之前的头部
+type legacyHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+func TestHeader_Compatible(t *testing.T) {
+	md := map[string]string{"k": "v"}
+
+	t.Run("gob", func(t *testing.T) {
+		// 新版本发送给旧版本，旧版本忽略元数据
+		var buf bytes.Buffer
+		_ = gob.NewEncoder(&buf).Encode(&Header{ServiceMethod: "Foo.Sum", Seq: 1, Metadata: md})
+		var old legacyHeader
+		err := gob.NewDecoder(&buf).Decode(&old)
+		_assert(err == nil && old.ServiceMethod == "Foo.Sum" && old.Seq == 1, "legacy decode failed: %+v %v", old, err)
+
+		// 旧版本发送给新版本，元数据为空
+		buf.Reset()
+		_ = gob.NewEncoder(&buf).Encode(&legacyHeader{ServiceMethod: "Foo.Sum", Seq: 2})
+		var h Header
+		err = gob.NewDecoder(&buf).Decode(&h)
+		_assert(err == nil && h.Seq == 2 && h.Metadata == nil, "decode legacy header failed: %+v %v", h, err)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		// 没有元数据时与旧版本的头部完全相同
+		data, _ := json.Marshal(&Header{ServiceMethod: "Foo.Sum", Seq: 1})
+		legacy, _ := json.Marshal(&legacyHeader{ServiceMethod: "Foo.Sum", Seq: 1})
+		_assert(bytes.Equal(data, legacy), "expect %s, but got %s", legacy, data)
+
+		data, _ = json.Marshal(&Header{ServiceMethod: "Foo.Sum", Seq: 1, Metadata: md})
+		var h Header
+		err := json.Unmarshal(data, &h)
+		_assert(err == nil && h.Metadata["k"] == "v", "metadata should round trip, but got %+v %v", h, err)
+	})
+}
@@ -3,20 +3,44 @@ package codec
 import (
 	"bufio"
 	"encoding/gob"
+	"github.com/Asolmn/tinyrpc/logging"
 	"io"
-	"log"
 )
 
 // Gob类型的编解码器
 type GobCodec struct {
-	conn io.ReadWriteCloser // 链接实例
-	buf  *bufio.Writer      // 缓冲Writer
-	dec  *gob.Decoder       // 反序列化
-	enc  *gob.Encoder       // 序列化
+	conn    io.ReadWriteCloser // 链接实例
+	buf     *bufio.Writer      // 缓冲Writer
+	dec     *gob.Decoder       // 反序列化
+	enc     *gob.Encoder       // 序列化
+	read    *countingReader    // 统计解码器读取的字节数
+	written *countingWriter    // 统计编码器写入的字节数
+	logger  logging.Logger     // 输出日志使用的Logger，为nil时使用logging.Default()
+}
+
+// countingReader 统计读取的字节数，实现io.ByteReader，gob不会再额外包装一层缓冲
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
 }
 
 // 检查GobCodec实例是否具有Codec接口的所有方法
 var _ Codec = (*GobCodec)(nil)
+var _ LoggerSetter = (*GobCodec)(nil)
 
 // 通过构建函数传入conn
 // 通常是通过TCP或者Unix建立socket得到的链接实例
@@ -24,14 +48,27 @@ var _ Codec = (*GobCodec)(nil)
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	// 创建一个缓冲写入器，写入目标为conn
 	buf := bufio.NewWriter(conn)
+	read := &countingReader{r: bufio.NewReader(conn)}
+	written := &countingWriter{w: buf}
 	return &GobCodec{
-		conn: conn,
-		buf:  buf,
-		dec:  gob.NewDecoder(conn), // 创建新解码器
-		enc:  gob.NewEncoder(buf),  // 创建新编码器
+		conn:    conn,
+		buf:     buf,
+		dec:     gob.NewDecoder(read),    // 创建新解码器
+		enc:     gob.NewEncoder(written), // 创建新编码器
+		read:    read,
+		written: written,
 	}
 }
 
+// BytesRead 返回解码器已经读取的字节数
+func (c *GobCodec) BytesRead() int64 { return c.read.n }
+
+// BytesWritten 返回编码器已经写入的字节数
+func (c *GobCodec) BytesWritten() int64 { return c.written.n }
+
+// SetLogger 设置输出日志使用的Logger，需要在读写之前调用
+func (c *GobCodec) SetLogger(l logging.Logger) { c.logger = l }
+
 // 读取rpc请求的头部信息
 func (c *GobCodec) ReadHeader(h *Header) error {
 	return c.dec.Decode(h) // 反序列化头部信息
@@ -56,14 +93,14 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 	// 序列号头部
 	// Encode(h)，对头部信息进行Gob编码
 	if err := c.enc.Encode(h); err != nil {
-		log.Println("rpc codec: gob error encoding header:", err)
+		logging.Or(c.logger).Error("rpc codec: gob error encoding header", "err", err)
 		return err
 	}
 
 	// 序列号主体
 	// Encode(body)，对主体信息进行Gob编码
 	if err := c.enc.Encode(body); err != nil {
-		log.Println("rpc codec: gob error encoding body:", err)
+		logging.Or(c.logger).Error("rpc codec: gob error encoding body", "err", err)
 		return err
 	}
 	return nil
@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/Asolmn/tinyrpc/logging"
+	"io"
+)
+
+// JSON类型的编解码器，便于其它语言和命令行工具在不知道Go类型的情况下调用
+type JsonCodec struct {
+	conn    io.ReadWriteCloser // 链接实例
+	buf     *bufio.Writer      // 缓冲Writer
+	dec     *json.Decoder      // 反序列化
+	enc     *json.Encoder      // 序列化
+	written *countingWriter    // 统计编码器写入的字节数
+	logger  logging.Logger     // 输出日志使用的Logger，为nil时使用logging.Default()
+}
+
+// 检查JsonCodec实例是否具有Codec接口的所有方法
+var _ Codec = (*JsonCodec)(nil)
+var _ LoggerSetter = (*JsonCodec)(nil)
+
+// 通过构建函数传入conn，返回一个新的JsonCodec实例
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	written := &countingWriter{w: buf}
+	return &JsonCodec{
+		conn:    conn,
+		buf:     buf,
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(written),
+		written: written,
+	}
+}
+
+// BytesRead 返回解码器已经解码的字节数，不包含预读的数据
+func (c *JsonCodec) BytesRead() int64 { return c.dec.InputOffset() }
+
+// BytesWritten 返回编码器已经写入的字节数
+func (c *JsonCodec) BytesWritten() int64 { return c.written.n }
+
+// SetLogger 设置输出日志使用的Logger，需要在读写之前调用
+func (c *JsonCodec) SetLogger(l logging.Logger) { c.logger = l }
+
+// 读取rpc请求的头部信息
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// 读取rpc请求的主体信息，body为nil时丢弃主体
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+// 将rpc响应写入连接
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+
+	if err := c.enc.Encode(h); err != nil {
+		logging.Or(c.logger).Error("rpc codec: json error encoding header", "err", err)
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		logging.Or(c.logger).Error("rpc codec: json error encoding body", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 关闭编解码器
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
@@ -1,28 +1,53 @@
 package tinyrpc
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/Asolmn/tinyrpc/codec"
 	"html/template"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 )
 
 const debugText = `<html>
 	<body>
-	<title>GeeRPC Services</title>
-	{{range .}}
+	<title>tinyrpc Services</title>
+	Started {{.StartTime.Format "2006-01-02 15:04:05"}}, up {{.Uptime}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>In flight</th><th align=center>Mean latency</th><th align=center>Max latency</th>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{.Name}}({{.ArgType}}, {{.ReplyType}}) error</td>
+			<td align=center>{{.Calls}}</td>
+			<td align=center>{{.Errors}}</td>
+			<td align=center>{{.InFlight}}</td>
+			<td align=center>{{.MeanLatency}}</td>
+			<td align=center>{{.MaxLatency}}</td>
 			</tr>
 		{{end}}
 		</table>
 	{{end}}
+	<hr>
+	Connections
+	<hr>
+		<table>
+		<th align=center>Remote address</th><th align=center>Codec</th><th align=center>Connected at</th><th align=center>Requests</th><th align=center>In flight</th>
+		{{range .Connections}}
+			<tr>
+			<td align=left font=fixed>{{.RemoteAddr}}</td>
+			<td align=center>{{.Codec}}</td>
+			<td align=center>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}</td>
+			<td align=center>{{.Requests}}</td>
+			<td align=center>{{.InFlight}}</td>
+			</tr>
+		{{end}}
+		</table>
 	</body>
 	</html>`
 
@@ -32,24 +57,98 @@ type debugHTTP struct {
 	*Server
 }
 
+// debugInfo 调试页面的数据，时间的单位为纳秒
+type debugInfo struct {
+	StartTime   time.Time      `json:"start_time"`
+	Uptime      time.Duration  `json:"uptime"`
+	Services    []debugService `json:"services"`
+	Connections []debugConn    `json:"connections"`
+}
+
 type debugService struct {
-	Name   string
-	Method map[string]*methodType
+	Name    string        `json:"name"`
+	Methods []debugMethod `json:"methods"`
 }
 
-// Runs at /debug/geerpc
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// Build a sorted version of the data.
-	var services []debugService
+// debugMethod 方法的调用统计，延迟为服务方法的执行时间
+type debugMethod struct {
+	Name        string        `json:"name"`
+	ArgType     string        `json:"arg_type"`
+	ReplyType   string        `json:"reply_type"`
+	Calls       uint64        `json:"calls"`
+	Errors      uint64        `json:"errors"`
+	InFlight    int64         `json:"in_flight"`
+	MeanLatency time.Duration `json:"mean_latency"`
+	MaxLatency  time.Duration `json:"max_latency"`
+}
+
+// debugConn 正在服务的连接
+type debugConn struct {
+	RemoteAddr  string     `json:"remote_addr"`
+	Codec       codec.Type `json:"codec"`
+	ConnectedAt time.Time  `json:"connected_at"`
+	Requests    uint64     `json:"requests"`
+	InFlight    int        `json:"in_flight"`
+}
+
+// debugInfo 收集服务、方法和连接的统计，按名称和连接时间排序
+func (server *Server) debugInfo() *debugInfo {
+	info := &debugInfo{
+		StartTime:   server.started,
+		Uptime:      time.Since(server.started).Round(time.Second),
+		Services:    []debugService{},
+		Connections: []debugConn{},
+	}
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
 		svc := svci.(*service)
-		services = append(services, debugService{
-			Name:   namei.(string),
-			Method: svc.method,
-		})
+		ds := debugService{Name: namei.(string), Methods: []debugMethod{}}
+		for name, mtype := range svc.method {
+			ds.Methods = append(ds.Methods, debugMethod{
+				Name:        name,
+				ArgType:     mtype.ArgType.String(),
+				ReplyType:   mtype.ReplyType.String(),
+				Calls:       mtype.NumCalls(),
+				Errors:      mtype.NumErrors(),
+				InFlight:    mtype.InFlight(),
+				MeanLatency: mtype.MeanLatency(),
+				MaxLatency:  mtype.MaxLatency(),
+			})
+		}
+		sort.Slice(ds.Methods, func(i, j int) bool { return ds.Methods[i].Name < ds.Methods[j].Name })
+		info.Services = append(info.Services, ds)
 		return true
 	})
-	err := debug.Execute(w, services)
+	sort.Slice(info.Services, func(i, j int) bool { return info.Services[i].Name < info.Services[j].Name })
+
+	server.mu.Lock()
+	for sc := range server.conns {
+		sc.mu.Lock()
+		info.Connections = append(info.Connections, debugConn{
+			RemoteAddr:  sc.remoteAddr,
+			Codec:       sc.codec,
+			ConnectedAt: sc.since,
+			Requests:    sc.requests,
+			InFlight:    sc.active,
+		})
+		sc.mu.Unlock()
+	}
+	server.mu.Unlock()
+	sort.Slice(info.Connections, func(i, j int) bool {
+		return info.Connections[i].ConnectedAt.Before(info.Connections[j].ConnectedAt)
+	})
+	return info
+}
+
+// Runs at /debug/tinyrpc
+// 请求带有?format=json或者Accept为application/json时返回JSON
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info := server.debugInfo()
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+		return
+	}
+	err := debug.Execute(w, info)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
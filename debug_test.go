@@ -0,0 +1,53 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugHTTP(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+	var tree Tree
+	_ = server.Register(&tree)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call(context.Background(), "Slow.Sleep", 20, &reply) == nil, "call failed")
+	call := client.Go("Slow.Sleep", 500, &reply, make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+
+	for _, req := range []struct{ url, accept string }{
+		{"/debug/tinyrpc?format=json", ""},
+		{"/debug/tinyrpc", "application/json"},
+	} {
+		r := httptest.NewRequest("GET", req.url, nil)
+		r.Header.Set("Accept", req.accept)
+		rec := httptest.NewRecorder()
+		debugHTTP{server}.ServeHTTP(rec, r)
+		_assert(rec.Header().Get("Content-Type") == "application/json", "expect json response")
+
+		var info debugInfo
+		_assert(json.NewDecoder(rec.Body).Decode(&info) == nil, "invalid json")
+		_assert(len(info.Services) == 2 && info.Services[0].Name == "Slow", "unexpected services %+v", info.Services)
+		m := info.Services[0].Methods[0]
+		_assert(m.Name == "Sleep" && m.ArgType == "int" && m.ReplyType == "*int", "unexpected method %+v", m)
+		_assert(m.Calls == 2 && m.InFlight == 1 && m.Errors == 0, "unexpected stats %+v", m)
+		_assert(m.MeanLatency >= 20*time.Millisecond && m.MaxLatency >= m.MeanLatency, "unexpected latency %+v", m)
+		_assert(len(info.Connections) == 1, "expect 1 connection, but got %d", len(info.Connections))
+		c := info.Connections[0]
+		_assert(c.Codec == DefaultOption.CodecType && c.Requests == 2 && c.InFlight == 1 && c.RemoteAddr != "", "unexpected connection %+v", c)
+	}
+
+	rec := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tinyrpc", nil))
+	body := rec.Body.String()
+	_assert(strings.Contains(body, "tinyrpc Services") && strings.Contains(body, "Sleep(int, *int) error"), "unexpected html")
+	<-call.Done
+}
@@ -0,0 +1,185 @@
+package tinyrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// ServingStatus 服务的健康状态
+type ServingStatus int
+
+const (
+	StatusUnknown        ServingStatus = iota // 未知状态
+	StatusServing                             // 正常提供服务
+	StatusNotServing                          // 暂停提供服务，例如正在关闭
+	StatusServiceUnknown                      // 服务器上没有该服务
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	case StatusServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthServiceName 健康检查服务的服务名
+const HealthServiceName = "Health"
+
+// HealthCheckRequest 健康检查请求，Service为空表示检查整个服务器
+type HealthCheckRequest struct {
+	Service string
+}
+
+// HealthCheckResponse 健康检查响应
+type HealthCheckResponse struct {
+	Status ServingStatus
+}
+
+// HealthWatchRequest 等待服务的健康状态变化
+// 状态与Status不同时立即返回，否则等待状态发生变化或者等待Wait之后返回当前状态
+type HealthWatchRequest struct {
+	Service string
+	Status  ServingStatus // 调用方已知的状态
+	Wait    time.Duration // 最长等待时间，为0或者超过maxHealthWatchWait时使用maxHealthWatchWait
+}
+
+// maxHealthWatchWait Watch的最长等待时间
+const maxHealthWatchWait = time.Second * 30
+
+// Health 内置的健康检查服务，通过Server.EnableHealth注册到服务器
+// 没有设置状态的服务，注册在服务器上时为SERVING，否则为SERVICE_UNKNOWN
+// 服务器关闭时所有服务变为NOT_SERVING，之后的设置将被忽略
+type Health struct {
+	server   *Server
+	mu       sync.Mutex
+	statuses map[string]ServingStatus // 应用设置的服务状态，空字符串表示整个服务器
+	shutdown bool                     // 服务器正在关闭
+	changed  chan struct{}            // 状态发生变化时关闭，并替换为新的信道
+}
+
+// newHealth 创建服务器的健康检查服务
+func newHealth(server *Server) *Health {
+	return &Health{
+		server:   server,
+		statuses: make(map[string]ServingStatus),
+		changed:  make(chan struct{}),
+	}
+}
+
+// SetServingStatus 设置服务的健康状态，service为空表示整个服务器
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return
+	}
+	h.statuses[service] = status
+	h.notify()
+}
+
+// Shutdown 将所有服务设置为NOT_SERVING，并忽略之后的设置，服务器关闭时自动调用
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = true
+	h.notify()
+}
+
+// Resume 恢复Shutdown之前的状态
+func (h *Health) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = false
+	h.notify()
+}
+
+// notify 通知正在等待的Watch，调用方需要持有锁
+func (h *Health) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// status 返回服务当前的健康状态，调用方需要持有锁
+func (h *Health) status(service string) ServingStatus {
+	if h.shutdown {
+		return StatusNotServing
+	}
+	if status, ok := h.statuses[service]; ok {
+		return status
+	}
+	if service == "" {
+		return StatusServing
+	}
+	if _, ok := h.server.serviceMap.Load(service); ok {
+		return StatusServing
+	}
+	return StatusServiceUnknown
+}
+
+// Check 返回服务当前的健康状态
+func (h *Health) Check(req HealthCheckRequest, reply *HealthCheckResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reply.Status = h.status(req.Service)
+	return nil
+}
+
+// Watch 等待服务的健康状态与req.Status不同，或者等待超时后返回当前状态
+func (h *Health) Watch(req HealthWatchRequest, reply *HealthCheckResponse) error {
+	wait := req.Wait
+	if wait <= 0 || wait > maxHealthWatchWait {
+		wait = maxHealthWatchWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		h.mu.Lock()
+		status, changed, shutdown := h.status(req.Service), h.changed, h.shutdown
+		h.mu.Unlock()
+
+		// 服务器正在关闭时立即返回，避免阻塞关闭
+		if status != req.Status || shutdown {
+			reply.Status = status
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			reply.Status = status
+			return nil
+		}
+	}
+}
+
+// EnableHealth 在服务器上注册内置的健康检查服务，重复调用返回同一个实例
+// 服务器关闭时，健康检查服务在关闭监听器之前变为NOT_SERVING
+func (server *Server) EnableHealth() *Health {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.health == nil {
+		server.health = newHealth(server)
+		s, _ := newService(server.health) // Health是导出类型，不会返回错误
+		server.serviceMap.Store(s.name, s)
+	}
+	return server.health
+}
+
+// Health 返回服务器的健康检查服务，没有调用EnableHealth时返回nil
+func (server *Server) Health() *Health {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.health
+}
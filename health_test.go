@@ -0,0 +1,84 @@
+package tinyrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func checkHealth(client *Client, service string) ServingStatus {
+	var reply HealthCheckResponse
+	err := client.Call(context.Background(), "Health.Check", HealthCheckRequest{Service: service}, &reply)
+	_assert(err == nil, "health check failed: %v", err)
+	return reply.Status
+}
+
+func TestHealth_Check(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+	health := server.EnableHealth()
+	_assert(server.EnableHealth() == health, "EnableHealth should return the same instance")
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	_assert(checkHealth(client, "") == StatusServing, "server should be serving")
+	_assert(checkHealth(client, "Slow") == StatusServing, "registered service should be serving")
+	_assert(checkHealth(client, "Foo") == StatusServiceUnknown, "unregistered service should be unknown")
+
+	health.SetServingStatus("Slow", StatusNotServing)
+	_assert(checkHealth(client, "Slow") == StatusNotServing, "status should be set by application")
+	_assert(checkHealth(client, "") == StatusServing, "server status should not change")
+
+	// Watch在状态变化时返回
+	watch := client.Go("Health.Watch", HealthWatchRequest{Service: "Slow", Status: StatusNotServing}, new(HealthCheckResponse), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-watch.Done:
+		t.Fatal("watch should block until the status changes")
+	default:
+	}
+	health.SetServingStatus("Slow", StatusServing)
+	<-watch.Done
+	_assert(watch.Error == nil, "watch failed: %v", watch.Error)
+	_assert(watch.Reply.(*HealthCheckResponse).Status == StatusServing, "watch should return the new status")
+
+	// 等待超时后返回当前状态
+	var reply HealthCheckResponse
+	err = client.Call(context.Background(), "Health.Watch", HealthWatchRequest{Service: "Slow", Status: StatusServing, Wait: 50 * time.Millisecond}, &reply)
+	_assert(err == nil && reply.Status == StatusServing, "watch should return the current status after wait")
+}
+
+func TestHealth_Shutdown(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+	server.EnableHealth()
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	watch := client.Go("Health.Watch", HealthWatchRequest{Status: StatusServing}, new(HealthCheckResponse), make(chan *Call, 1))
+	call := client.Go("Slow.Sleep", 300, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+
+	// 关闭时Watch立即返回NOT_SERVING
+	<-watch.Done
+	_assert(watch.Error == nil, "watch failed: %v", watch.Error)
+	_assert(watch.Reply.(*HealthCheckResponse).Status == StatusNotServing, "watch should return NOT_SERVING")
+
+	// 关闭期间，已有连接上仍然可以检查健康状态，其它请求被拒绝
+	_assert(checkHealth(client, "Slow") == StatusNotServing, "service should be NOT_SERVING during shutdown")
+	server.Health().SetServingStatus("Slow", StatusServing)
+	_assert(checkHealth(client, "Slow") == StatusNotServing, "status should not change after shutdown")
+	err = client.Call(context.Background(), "Slow.Sleep", 1, new(int))
+	_assert(err != nil, "new requests should be rejected during shutdown")
+
+	<-call.Done
+	_assert(call.Error == nil, "in-flight call should succeed: %v", call.Error)
+	_assert(<-done == nil, "shutdown failed")
+}
@@ -0,0 +1,166 @@
+// Package logging 可替换的结构化日志
+// tinyrpc的服务端、客户端、编解码器和注册中心都通过Logger输出日志，方法签名与log/slog.Logger相同，
+// *slog.Logger可以直接作为Logger使用，也可以接入其他日志库
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Logger 分级别的结构化日志，args为交替出现的键和值，例如Warn("rpc server: read body error", "err", err)
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Level 日志级别，取值与log/slog.Level相同
+type Level int
+
+const (
+	LevelDebug Level = -4 // 调试信息，例如每次心跳、注册的每个方法
+	LevelInfo  Level = 0  // 正常的运行信息
+	LevelWarn  Level = 4  // 可以恢复的错误，例如客户端发送了错误的请求
+	LevelError Level = 8  // 需要关注的错误
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// ParseLevel 解析日志级别的名称，不区分大小写，例如debug、INFO
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	}
+	return 0, errors.New("logging: unknown level " + s)
+}
+
+// stdLogger 通过log.Logger输出的Logger，每条日志一行，键值对以key=value的格式追加在消息之后
+type stdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+// New 返回输出到w的Logger，低于level的日志被丢弃
+func New(w io.Writer, level Level) Logger {
+	return &stdLogger{l: log.New(w, "", log.LstdFlags), level: level}
+}
+
+// Std 返回通过log包的标准Logger输出的Logger，输出目标和格式跟随log.SetOutput和log.SetFlags
+func Std(level Level) Logger {
+	return &stdLogger{l: log.Default(), level: level}
+}
+
+func (s *stdLogger) Debug(msg string, args ...any) { s.log(LevelDebug, msg, args) }
+func (s *stdLogger) Info(msg string, args ...any)  { s.log(LevelInfo, msg, args) }
+func (s *stdLogger) Warn(msg string, args ...any)  { s.log(LevelWarn, msg, args) }
+func (s *stdLogger) Error(msg string, args ...any) { s.log(LevelError, msg, args) }
+
+func (s *stdLogger) log(level Level, msg string, args []any) {
+	if level < s.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for len(args) > 0 {
+		// 与slog相同，不是字符串的键以及最后缺少值的键使用!BADKEY
+		key, value := "!BADKEY", args[0]
+		if k, ok := args[0].(string); ok && len(args) > 1 {
+			key, value = k, args[1]
+			args = args[2:]
+		} else {
+			args = args[1:]
+		}
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(formatValue(value))
+	}
+	_ = s.l.Output(3, b.String())
+}
+
+// formatValue 格式化键值对中的值，包含空白、引号或者等号的值加上引号
+func formatValue(v any) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// nopLogger 丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// Nop 返回丢弃所有日志的Logger，用于在测试中关闭日志
+func Nop() Logger { return nopLogger{} }
+
+var (
+	mu            sync.RWMutex
+	defaultLogger = Std(LevelInfo)
+)
+
+// Default 返回默认的Logger，没有单独设置Logger的组件使用它输出日志
+// 初始值通过log包的标准Logger输出Info及以上级别的日志
+func Default() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return defaultLogger
+}
+
+// SetDefault 设置默认的Logger，l为nil时恢复初始值
+func SetDefault(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l == nil {
+		l = Std(LevelInfo)
+	}
+	defaultLogger = l
+}
+
+// Or 返回l，l为nil时返回默认的Logger
+func Or(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	return Default()
+}
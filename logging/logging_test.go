@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo)
+	l.Debug("dropped")
+	l.Info("rpc server: register", "service", "Foo", "method", "Sum")
+	l.Warn("rpc server: read body error", "err", errors.New("unexpected EOF"), "empty", "")
+	l.Error("odd", "key", 1, "dangling")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	_assert(len(lines) == 3, "expect 3 lines, but got %d: %q", len(lines), buf.String())
+	_assert(strings.HasSuffix(lines[0], `INFO rpc server: register service=Foo method=Sum`), "unexpected line %q", lines[0])
+	_assert(strings.HasSuffix(lines[1], `WARN rpc server: read body error err="unexpected EOF" empty=""`), "unexpected line %q", lines[1])
+	_assert(strings.HasSuffix(lines[2], `ERROR odd key=1 !BADKEY=dangling`), "unexpected line %q", lines[2])
+}
+
+func TestDefault(t *testing.T) {
+	defer SetDefault(nil)
+
+	var buf bytes.Buffer
+	l := New(&buf, LevelDebug)
+	SetDefault(l)
+	_assert(Default() == l && Or(nil) == l, "default logger should be replaced")
+	_assert(Or(Nop()) != l, "non-nil logger should be preferred")
+	Or(nil).Debug("hello")
+	_assert(strings.Contains(buf.String(), "DEBUG hello"), "default logger should be used")
+
+	SetDefault(nil)
+	_assert(Default() != l, "nil should restore the standard logger")
+
+	level, err := ParseLevel("warn")
+	_assert(err == nil && level == LevelWarn && level.String() == "WARN", "unexpected level %v", level)
+	_, err = ParseLevel("verbose")
+	_assert(err != nil, "expect an error for unknown level")
+}
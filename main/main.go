@@ -47,8 +47,9 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	// 向服务端注册服务
 	_ = server.Register(&foo)
 
-	// 服务端向注册中心发送心跳
-	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	// 服务端向注册中心发送心跳，关闭时从注册中心注销
+	hb := registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0, server.Services()...)
+	server.RegisterOnShutdown(func() { _ = hb.Deregister() })
 	wg.Done()
 	server.Accept(l)
 
@@ -0,0 +1,35 @@
+package tinyrpc
+
+import "context"
+
+// outgoingMetadataKey 调用方在context中设置的元数据，随请求头发送
+type outgoingMetadataKey struct{}
+
+// incomingMetadataKey 服务端从请求头中读取的元数据
+type incomingMetadataKey struct{}
+
+// WithMetadata 返回附带了元数据的context，使用该context发起的调用会在请求头中携带元数据
+// 与ctx中已有的元数据合并，相同的键使用md中的值
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range outgoingMetadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+// outgoingMetadata 返回调用方设置的元数据，不能修改返回值
+func outgoingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(map[string]string)
+	return md
+}
+
+// MetadataFromContext 返回服务端收到的请求元数据，用于第一个参数为context.Context的服务方法
+// 不会转发到服务方法发起的调用中，需要转发时使用WithMetadata
+func MetadataFromContext(ctx context.Context) (map[string]string, bool) {
+	md, ok := ctx.Value(incomingMetadataKey{}).(map[string]string)
+	return md, ok
+}
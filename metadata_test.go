@@ -0,0 +1,71 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Asolmn/tinyrpc/codec"
+	"net"
+	"testing"
+)
+
+type Echo int
+
+// Metadata 返回请求的元数据
+func (e Echo) Metadata(ctx context.Context, _ int, reply *map[string]string) error {
+	md, _ := MetadataFromContext(ctx)
+	*reply = md
+	return nil
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+	var e Echo
+	server := NewServer()
+	_ = server.Register(&e)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", l.Addr().String(), &Option{CodecType: typ})
+		_assert(err == nil, "failed to dial: %v", err)
+
+		ctx := WithMetadata(context.Background(), map[string]string{"a": "1", "b": "2"})
+		ctx = WithMetadata(ctx, map[string]string{"b": "3"})
+		var md map[string]string
+		err = client.Call(ctx, "Echo.Metadata", 0, &md)
+		_assert(err == nil, "%s: call failed: %v", typ, err)
+		_assert(len(md) == 2 && md["a"] == "1" && md["b"] == "3", "%s: unexpected metadata %v", typ, md)
+
+		md = nil
+		err = client.Call(context.Background(), "Echo.Metadata", 0, &md)
+		_assert(err == nil && len(md) == 0, "%s: metadata should not leak into other calls", typ)
+
+		// 找不到服务时请求体被丢弃，连接仍然可用
+		err = client.Call(context.Background(), "Nope.Foo", Args{Num1: 1}, nil)
+		_assert(err != nil, "%s: call unknown service should fail", typ)
+		_assert(client.Call(ctx, "Echo.Metadata", 0, &md) == nil, "%s: connection should still work", typ)
+		_ = client.Close()
+	}
+}
+
+func TestJsonCodec_RawMessage(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// JSON编解码时，调用方不需要Go类型
+	client, err := Dial("tcp", l.Addr().String(), &Option{CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply json.RawMessage
+	err = client.Call(context.Background(), "Foo.Sum", json.RawMessage(`{"Num1":1,"Num2":2}`), &reply)
+	_assert(err == nil && string(reply) == "3", "unexpected reply %s %v", reply, err)
+	err = client.Call(context.Background(), "Foo.Sum", json.RawMessage(`{"Num1":"x"}`), &reply)
+	_assert(err != nil, "invalid arguments should fail")
+}
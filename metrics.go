@@ -0,0 +1,195 @@
+package tinyrpc
+
+import (
+	"errors"
+	"github.com/Asolmn/tinyrpc/metrics"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 调用结果的分类，作为请求计数的code标签
+const (
+	codeOK          = "ok"          // 调用成功
+	codeError       = "error"       // 服务方法返回错误
+	codeTimeout     = "timeout"     // 服务端处理超时
+	codeNotFound    = "not_found"   // 找不到服务或者方法
+	codeBadRequest  = "bad_request" // 请求体无法解码
+	codeUnavailable = "unavailable" // 服务器正在关闭或者连接已经断开
+	codeCanceled    = "canceled"    // 调用方取消了调用
+)
+
+// unknownMethod 找不到的方法使用的method标签，避免任意的方法名导致时间序列无限增长
+const unknownMethod = "unknown"
+
+// serverMetrics 服务器的指标
+type serverMetrics struct {
+	requests          *metrics.CounterVec
+	duration          *metrics.HistogramVec
+	inFlight          *metrics.GaugeVec
+	receivedBytes     *metrics.Counter
+	sentBytes         *metrics.Counter
+	connections       *metrics.Gauge
+	handshakeFailures *metrics.CounterVec
+}
+
+// newServerMetrics 在reg中创建服务器的指标，多个服务器使用同一个reg时共享指标
+func newServerMetrics(reg *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		requests: reg.CounterVec("tinyrpc_server_requests_total",
+			"Total number of requests handled by the server, partitioned by method and result code.", "method", "code"),
+		duration: reg.HistogramVec("tinyrpc_server_request_duration_seconds",
+			"Time spent handling requests in seconds.", nil, "method"),
+		inFlight: reg.GaugeVec("tinyrpc_server_in_flight_requests",
+			"Number of requests currently being handled.", "method"),
+		receivedBytes: reg.CounterVec("tinyrpc_server_received_bytes_total",
+			"Total number of bytes read from client connections.").With(),
+		sentBytes: reg.CounterVec("tinyrpc_server_sent_bytes_total",
+			"Total number of bytes written to client connections.").With(),
+		connections: reg.GaugeVec("tinyrpc_server_connections",
+			"Number of open client connections.").With(),
+		handshakeFailures: reg.CounterVec("tinyrpc_server_handshake_failures_total",
+			"Total number of connections rejected during the option exchange, partitioned by reason.", "reason"),
+	}
+}
+
+// EnableMetrics 在reg中记录服务器的指标，reg为nil时使用metrics.DefaultRegistry
+// 需要在开始接受连接和调用HandleHTTP之前调用，HandleHTTP会在/debug/tinyrpc/metrics输出这些指标
+func (server *Server) EnableMetrics(reg *metrics.Registry) {
+	if reg == nil {
+		reg = metrics.DefaultRegistry
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.metricsReg = reg
+	server.metrics = newServerMetrics(reg)
+}
+
+// serveMetrics 输出服务器的指标，没有启用时返回404
+func (server *Server) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	server.mu.Lock()
+	reg := server.metricsReg
+	server.mu.Unlock()
+
+	if reg == nil {
+		http.Error(w, "rpc server: metrics not enabled", http.StatusNotFound)
+		return
+	}
+	reg.ServeHTTP(w, req)
+}
+
+// handshakeFailed 记录协议交换失败，m为nil时不记录
+func (m *serverMetrics) handshakeFailed(reason string) {
+	if m != nil {
+		m.handshakeFailures.With(reason).Inc()
+	}
+}
+
+// requestStart 记录开始处理一个请求
+func (m *serverMetrics) requestStart(method string) {
+	if m != nil {
+		m.inFlight.With(method).Inc()
+	}
+}
+
+// requestDone 记录处理完成的请求
+func (m *serverMetrics) requestDone(method, code string, elapsed time.Duration) {
+	if m != nil {
+		m.inFlight.With(method).Dec()
+		m.requests.With(method, code).Inc()
+		m.duration.With(method).Observe(elapsed.Seconds())
+	}
+}
+
+// requestRejected 记录没有交给服务方法处理的请求
+func (m *serverMetrics) requestRejected(method, code string) {
+	if m != nil {
+		m.requests.With(method, code).Inc()
+	}
+}
+
+// requestLabel 返回请求的method标签，找不到的方法统一使用unknownMethod
+func requestLabel(req *request) string {
+	if req.mtype == nil {
+		return unknownMethod
+	}
+	return req.h.ServiceMethod
+}
+
+// countingConn 统计读写字节数的连接
+type countingConn struct {
+	io.ReadWriteCloser
+	m *serverMetrics
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.m.receivedBytes.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.m.sentBytes.Add(float64(n))
+	return n, err
+}
+
+// clientMetrics 客户端的指标，按服务端地址区分
+type clientMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
+}
+
+// newClientMetrics 在reg中创建客户端的指标，多个客户端使用同一个reg时共享指标
+func newClientMetrics(reg *metrics.Registry) *clientMetrics {
+	return &clientMetrics{
+		requests: reg.CounterVec("tinyrpc_client_requests_total",
+			"Total number of calls completed by the client, partitioned by target, method and result code.", "target", "method", "code"),
+		duration: reg.HistogramVec("tinyrpc_client_request_duration_seconds",
+			"Time from sending a call to receiving its reply in seconds.", nil, "target", "method"),
+		inFlight: reg.GaugeVec("tinyrpc_client_in_flight_requests",
+			"Number of calls waiting for a reply.", "target"),
+	}
+}
+
+// callMetrics 记录一次调用的指标，done只会生效一次
+type callMetrics struct {
+	m      *clientMetrics
+	target string
+	start  time.Time
+	once   int32
+}
+
+// begin 开始记录一次调用
+func (m *clientMetrics) begin(target string) *callMetrics {
+	m.inFlight.With(target).Inc()
+	return &callMetrics{m: m, target: target, start: time.Now()}
+}
+
+// done 记录调用的结果
+func (c *callMetrics) done(serviceMethod, code string) {
+	if !atomic.CompareAndSwapInt32(&c.once, 0, 1) {
+		return
+	}
+	c.m.inFlight.With(c.target).Dec()
+	c.m.requests.With(c.target, serviceMethod, code).Inc()
+	c.m.duration.With(c.target, serviceMethod).Observe(time.Since(c.start).Seconds())
+}
+
+// callCode 返回客户端调用结果的分类
+func callCode(err error) string {
+	switch {
+	case err == nil:
+		return codeOK
+	case errors.Is(err, ErrShutdown), errors.Is(err, ErrServerShutdown):
+		return codeUnavailable
+	case errors.Is(err, ErrHandleTimeout):
+		return codeTimeout
+	case errors.Is(err, ErrServiceNotFound), errors.Is(err, ErrMethodNotFound), errors.Is(err, ErrIllFormedRequest):
+		return codeNotFound
+	}
+	return codeError
+}
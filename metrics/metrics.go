@@ -0,0 +1,319 @@
+// Package metrics 不依赖第三方库的指标记录，支持计数器、仪表盘和直方图，以Prometheus文本格式输出
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 指标类型，与Prometheus的TYPE相同
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefBuckets 默认的直方图区间，单位为秒，适用于RPC调用的延迟
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry 指标的集合，同名的指标只会创建一次
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// DefaultRegistry 默认的指标集合
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry 创建一个空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family 同名的一组指标，每一组标签值对应一个时间序列
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64 // 直方图区间的上界，不包含+Inf
+
+	mu     sync.RWMutex
+	series map[string]*series // 以标签值拼接而成的字符串为键
+}
+
+// series 一个时间序列，计数器和仪表盘使用value，直方图使用counts、sum和count
+type series struct {
+	values []string // 标签值
+	value  uint64   // float64的二进制表示
+	counts []uint64 // 每个区间的观测次数，不累加，最后一个为+Inf
+	sum    uint64   // 观测值的和，float64的二进制表示
+	count  uint64   // 观测次数
+}
+
+// family 返回指定名称的指标，不存在时创建
+// 已经存在的指标类型或者标签不同时panic，属于编程错误
+func (r *Registry) family(name, help, typ string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic("metrics: " + name + " already registered with a different type or labels")
+		}
+		return f
+	}
+	if typ == typeHistogram {
+		buckets = append([]float64(nil), buckets...)
+		sort.Float64s(buckets)
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  append([]string(nil), labels...),
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with 返回标签值对应的时间序列，不存在时创建
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, but got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// addFloat 原子地将delta加到以二进制表示的float64上
+func addFloat(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// loadFloat 原子地读取以二进制表示的float64
+func loadFloat(addr *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(addr))
+}
+
+// CounterVec 带有标签的计数器
+type CounterVec struct{ f *family }
+
+// Counter 只增不减的计数器
+type Counter struct{ s *series }
+
+// CounterVec 返回指定名称的计数器，不存在时创建
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.family(name, help, typeCounter, nil, labels)}
+}
+
+// With 返回标签值对应的计数器，标签值的顺序与创建时的标签相同
+func (v *CounterVec) With(values ...string) *Counter { return &Counter{s: v.f.with(values)} }
+
+// Inc 计数器加1
+func (c *Counter) Inc() { addFloat(&c.s.value, 1) }
+
+// Add 计数器加上delta，delta不能为负数
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	addFloat(&c.s.value, delta)
+}
+
+// Value 返回计数器的值
+func (c *Counter) Value() float64 { return loadFloat(&c.s.value) }
+
+// GaugeVec 带有标签的仪表盘
+type GaugeVec struct{ f *family }
+
+// Gauge 可增可减的仪表盘
+type Gauge struct{ s *series }
+
+// GaugeVec 返回指定名称的仪表盘，不存在时创建
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.family(name, help, typeGauge, nil, labels)}
+}
+
+// With 返回标签值对应的仪表盘
+func (v *GaugeVec) With(values ...string) *Gauge { return &Gauge{s: v.f.with(values)} }
+
+// Set 设置仪表盘的值
+func (g *Gauge) Set(value float64) { atomic.StoreUint64(&g.s.value, math.Float64bits(value)) }
+
+// Add 仪表盘加上delta
+func (g *Gauge) Add(delta float64) { addFloat(&g.s.value, delta) }
+
+// Inc 仪表盘加1
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec 仪表盘减1
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value 返回仪表盘的值
+func (g *Gauge) Value() float64 { return loadFloat(&g.s.value) }
+
+// HistogramVec 带有标签的直方图
+type HistogramVec struct{ f *family }
+
+// Histogram 记录观测值分布的直方图
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// HistogramVec 返回指定名称的直方图，不存在时创建，buckets为空时使用DefBuckets
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{f: r.family(name, help, typeHistogram, buckets, labels)}
+}
+
+// With 返回标签值对应的直方图
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{s: v.f.with(values), buckets: v.f.buckets}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(value float64) {
+	// 第一个上界不小于value的区间，都不满足时为+Inf
+	i := sort.SearchFloat64s(h.buckets, value)
+	atomic.AddUint64(&h.s.counts[i], 1)
+	addFloat(&h.s.sum, value)
+	atomic.AddUint64(&h.s.count, 1)
+}
+
+// Count 返回观测次数
+func (h *Histogram) Count() uint64 { return atomic.LoadUint64(&h.s.count) }
+
+// Sum 返回观测值的和
+func (h *Histogram) Sum() float64 { return loadFloat(&h.s.sum) }
+
+// ContentType Prometheus文本格式的Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteTo 以Prometheus文本格式输出所有指标，指标按名称排序，时间序列按标签值排序
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP 以Prometheus文本格式响应所有指标
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+// write 输出一组指标，没有时间序列时只输出HELP和TYPE
+func (f *family) write(b *strings.Builder) {
+	f.mu.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+
+	if f.help != "" {
+		fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	}
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range all {
+		if f.typ != typeHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(loadFloat(&s.value)))
+			continue
+		}
+		// 直方图的区间计数是累加的
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += atomic.LoadUint64(&s.counts[i])
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", formatFloat(upper)), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&s.counts[len(f.buckets)])
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", "+Inf"), cumulative)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(loadFloat(&s.sum)))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, labelPairs(f.labels, s.values, "", ""), atomic.LoadUint64(&s.count))
+	}
+}
+
+// labelPairs 格式化标签，extra不为空时追加一个标签，例如直方图的le
+func labelPairs(labels, values []string, extra, extraValue string) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// formatFloat 按照Prometheus的格式输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
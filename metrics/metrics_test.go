@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.CounterVec("requests_total", "Total requests.\nSecond line.", "method", "code")
+	requests.With("Foo.Sum", "ok").Add(2)
+	requests.With(`Foo."Bar"`, "error").Inc()
+	// 同名的指标只会创建一次
+	r.CounterVec("requests_total", "", "method", "code").With("Foo.Sum", "ok").Inc()
+
+	g := r.GaugeVec("connections", "Open connections.").With()
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	h := r.HistogramVec("latency_seconds", "", []float64{0.1, 0.01, 1}, "method").With("Foo.Sum")
+	h.Observe(0.005)
+	h.Observe(0.01)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	_assert(err == nil, "write failed: %v", err)
+	want := `# HELP connections Open connections.
+# TYPE connections gauge
+connections 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="Foo.Sum",le="0.01"} 2
+latency_seconds_bucket{method="Foo.Sum",le="0.1"} 2
+latency_seconds_bucket{method="Foo.Sum",le="1"} 3
+latency_seconds_bucket{method="Foo.Sum",le="+Inf"} 4
+latency_seconds_sum{method="Foo.Sum"} 3.515
+latency_seconds_count{method="Foo.Sum"} 4
+# HELP requests_total Total requests.\nSecond line.
+# TYPE requests_total counter
+requests_total{method="Foo.\"Bar\"",code="error"} 1
+requests_total{method="Foo.Sum",code="ok"} 3
+`
+	_assert(b.String() == want, "unexpected output:\n%s", b.String())
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	_assert(rec.Header().Get("Content-Type") == ContentType && rec.Body.String() == want, "unexpected http response")
+}
+
+func TestRegistry_Conflict(t *testing.T) {
+	r := NewRegistry()
+	r.CounterVec("foo", "", "a")
+	defer func() {
+		_assert(recover() != nil, "registering foo with different labels should panic")
+	}()
+	r.GaugeVec("foo", "", "a")
+}
+
+func TestCounter_Concurrent(t *testing.T) {
+	c := NewRegistry().CounterVec("foo", "").With()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	_assert(c.Value() == 8000, "expect 8000, but got %v", c.Value())
+}
@@ -0,0 +1,70 @@
+package tinyrpc
+
+import (
+	"context"
+	"github.com/Asolmn/tinyrpc/metrics"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	t.Parallel()
+	reg := metrics.NewRegistry()
+	server, addr := startSlowServer(t)
+	server.EnableMetrics(reg)
+
+	client, err := XDial("tcp@"+addr, &Option{Metrics: reg, HandleTimeout: 100 * time.Millisecond})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call(context.Background(), "Slow.Sleep", 1, &reply) == nil, "call failed")
+	_assert(client.Call(context.Background(), "Slow.Sleep", 300, &reply) != nil, "call should time out")
+	_assert(client.Call(context.Background(), "Slow.Foo", 1, &reply) != nil, "unknown method should fail")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_assert(client.Call(ctx, "Slow.Sleep", 50, &reply) != nil, "call should be canceled")
+
+	// 无效的协议交换
+	conn, _ := net.Dial("tcp", addr)
+	_, _ = conn.Write([]byte("{\"MagicNumber\":1}\n"))
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		server.serveMetrics(rec, httptest.NewRequest("GET", defaultMetricsPath, nil))
+		return rec.Body.String()
+	}
+	target := "tcp@" + addr
+	for _, want := range []string{
+		`tinyrpc_server_requests_total{method="Slow.Sleep",code="ok"} 2`,
+		`tinyrpc_server_requests_total{method="Slow.Sleep",code="timeout"} 1`,
+		`tinyrpc_server_requests_total{method="unknown",code="not_found"} 1`,
+		`tinyrpc_server_request_duration_seconds_count{method="Slow.Sleep"} 3`,
+		`tinyrpc_server_in_flight_requests{method="Slow.Sleep"} 0`,
+		`tinyrpc_server_connections 1`,
+		`tinyrpc_server_handshake_failures_total{reason="magic_number"} 1`,
+		`tinyrpc_client_requests_total{target="` + target + `",method="Slow.Sleep",code="ok"} 1`,
+		`tinyrpc_client_requests_total{target="` + target + `",method="Slow.Sleep",code="timeout"} 1`,
+		`tinyrpc_client_requests_total{target="` + target + `",method="Slow.Sleep",code="canceled"} 1`,
+		`tinyrpc_client_requests_total{target="` + target + `",method="Slow.Foo",code="not_found"} 1`,
+		`tinyrpc_client_in_flight_requests{target="` + target + `"} 0`,
+	} {
+		// 被取消的调用在服务端仍然会执行完成
+		body := scrape()
+		for i := 0; i < 100 && !strings.Contains(body, want); i++ {
+			time.Sleep(10 * time.Millisecond)
+			body = scrape()
+		}
+		_assert(strings.Contains(body, want), "missing %s in\n%s", want, body)
+	}
+	_assert(!strings.Contains(scrape(), "tinyrpc_server_received_bytes_total 0\n"), "received bytes should be counted")
+
+	rec := httptest.NewRecorder()
+	NewServer().serveMetrics(rec, httptest.NewRequest("GET", defaultMetricsPath, nil))
+	_assert(rec.Code == 404, "metrics should be disabled by default")
+}
@@ -0,0 +1,157 @@
+package tinyrpc
+
+import (
+	"errors"
+	"go/ast"
+	"reflect"
+	"sort"
+)
+
+// ReflectionServiceName 反射服务的服务名
+const ReflectionServiceName = "Reflection"
+
+// TypeSchema 参数类型的描述，由methodType中的反射信息生成
+// 调用方不需要Go类型，就可以根据描述构造请求
+type TypeSchema struct {
+	Name      string         `json:"name"`                // 类型名，例如int、main.Args、*main.Args、[]string
+	Kind      string         `json:"kind"`                // 类型的种类，与reflect.Kind的名称相同，例如int、struct、ptr、slice、map
+	Elem      *TypeSchema    `json:"elem,omitempty"`      // ptr、slice、array和map的元素类型
+	Key       *TypeSchema    `json:"key,omitempty"`       // map的键类型
+	Len       int            `json:"len,omitempty"`       // array的长度
+	Fields    []*FieldSchema `json:"fields,omitempty"`    // struct的导出字段，按定义顺序排列
+	Recursive bool           `json:"recursive,omitempty"` // 递归引用了正在描述的结构体，不再展开字段
+}
+
+// FieldSchema 结构体字段的描述
+type FieldSchema struct {
+	Name     string      `json:"name"`               // 字段名
+	Tag      string      `json:"tag,omitempty"`      // 字段的标签，例如`json:"name"`
+	Embedded bool        `json:"embedded,omitempty"` // 是否为嵌入字段
+	Type     *TypeSchema `json:"type"`               // 字段的类型
+}
+
+// MethodSchema 方法的描述
+type MethodSchema struct {
+	Name  string      `json:"name"`  // 方法名
+	Arg   *TypeSchema `json:"arg"`   // 第一个参数的类型
+	Reply *TypeSchema `json:"reply"` // 第二个参数的类型，总是指针
+}
+
+// ServiceSchema 服务的描述，方法按名称排序
+type ServiceSchema struct {
+	Name    string          `json:"name"`
+	Methods []*MethodSchema `json:"methods"`
+}
+
+// ReflectionRequest 反射请求，Service为空表示所有服务，Method为空表示服务的所有方法
+type ReflectionRequest struct {
+	Service string
+	Method  string
+}
+
+// Reflection 内置的反射服务，通过Server.EnableReflection注册到服务器
+type Reflection struct {
+	server *Server
+}
+
+// Services 返回服务器中已经注册的服务名，按字母顺序排序
+func (r *Reflection) Services(_ ReflectionRequest, reply *[]string) error {
+	*reply = r.server.Services()
+	return nil
+}
+
+// Describe 返回服务以及方法的参数类型描述
+func (r *Reflection) Describe(req ReflectionRequest, reply *[]*ServiceSchema) error {
+	if req.Service == "" && req.Method != "" {
+		return errors.New("rpc reflection: method without service")
+	}
+
+	var names []string
+	if req.Service == "" {
+		names = r.server.Services()
+	} else {
+		names = []string{req.Service}
+	}
+
+	schemas := make([]*ServiceSchema, 0, len(names))
+	for _, name := range names {
+		svci, ok := r.server.serviceMap.Load(name)
+		if !ok {
+			return errors.New("rpc reflection: can't find service " + name)
+		}
+		schema, err := describeService(svci.(*service), req.Method)
+		if err != nil {
+			return err
+		}
+		schemas = append(schemas, schema)
+	}
+	*reply = schemas
+	return nil
+}
+
+// describeService 生成服务的描述，method不为空时只描述该方法
+func describeService(svc *service, method string) (*ServiceSchema, error) {
+	schema := &ServiceSchema{Name: svc.name}
+	for name, mtype := range svc.method {
+		if method != "" && name != method {
+			continue
+		}
+		schema.Methods = append(schema.Methods, &MethodSchema{
+			Name:  name,
+			Arg:   DescribeType(mtype.ArgType),
+			Reply: DescribeType(mtype.ReplyType),
+		})
+	}
+	if method != "" && len(schema.Methods) == 0 {
+		return nil, errors.New("rpc reflection: can't find method " + svc.name + "." + method)
+	}
+	sort.Slice(schema.Methods, func(i, j int) bool { return schema.Methods[i].Name < schema.Methods[j].Name })
+	return schema, nil
+}
+
+// DescribeType 生成类型的描述，只包含结构体的导出字段
+func DescribeType(t reflect.Type) *TypeSchema {
+	return describeType(t, make(map[reflect.Type]bool))
+}
+
+// describeType 递归生成类型的描述，visiting记录正在展开的结构体，避免递归类型无限展开
+func describeType(t reflect.Type, visiting map[reflect.Type]bool) *TypeSchema {
+	schema := &TypeSchema{Name: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Array:
+		schema.Len = t.Len()
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Map:
+		schema.Key = describeType(t.Key(), visiting)
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			schema.Recursive = true
+			return schema
+		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			// 编解码器忽略未导出的字段
+			if !ast.IsExported(f.Name) {
+				continue
+			}
+			schema.Fields = append(schema.Fields, &FieldSchema{
+				Name:     f.Name,
+				Tag:      string(f.Tag),
+				Embedded: f.Anonymous,
+				Type:     describeType(f.Type, visiting),
+			})
+		}
+		delete(visiting, t)
+	}
+	return schema
+}
+
+// EnableReflection 在服务器上注册内置的反射服务，重复调用不会重复注册
+func (server *Server) EnableReflection() {
+	s, _ := newService(&Reflection{server: server}) // Reflection是导出类型，不会返回错误
+	server.serviceMap.LoadOrStore(s.name, s)
+}
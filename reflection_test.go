@@ -0,0 +1,68 @@
+package tinyrpc
+
+import (
+	"context"
+	"testing"
+)
+
+type Node struct {
+	Value    int
+	Children []*Node
+}
+
+type Query struct {
+	Name   string `json:"name"`
+	Labels map[string]string
+	Root   *Node
+	secret int
+}
+
+type Tree int
+
+func (t Tree) Find(q Query, reply *Node) error { return nil }
+
+func (t Tree) Count(n int, reply *int) error { return nil }
+
+func TestReflection(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+	var tree Tree
+	_ = server.Register(&tree)
+	server.EnableReflection()
+	server.EnableReflection()
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var services []string
+	err = client.Call(context.Background(), "Reflection.Services", ReflectionRequest{}, &services)
+	_assert(err == nil, "failed to list services: %v", err)
+	_assert(len(services) == 3 && services[0] == "Reflection" && services[2] == "Tree", "unexpected services %v", services)
+
+	var schemas []*ServiceSchema
+	err = client.Call(context.Background(), "Reflection.Describe", ReflectionRequest{Service: "Tree"}, &schemas)
+	_assert(err == nil, "failed to describe: %v", err)
+	_assert(len(schemas) == 1 && len(schemas[0].Methods) == 2, "unexpected schema")
+	count, find := schemas[0].Methods[0], schemas[0].Methods[1]
+	_assert(count.Name == "Count" && count.Arg.Kind == "int", "unexpected method %s(%s)", count.Name, count.Arg.Kind)
+	_assert(count.Reply.Kind == "ptr" && count.Reply.Elem.Name == "int", "reply should be a pointer to int")
+
+	// 结构体只包含导出字段，递归引用的结构体不再展开
+	q := find.Arg
+	_assert(q.Name == "tinyrpc.Query" && q.Kind == "struct" && len(q.Fields) == 3, "unexpected arg %s %d", q.Name, len(q.Fields))
+	_assert(q.Fields[0].Name == "Name" && q.Fields[0].Tag == `json:"name"`, "unexpected field %s", q.Fields[0].Name)
+	labels := q.Fields[1].Type
+	_assert(labels.Kind == "map" && labels.Key.Kind == "string" && labels.Elem.Kind == "string", "unexpected map schema")
+	node := q.Fields[2].Type.Elem
+	_assert(node.Kind == "struct" && len(node.Fields) == 2 && !node.Recursive, "node should be expanded")
+	child := node.Fields[1].Type.Elem.Elem
+	_assert(child.Name == "tinyrpc.Node" && child.Recursive && len(child.Fields) == 0, "recursive node should not be expanded")
+
+	err = client.Call(context.Background(), "Reflection.Describe", ReflectionRequest{Service: "Tree", Method: "Find"}, &schemas)
+	_assert(err == nil && len(schemas[0].Methods) == 1, "failed to describe method: %v", err)
+	err = client.Call(context.Background(), "Reflection.Describe", ReflectionRequest{Service: "Foo"}, &schemas)
+	_assert(err != nil, "describe unknown service should fail")
+	err = client.Call(context.Background(), "Reflection.Describe", ReflectionRequest{Service: "Tree", Method: "Foo"}, &schemas)
+	_assert(err != nil, "describe unknown method should fail")
+}
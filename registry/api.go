@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON格式的REST API挂载在注册中心地址之下，例如/_tinyrpc_/registry/v1/instances
+//
+//	GET    <path>/v1/services                                 返回所有服务以及版本
+//	GET    <path>/v1/instances?service=Foo&version=v1&tag=ssd 返回服务实例，可以按服务、版本、标签过滤，
+//	                                                          只指定版本时返回提供该版本任意服务的实例，
+//	                                                          携带index和wait时为watch请求，见watch.go
+//	GET    <path>/v1/instances/{addr}                         返回单个服务实例
+//	POST   <path>/v1/instances                                注册服务实例或者发送心跳，可以设置ttl
+//	DELETE <path>/v1/instances/{addr}                         注销服务实例
+//
+// {addr}需要进行路径转义，例如unix@/tmp/a.sock转义为unix@%2Ftmp%2Fa.sock
+const apiPrefix = "/v1/"
+
+// RegisterRequest 注册服务实例的请求体
+type RegisterRequest struct {
+	Instance
+	TTL int64 `json:"ttl,omitempty"` // 实例的过期时间，单位为秒，为0时使用注册中心的超时时间
+}
+
+// ServiceInfo 注册中心中的服务
+type ServiceInfo struct {
+	Name      string   `json:"name"`               // 服务名
+	Versions  []string `json:"versions,omitempty"` // 服务的所有版本，不包含未指定版本的注册
+	Instances int      `json:"instances"`          // 提供该服务的实例数量
+}
+
+// errorResponse API的错误响应体
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// serveAPI 处理REST API请求，path为去除了apiPrefix之后未解码的路径
+func (r *TinyRegistry) serveAPI(w http.ResponseWriter, req *http.Request, path string) {
+	resource, addr, hasAddr := strings.Cut(path, "/")
+	switch {
+	case resource == "replicate" && !hasAddr:
+		r.serveReplicate(w, req)
+	case resource == "services" && !hasAddr:
+		if req.Method != "GET" {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Services []ServiceInfo `json:"services"`
+		}{r.services()})
+	case resource == "instances" && !hasAddr:
+		switch req.Method {
+		case "GET":
+			// 携带index时为watch请求，等待服务列表发生变化
+			index, err := r.watch(req)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid watch parameter: "+err.Error())
+				return
+			}
+			query := req.URL.Query()
+			instances := filterTags(r.aliveInstances(query.Get("service"), query.Get("version")), query["tag"])
+			if instances == nil {
+				instances = []*Instance{}
+			}
+			w.Header().Set("X-Tinyrpc-Index", strconv.FormatUint(index, 10))
+			writeJSON(w, http.StatusOK, instancesResponse{Index: index, Instances: instances})
+		case "POST":
+			r.register(w, req)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case resource == "instances" && addr != "":
+		addr, err := url.PathUnescape(addr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid instance address: "+err.Error())
+			return
+		}
+		switch req.Method {
+		case "GET":
+			inst, ok := r.getServer(addr)
+			if !ok {
+				writeError(w, http.StatusNotFound, "instance not found: "+addr)
+				return
+			}
+			writeJSON(w, http.StatusOK, inst)
+		case "DELETE":
+			if !r.removeServer(addr) {
+				writeError(w, http.StatusNotFound, "instance not found: "+addr)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "unknown resource: "+path)
+	}
+}
+
+// register 注册服务实例，新注册的实例返回201，已经存在的实例返回200
+func (r *TinyRegistry) register(w http.ResponseWriter, req *http.Request) {
+	var body RegisterRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if body.Addr == "" {
+		writeError(w, http.StatusBadRequest, "addr is required")
+		return
+	}
+	if body.TTL < 0 {
+		writeError(w, http.StatusBadRequest, "ttl must not be negative")
+		return
+	}
+
+	status := http.StatusOK
+	if r.putServer(&body.Instance, time.Duration(body.TTL)*time.Second) {
+		status = http.StatusCreated
+	}
+	inst, _ := r.getServer(body.Addr)
+	writeJSON(w, status, inst)
+}
+
+// services 返回所有可用实例提供的服务，按服务名排序
+func (r *TinyRegistry) services() []ServiceInfo {
+	infos := make(map[string]*ServiceInfo)
+	versions := make(map[string]map[string]bool)
+	for _, inst := range r.aliveInstances("", "") {
+		counted := make(map[string]bool)
+		for _, svc := range inst.Services {
+			name, version := SplitService(svc)
+			info, ok := infos[name]
+			if !ok {
+				info = &ServiceInfo{Name: name}
+				infos[name] = info
+				versions[name] = make(map[string]bool)
+			}
+			if !counted[name] { // 同一实例注册了多个版本时只计数一次
+				counted[name] = true
+				info.Instances++
+			}
+			if version != "" && !versions[name][version] {
+				versions[name][version] = true
+				info.Versions = append(info.Versions, version)
+			}
+		}
+	}
+
+	services := make([]ServiceInfo, 0, len(infos))
+	for _, info := range infos {
+		sort.Strings(info.Versions)
+		services = append(services, *info)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// filterTags 返回带有所有指定标签的服务实例
+func filterTags(instances []*Instance, tags []string) []*Instance {
+	if len(tags) == 0 {
+		return instances
+	}
+	var filtered []*Instance
+	for _, inst := range instances {
+		match := true
+		for _, tag := range tags {
+			if !inst.HasTag(tag) {
+				match = false
+				break
+			}
+		}
+		if match {
+			filtered = append(filtered, inst)
+		}
+	}
+	return filtered
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed, allow: "+allow)
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// doJSON 发送请求，并将JSON响应体解码到v中，返回状态码
+func doJSON(t *testing.T, method, url string, body, v interface{}) int {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if v != nil {
+		_ = json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+func TestTinyRegistry_API(t *testing.T) {
+	registry := startRegistry(t, New(time.Minute))
+	api := registry + "/v1"
+
+	var inst Instance
+	status := doJSON(t, "POST", api+"/instances", RegisterRequest{
+		Instance: Instance{Addr: "tcp@a", Services: []string{"Foo@v1", "Bar"}, Metadata: map[string]string{MetaTags: "ssd"}},
+	}, &inst)
+	_assert(status == http.StatusCreated && inst.Addr == "tcp@a", "register: expect 201, but got %d %+v", status, inst)
+	status = doJSON(t, "POST", api+"/instances", RegisterRequest{
+		Instance: Instance{Addr: "tcp@a", Services: []string{"Foo@v1", "Bar"}, Metadata: map[string]string{MetaTags: "ssd"}},
+	}, nil)
+	_assert(status == http.StatusOK, "heartbeat: expect 200, but got %d", status)
+	_ = doJSON(t, "POST", api+"/instances", RegisterRequest{Instance: Instance{Addr: "unix@/tmp/b.sock", Services: []string{"Foo@v2"}}}, nil)
+
+	var errBody errorResponse
+	status = doJSON(t, "POST", api+"/instances", RegisterRequest{}, &errBody)
+	_assert(status == http.StatusBadRequest && errBody.Error != "", "expect 400 with error body, but got %d %+v", status, errBody)
+
+	var services struct{ Services []ServiceInfo }
+	_ = doJSON(t, "GET", api+"/services", nil, &services)
+	_assert(len(services.Services) == 2, "expect 2 services, but got %+v", services)
+	foo := services.Services[1]
+	_assert(foo.Name == "Foo" && foo.Instances == 2 && len(foo.Versions) == 2, "unexpected service %+v", foo)
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{"", 2},
+		{"?service=Foo", 2},
+		{"?service=Foo&version=v2", 1},
+		{"?version=v1", 1},
+		{"?tag=ssd", 1},
+		{"?service=Bar&tag=hdd", 0},
+	}
+	for _, c := range cases {
+		var body instancesResponse
+		status = doJSON(t, "GET", api+"/instances"+c.query, nil, &body)
+		_assert(status == http.StatusOK && len(body.Instances) == c.want, "GET %s: expect %d instances, but got %d", c.query, c.want, len(body.Instances))
+	}
+
+	sock := api + "/instances/" + url.PathEscape("unix@/tmp/b.sock")
+	status = doJSON(t, "GET", sock, nil, &inst)
+	_assert(status == http.StatusOK && inst.Addr == "unix@/tmp/b.sock", "get: expect unix@/tmp/b.sock, but got %d %+v", status, inst)
+	status = doJSON(t, "DELETE", sock, nil, nil)
+	_assert(status == http.StatusNoContent, "delete: expect 204, but got %d", status)
+	status = doJSON(t, "GET", sock, nil, &errBody)
+	_assert(status == http.StatusNotFound, "get after delete: expect 404, but got %d", status)
+	status = doJSON(t, "DELETE", sock, nil, nil)
+	_assert(status == http.StatusNotFound, "delete twice: expect 404, but got %d", status)
+	status = doJSON(t, "PUT", api+"/instances", nil, nil)
+	_assert(status == http.StatusMethodNotAllowed, "put: expect 405, but got %d", status)
+}
+
+func TestTinyRegistry_TTL(t *testing.T) {
+	registry := startRegistry(t, New(time.Minute))
+	api := registry + "/v1"
+
+	_ = doJSON(t, "POST", api+"/instances", RegisterRequest{Instance: Instance{Addr: "tcp@a"}, TTL: 1}, nil)
+	_ = doJSON(t, "POST", api+"/instances", RegisterRequest{Instance: Instance{Addr: "tcp@b"}}, nil)
+	var body instancesResponse
+	_ = doJSON(t, "GET", api+"/instances", nil, &body)
+	_assert(len(body.Instances) == 2, "expect 2 instances, but got %d", len(body.Instances))
+
+	time.Sleep(time.Second + 100*time.Millisecond)
+	_ = doJSON(t, "GET", api+"/instances", nil, &body)
+	_assert(len(body.Instances) == 1 && body.Instances[0].Addr == "tcp@b", "tcp@a should expire, but got %+v", body.Instances)
+}
+
+func TestTinyRegistry_MountPath(t *testing.T) {
+	const path = "/api/v1/registry" // 挂载路径本身包含/v1/
+	r := New(time.Minute)
+	r.SetPath(path)
+	mux := http.NewServeMux()
+	mux.Handle(path, r)
+	mux.Handle(path+"/v1/", r)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	registry := ts.URL + path
+
+	err := sendHeartbeat(registry, &Instance{Addr: "tcp@a", Services: []string{"Foo"}})
+	_assert(err == nil, "legacy heartbeat should be served under %s: %v", path, err)
+	servers := getServers(t, registry)
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "legacy GET: expect tcp@a, but got %v", servers)
+
+	var body instancesResponse
+	status := doJSON(t, "GET", registry+"/v1/instances", nil, &body)
+	_assert(status == http.StatusOK && len(body.Instances) == 1, "API GET: expect 1 instance, but got %d %+v", status, body)
+}
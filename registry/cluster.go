@@ -0,0 +1,251 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 注册中心集群通过反熵(anti-entropy)复制服务实例
+// 本地的注册、心跳和注销会立即异步推送给所有节点，同时每个节点定时从其他节点拉取全部状态进行合并
+// 合并时按照最后写入者胜出(last-write-wins)，服务实例的版本为最后一次心跳的时间，注销记录为删除时间的墓碑
+// 因此要求节点之间的时钟大致同步
+//
+//	GET  <path>/v1/replicate 返回节点的全部服务实例和墓碑
+//	POST <path>/v1/replicate 合并其他节点推送的服务实例和墓碑
+
+// replicaEntry 复制的一条记录，Instance不为nil时为注册或者心跳，否则为注销
+type replicaEntry struct {
+	Instance *Instance     `json:"instance,omitempty"` // LastHeartbeat为记录的版本
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Addr     string        `json:"addr,omitempty"`    // 注销的服务端地址
+	Deleted  time.Time     `json:"deleted,omitempty"` // 注销的时间
+}
+
+// replicaState 节点之间复制的全部状态
+type replicaState struct {
+	Entries []replicaEntry `json:"entries"`
+}
+
+// cluster 注册中心集群的复制状态
+type cluster struct {
+	peers    []string     // 其他节点的注册中心地址
+	client   *http.Client // 复制请求使用的客户端
+	stop     chan struct{}
+	done     chan struct{}
+	interval time.Duration
+}
+
+// ParseRegistries 解析以逗号分隔的多个注册中心地址，Heartbeat和服务发现使用它在注册中心之间故障转移
+func ParseRegistries(registry string) []string {
+	var registries []string
+	for _, addr := range strings.Split(registry, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			registries = append(registries, addr)
+		}
+	}
+	return registries
+}
+
+// Replicate 与peers中的其他注册中心组成集群，peers为其他节点的注册中心地址，例如http://host:9999/_tinyrpc_/registry
+// 本地的变更立即推送给所有节点，并且每隔interval从所有节点拉取全部状态，interval为0时使用默认的10s
+// 需要在注册中心开始处理请求之前调用，Close时停止复制
+func (r *TinyRegistry) Replicate(peers []string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cluster != nil {
+		return errors.New("rpc registry: replication already enabled")
+	}
+	c := &cluster{
+		peers:    peers,
+		client:   &http.Client{Timeout: defaultRequestTimeout},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+	r.cluster = c
+	go r.antiEntropy(c)
+	return nil
+}
+
+// stopReplicate 停止复制
+func (r *TinyRegistry) stopReplicate() {
+	r.mu.Lock()
+	c := r.cluster
+	r.cluster = nil
+	r.mu.Unlock()
+
+	if c != nil {
+		close(c.stop)
+		<-c.done
+	}
+}
+
+// antiEntropy 定时从所有节点拉取全部状态
+func (r *TinyRegistry) antiEntropy(c *cluster) {
+	defer close(c.done)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		for _, peer := range c.peers {
+			if err := r.pull(c, peer); err != nil {
+				r.log().Warn("rpc registry: pull from peer error", "peer", peer, "err", err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// pull 从一个节点拉取全部状态并合并
+func (r *TinyRegistry) pull(c *cluster, peer string) error {
+	resp, err := c.client.Get(strings.TrimSuffix(peer, "/") + apiPrefix + "replicate")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("rpc registry: pull " + resp.Status)
+	}
+
+	var state replicaState
+	if err = json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return err
+	}
+	r.merge(state.Entries)
+	return nil
+}
+
+// replicate 将本地的变更异步推送给所有节点，没有启用复制时忽略，调用方需要持有锁
+// 推送失败的变更由反熵拉取补偿
+func (r *TinyRegistry) replicate(e replicaEntry) {
+	c := r.cluster
+	if c == nil {
+		return
+	}
+	body, _ := json.Marshal(replicaState{Entries: []replicaEntry{e}})
+	for _, peer := range c.peers {
+		go func(peer string) {
+			resp, err := c.client.Post(strings.TrimSuffix(peer, "/")+apiPrefix+"replicate", "application/json", bytes.NewReader(body))
+			if err != nil {
+				r.log().Warn("rpc registry: push to peer error", "peer", peer, "err", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}(peer)
+	}
+}
+
+// state 返回节点的全部服务实例和墓碑
+func (r *TinyRegistry) state() replicaState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(time.Now())
+	entries := make([]replicaEntry, 0, len(r.servers)+len(r.tombstones))
+	for _, s := range r.servers {
+		entries = append(entries, replicaEntry{Instance: s.Instance.clone(), TTL: s.ttl})
+	}
+	for addr, deleted := range r.tombstones {
+		entries = append(entries, replicaEntry{Addr: addr, Deleted: deleted})
+	}
+	return replicaState{Entries: entries}
+}
+
+// merge 按照最后写入者胜出合并其他节点的记录，合并的记录不会再次推送
+func (r *TinyRegistry) merge(entries []replicaEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for _, e := range entries {
+		if e.Instance == nil {
+			changed = r.mergeDelete(e.Addr, e.Deleted) || changed
+			continue
+		}
+		if e.Instance.Addr == "" {
+			continue
+		}
+		version := e.Instance.LastHeartbeat
+		if deleted, ok := r.tombstones[e.Instance.Addr]; ok && !version.After(deleted) {
+			continue
+		}
+		old, ok := r.servers[e.Instance.Addr]
+		if ok && !version.After(old.start) {
+			continue
+		}
+		item := &ServerItem{Instance: *e.Instance.clone(), start: version, ttl: e.TTL}
+		if item.expired(r.timeout, now) {
+			continue
+		}
+		r.servers[item.Addr] = item
+		delete(r.tombstones, item.Addr)
+		created := !ok || old.expired(r.timeout, now)
+		if created { // 重新注册的服务实例不沿用之前的健康检查结果
+			delete(r.health, item.Addr)
+		}
+		if created || !old.Instance.equal(&item.Instance) {
+			changed = true
+		}
+		r.journal(persistRecord{Op: opPut, Instance: &item.Instance, TTL: item.ttl})
+	}
+	if changed {
+		r.bump()
+	}
+}
+
+// mergeDelete 合并注销记录，返回服务列表是否发生变化，调用方需要持有锁
+func (r *TinyRegistry) mergeDelete(addr string, deleted time.Time) bool {
+	if addr == "" {
+		return false
+	}
+	if old, ok := r.tombstones[addr]; !ok || deleted.After(old) {
+		r.tombstones[addr] = deleted
+	}
+	s, ok := r.servers[addr]
+	if !ok || s.start.After(deleted) { // 注销之后又重新注册
+		return false
+	}
+	r.deleteServer(addr)
+	r.journal(persistRecord{Op: opDelete, Addr: addr, Deleted: r.tombstones[addr]})
+	return true
+}
+
+// tombstoneTTL 墓碑的保留时间，超过注册中心的超时时间后，更早的注册记录都已经过期，不再需要墓碑
+func (r *TinyRegistry) tombstoneTTL() time.Duration {
+	if r.timeout > 0 {
+		return r.timeout
+	}
+	return defaultTimeout
+}
+
+// serveReplicate 处理复制请求
+func (r *TinyRegistry) serveReplicate(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, r.state())
+	case "POST":
+		var state replicaState
+		if err := json.NewDecoder(req.Body).Decode(&state); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		r.merge(state.Entries)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
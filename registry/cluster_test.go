@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// node 集群中的一个注册中心节点
+type node struct {
+	r    *TinyRegistry
+	ts   *httptest.Server
+	addr string
+}
+
+func (n *node) kill() {
+	n.ts.Close()
+	_ = n.r.Close()
+}
+
+// startCluster 在进程内启动一个n节点的注册中心集群
+func startCluster(t *testing.T, n int) []*node {
+	nodes := make([]*node, n)
+	for i := range nodes {
+		r := New(time.Minute)
+		ts := httptest.NewServer(r)
+		nodes[i] = &node{r: r, ts: ts, addr: ts.URL + defaultPath}
+	}
+	for i, n := range nodes {
+		var peers []string
+		for j, peer := range nodes {
+			if i != j {
+				peers = append(peers, peer.addr)
+			}
+		}
+		_assert(n.r.Replicate(peers, 50*time.Millisecond) == nil, "failed to enable replication")
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.kill()
+		}
+	})
+	return nodes
+}
+
+// eventually 等待所有节点的服务列表都为want
+func eventually(t *testing.T, nodes []*node, want string) {
+	var got string
+	for i := 0; i < 100; i++ {
+		ok := true
+		for _, n := range nodes {
+			if got = strings.Join(n.r.aliveServers("", ""), ","); got != want {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expect %q on every node, but got %q", want, got)
+}
+
+func TestTinyRegistry_Cluster(t *testing.T) {
+	nodes := startCluster(t, 3)
+	registries := nodes[0].addr + "," + nodes[1].addr + "," + nodes[2].addr
+
+	// 注册到第一个节点，复制到其他节点
+	a := Heartbeat(registries, "tcp@a", time.Hour, "Foo")
+	defer a.Stop()
+	eventually(t, nodes, "tcp@a")
+
+	// 第一个节点宕机之后，心跳切换到下一个节点
+	nodes[0].kill()
+	b := Heartbeat(registries, "tcp@b", time.Hour, "Foo")
+	defer b.Stop()
+	eventually(t, nodes[1:], "tcp@a,tcp@b")
+
+	// 注销也会复制到其他节点
+	_assert(a.Deregister() == nil, "failed to deregister tcp@a")
+	eventually(t, nodes[1:], "tcp@b")
+
+	// 节点宕机期间错过的变更通过反熵拉取补偿
+	nodes[1].kill()
+	c := Heartbeat(registries, "tcp@c", time.Hour)
+	defer c.Stop()
+	eventually(t, nodes[2:], "tcp@b,tcp@c")
+
+	restarted := New(time.Minute)
+	ts := httptest.NewServer(restarted)
+	defer ts.Close()
+	_assert(restarted.Replicate([]string{nodes[2].addr}, 50*time.Millisecond) == nil, "failed to enable replication")
+	defer func() { _ = restarted.Close() }()
+	eventually(t, []*node{{r: restarted}}, "tcp@b,tcp@c")
+}
+
+func TestTinyRegistry_MergeLWW(t *testing.T) {
+	r := New(time.Minute)
+	now := time.Now()
+	older, newer := now.Add(-time.Second), now
+
+	r.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", Metadata: map[string]string{MetaZone: "new"}, LastHeartbeat: newer}}})
+	r.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", Metadata: map[string]string{MetaZone: "old"}, LastHeartbeat: older}}})
+	inst, ok := r.getServer("tcp@a")
+	_assert(ok && inst.Metadata[MetaZone] == "new", "older write should lose, but got %+v", inst)
+
+	// 注销早于最后一次心跳时，不会删除服务实例
+	r.merge([]replicaEntry{{Addr: "tcp@a", Deleted: older}})
+	_, ok = r.getServer("tcp@a")
+	_assert(ok, "stale delete should be ignored")
+
+	r.merge([]replicaEntry{{Addr: "tcp@a", Deleted: newer.Add(time.Millisecond)}})
+	_, ok = r.getServer("tcp@a")
+	_assert(!ok, "newer delete should win")
+
+	// 墓碑阻止更早的注册被复制回来
+	r.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", LastHeartbeat: newer}}})
+	_, ok = r.getServer("tcp@a")
+	_assert(!ok, "tombstone should block older registrations")
+}
+
+func TestTinyRegistry_MergeResetsHealth(t *testing.T) {
+	r := New(time.Minute)
+	r.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", LastHeartbeat: time.Now()}, TTL: 50 * time.Millisecond}})
+	r.mu.Lock()
+	r.health["tcp@a"] = &healthState{unhealthy: true}
+	r.mu.Unlock()
+	_assert(len(r.aliveServers("", "")) == 0, "unhealthy instance should be skipped")
+
+	// 服务实例过期之后通过其他节点重新注册，不沿用之前的健康检查结果
+	time.Sleep(100 * time.Millisecond)
+	r.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", LastHeartbeat: time.Now()}}})
+	got := strings.Join(r.aliveServers("", ""), ",")
+	_assert(got == "tcp@a", "re-registered instance should be healthy, but got %q", got)
+}
@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"github.com/Asolmn/tinyrpc"
+	"sync"
+	"time"
+)
+
+// Prober 主动探测服务实例是否可用
+type Prober interface {
+	// Probe 探测addr，返回nil表示服务实例可用，addr的格式为protocol@addr
+	Probe(ctx context.Context, addr string) error
+}
+
+// ProberFunc 将函数转换为Prober
+type ProberFunc func(ctx context.Context, addr string) error
+
+func (f ProberFunc) Probe(ctx context.Context, addr string) error { return f(ctx, addr) }
+
+// HandshakeProber 默认的探测方式，与服务实例建立连接、完成协议交换，并发送一个不存在的方法调用
+// 服务端返回任何错误响应(tinyrpc.ServerError)都说明它仍然在读取和处理请求，连接失败或者超时说明服务实例不可用
+var HandshakeProber Prober = ProberFunc(func(ctx context.Context, addr string) error {
+	client, err := probeDial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	err = client.Call(ctx, ".", 0, nil)
+	var serverErr tinyrpc.ServerError
+	if errors.As(err, &serverErr) { // 服务端返回的错误
+		return nil
+	}
+	if err == nil {
+		return errors.New("rpc registry: unexpected reply to probe")
+	}
+	return err
+})
+
+// HealthServiceProber 调用服务实例内置的健康检查服务，整个服务器不是SERVING时视为不健康
+// 服务实例没有注册健康检查服务时，与HandshakeProber相同
+var HealthServiceProber Prober = ProberFunc(func(ctx context.Context, addr string) error {
+	client, err := probeDial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply tinyrpc.HealthCheckResponse
+	err = client.Call(ctx, tinyrpc.HealthServiceName+".Check", tinyrpc.HealthCheckRequest{}, &reply)
+	if err != nil {
+		if errors.Is(err, tinyrpc.ErrServiceNotFound) { // 没有注册健康检查服务
+			return nil
+		}
+		return err
+	}
+	if reply.Status != tinyrpc.StatusServing {
+		return errors.New("rpc registry: instance is " + reply.Status.String())
+	}
+	return nil
+})
+
+// probeDial 在ctx的截止时间之前与服务实例建立连接
+func probeDial(ctx context.Context, addr string) (*tinyrpc.Client, error) {
+	opt := *tinyrpc.DefaultOption
+	if deadline, ok := ctx.Deadline(); ok {
+		opt.ConnectTimeout = time.Until(deadline)
+	}
+	return tinyrpc.XDial(addr, &opt)
+}
+
+// HealthCheckOption 主动健康检查配置
+type HealthCheckOption struct {
+	Interval           time.Duration // 探测间隔
+	Timeout            time.Duration // 单次探测的超时时间
+	UnhealthyThreshold int           // 连续失败次数达到后标记为不健康
+	HealthyThreshold   int           // 不健康的实例连续成功次数达到后恢复为健康
+	Prober             Prober        // 探测方式，为nil时使用HandshakeProber
+}
+
+// DefaultHealthCheckOption 默认健康检查配置
+var DefaultHealthCheckOption = &HealthCheckOption{
+	Interval:           time.Second * 10,
+	Timeout:            time.Second * 3,
+	UnhealthyThreshold: 3,
+	HealthyThreshold:   2,
+}
+
+// healthState 单个服务实例的健康状态
+type healthState struct {
+	unhealthy bool
+	successes int // 连续成功次数
+	failures  int // 连续失败次数
+}
+
+// healthChecker 注册中心的主动健康检查
+type healthChecker struct {
+	opt  *HealthCheckOption
+	stop chan struct{}
+	done chan struct{}
+}
+
+// EnableHealthCheck 定时主动探测所有注册的服务实例，不健康的实例不会出现在服务列表中
+// 新注册的实例默认健康，只有连续探测失败达到阈值后才会被排除，避免抖动
+// 需要在注册中心开始处理请求之前调用，Close时停止探测
+func (r *TinyRegistry) EnableHealthCheck(opt *HealthCheckOption) error {
+	if opt == nil {
+		opt = DefaultHealthCheckOption
+	}
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = DefaultHealthCheckOption.Interval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultHealthCheckOption.Timeout
+	}
+	if o.UnhealthyThreshold <= 0 {
+		o.UnhealthyThreshold = 1
+	}
+	if o.HealthyThreshold <= 0 {
+		o.HealthyThreshold = 1
+	}
+	if o.Prober == nil {
+		o.Prober = HandshakeProber
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.checker != nil {
+		return errors.New("rpc registry: health check already enabled")
+	}
+	c := &healthChecker{opt: &o, stop: make(chan struct{}), done: make(chan struct{})}
+	r.checker = c
+	go r.healthCheckLoop(c)
+	return nil
+}
+
+// stopHealthCheck 停止主动健康检查
+func (r *TinyRegistry) stopHealthCheck() {
+	r.mu.Lock()
+	c := r.checker
+	r.checker = nil
+	r.mu.Unlock()
+
+	if c != nil {
+		close(c.stop)
+		<-c.done
+	}
+}
+
+// healthCheckLoop 定时探测所有服务实例
+func (r *TinyRegistry) healthCheckLoop(c *healthChecker) {
+	defer close(c.done)
+
+	t := time.NewTicker(c.opt.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.probeAll(c.opt)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// probeAll 并发探测所有服务实例，并更新健康状态
+func (r *TinyRegistry) probeAll(opt *HealthCheckOption) {
+	r.mu.Lock()
+	r.sweep(time.Now())
+	addrs := make([]string, 0, len(r.servers))
+	for addr := range r.servers {
+		addrs = append(addrs, addr)
+	}
+	r.mu.Unlock()
+
+	results := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), opt.Timeout)
+			defer cancel()
+			results[i] = opt.Prober.Probe(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for i, addr := range addrs {
+		if _, ok := r.servers[addr]; !ok { // 探测期间被注销或者过期
+			continue
+		}
+		changed = r.report(addr, results[i], opt) || changed
+	}
+	// 删除已经不存在的服务实例的健康状态
+	for addr := range r.health {
+		if _, ok := r.servers[addr]; !ok {
+			delete(r.health, addr)
+		}
+	}
+	if changed {
+		r.bump()
+	}
+}
+
+// report 记录一次探测结果，返回健康状态是否发生变化，调用方需要持有锁
+func (r *TinyRegistry) report(addr string, err error, opt *HealthCheckOption) bool {
+	h, ok := r.health[addr]
+	if !ok {
+		h = &healthState{}
+		r.health[addr] = h
+	}
+	if err != nil {
+		h.successes = 0
+		h.failures++
+		if !h.unhealthy && h.failures >= opt.UnhealthyThreshold {
+			r.log().Warn("rpc registry: instance is unhealthy", "addr", addr, "err", err)
+			h.unhealthy = true
+			return true
+		}
+		return false
+	}
+	h.failures = 0
+	h.successes++
+	if h.unhealthy && h.successes >= opt.HealthyThreshold {
+		r.log().Info("rpc registry: instance is healthy again", "addr", addr)
+		h.unhealthy = false
+		return true
+	}
+	return false
+}
+
+// healthy 判断服务实例是否健康，没有启用健康检查或者没有探测过的实例视为健康，调用方需要持有锁
+func (r *TinyRegistry) healthy(addr string) bool {
+	h, ok := r.health[addr]
+	return !ok || !h.unhealthy
+}
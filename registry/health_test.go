@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"github.com/Asolmn/tinyrpc"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandshakeProber(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := tinyrpc.NewServer()
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := HandshakeProber.Probe(ctx, "tcp@"+l.Addr().String())
+	_assert(err == nil, "alive server should pass the probe, but got %v", err)
+
+	// 只接受连接但不处理请求的端口
+	wedged, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = wedged.Close() }()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = HandshakeProber.Probe(ctx, "tcp@"+wedged.Addr().String())
+	_assert(err != nil, "wedged server should fail the probe")
+}
+
+func TestTinyRegistry_HealthCheck(t *testing.T) {
+	r := New(time.Minute)
+	registry := startRegistry(t, r)
+	defer func() { _ = r.Close() }()
+
+	var failing atomic.Bool
+	var probes atomic.Int32
+	prober := ProberFunc(func(_ context.Context, addr string) error {
+		probes.Add(1)
+		if addr == "tcp@b" && failing.Load() {
+			return errors.New("wedged")
+		}
+		return nil
+	})
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@a"})
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@b"})
+	_assert(r.EnableHealthCheck(&HealthCheckOption{
+		Interval:           20 * time.Millisecond,
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+		Prober:             prober,
+	}) == nil, "failed to enable health check")
+
+	waitFor := func(want string) {
+		var got string
+		for i := 0; i < 100; i++ {
+			if got = strings.Join(getServers(t, registry), ","); got == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect %q, but got %q", want, got)
+	}
+
+	failing.Store(true)
+	index := r.Index()
+	waitFor("tcp@a")
+	_assert(r.Index() > index, "health change should bump the index")
+	_, ok := r.getServer("tcp@b")
+	_assert(ok, "unhealthy instance should still be registered")
+
+	failing.Store(false)
+	waitFor("tcp@a,tcp@b")
+	_assert(probes.Load() > 0, "prober should be called")
+}
+
+func TestTinyRegistry_HealthThreshold(t *testing.T) {
+	r := New(time.Minute)
+	opt := &HealthCheckOption{UnhealthyThreshold: 3, HealthyThreshold: 2}
+	fail := errors.New("fail")
+
+	// 偶尔失败不会被标记为不健康
+	for _, err := range []error{fail, fail, nil, fail, fail, nil} {
+		_assert(!r.report("tcp@a", err, opt), "flapping instance should stay healthy")
+	}
+	_assert(!r.report("tcp@a", fail, opt) && !r.report("tcp@a", fail, opt), "2 failures should not trip")
+	_assert(r.report("tcp@a", fail, opt) && !r.healthy("tcp@a"), "3 failures should mark unhealthy")
+	_assert(!r.report("tcp@a", nil, opt) && !r.healthy("tcp@a"), "1 success should not recover")
+	_assert(r.report("tcp@a", nil, opt) && r.healthy("tcp@a"), "2 successes should recover")
+}
+
+func TestHealthServiceProber(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := tinyrpc.NewServer()
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+	addr := "tcp@" + l.Addr().String()
+
+	probe := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return HealthServiceProber.Probe(ctx, addr)
+	}
+	_assert(probe() == nil, "server without health service should pass the probe")
+
+	health := server.EnableHealth()
+	_assert(probe() == nil, "serving server should pass the probe")
+	health.SetServingStatus("", tinyrpc.StatusNotServing)
+	_assert(probe() != nil, "NOT_SERVING server should fail the probe")
+}
+
+func TestTinyRegistry_HealthReset(t *testing.T) {
+	r := New(time.Minute)
+	opt := &HealthCheckOption{UnhealthyThreshold: 1, HealthyThreshold: 1}
+	alive := func() int {
+		return len(r.aliveInstances("", ""))
+	}
+
+	r.putServer(&Instance{Addr: "tcp@a"}, 0)
+	r.mu.Lock()
+	r.report("tcp@a", errors.New("wedged"), opt)
+	r.mu.Unlock()
+	_assert(alive() == 0, "unhealthy instance should be hidden")
+
+	// 注销之后在下一次探测之前重新注册，不沿用之前的健康检查结果
+	r.removeServer("tcp@a")
+	r.putServer(&Instance{Addr: "tcp@a"}, 0)
+	_assert(alive() == 1, "re-registered instance should not keep the unhealthy flag")
+
+	// 过期之后重新注册
+	r.mu.Lock()
+	r.report("tcp@a", errors.New("wedged"), opt)
+	r.servers["tcp@a"].start = time.Now().Add(-time.Hour)
+	r.mu.Unlock()
+	r.putServer(&Instance{Addr: "tcp@a"}, 0)
+	_assert(alive() == 1, "instance registered again after expiry should not keep the unhealthy flag")
+}
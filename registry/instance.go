@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 常用的元数据键
+const (
+	MetaWeight  = "weight"  // 负载均衡权重
+	MetaZone    = "zone"    // 所在的可用区
+	MetaVersion = "version" // 服务端版本
+	MetaTags    = "tags"    // 标签，多个标签以逗号分隔
+	MetaCodecs  = "codecs"  // 支持的编解码方式，多个以逗号分隔，例如application/gob
+)
+
+// Instance 服务实例的注册信息
+type Instance struct {
+	Addr     string            `json:"addr"`               // 服务端地址，格式为protocol@addr
+	Services []string          `json:"services,omitempty"` // 服务端注册的服务，格式为name或者name@version，为空表示提供所有服务
+	Metadata map[string]string `json:"metadata,omitempty"` // 任意元数据，例如权重、可用区、版本、标签
+
+	LastHeartbeat time.Time `json:"last_heartbeat,omitempty"` // 最后一次心跳的时间，由注册中心设置
+}
+
+// ParseInstance 解析带有元数据的服务地址，元数据以查询字符串的形式附加在地址之后
+// 例如: tcp@localhost:5000?weight=3&zone=a
+func ParseInstance(server string) *Instance {
+	addr, query, found := strings.Cut(strings.TrimSpace(server), "?")
+	inst := &Instance{Addr: addr}
+	if !found {
+		return inst
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return inst
+	}
+	for k := range values {
+		if inst.Metadata == nil {
+			inst.Metadata = make(map[string]string)
+		}
+		inst.Metadata[k] = values.Get(k)
+	}
+	return inst
+}
+
+// String 返回X-Tinyrpc-Servers中使用的地址格式，只附带权重，兼容只识别权重的客户端
+func (inst *Instance) String() string {
+	if w, ok := inst.Metadata[MetaWeight]; ok {
+		return inst.Addr + "?" + url.Values{MetaWeight: {w}}.Encode()
+	}
+	return inst.Addr
+}
+
+// Weight 返回元数据中的权重，没有设置或者设置了非法权重时返回def
+func (inst *Instance) Weight(def int) int {
+	weight, err := strconv.Atoi(inst.Metadata[MetaWeight])
+	if err != nil || weight < 0 {
+		return def
+	}
+	return weight
+}
+
+// Tags 返回元数据中的标签
+func (inst *Instance) Tags() []string {
+	var tags []string
+	for _, tag := range strings.Split(inst.Metadata[MetaTags], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HasTag 判断实例是否带有指定的标签
+func (inst *Instance) HasTag(tag string) bool {
+	for _, t := range inst.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitService 将name@version格式的服务拆分为服务名和版本
+func SplitService(service string) (name, version string) {
+	name, version, _ = strings.Cut(strings.TrimSpace(service), "@")
+	return name, version
+}
+
+// Provides 判断实例是否提供指定的服务，version为空时匹配所有版本
+// service为空时匹配提供该版本任意服务的实例，service和version都为空时匹配所有实例
+func (inst *Instance) Provides(service, version string) bool {
+	if (service == "" && version == "") || len(inst.Services) == 0 { // 没有上报服务列表的实例视为提供所有服务
+		return true
+	}
+	for _, svc := range inst.Services {
+		name, v := SplitService(svc)
+		if (service == "" || name == service) && (version == "" || v == version) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone 深拷贝实例，避免返回给调用方的实例与注册中心内部共享数据
+func (inst *Instance) clone() *Instance {
+	c := *inst
+	c.Services = append([]string(nil), inst.Services...)
+	if inst.Metadata != nil {
+		c.Metadata = make(map[string]string, len(inst.Metadata))
+		for k, v := range inst.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
+
+// equal 比较两个实例的地址、服务列表和元数据，不比较心跳时间
+func (inst *Instance) equal(other *Instance) bool {
+	if inst.Addr != other.Addr || len(inst.Services) != len(other.Services) || len(inst.Metadata) != len(other.Metadata) {
+		return false
+	}
+	for i := range inst.Services {
+		if inst.Services[i] != other.Services[i] {
+			return false
+		}
+	}
+	for k, v := range inst.Metadata {
+		if w, ok := other.Metadata[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sortInstances 按地址递增排序
+func sortInstances(instances []*Instance) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+}
@@ -0,0 +1,313 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 持久化由快照文件和追加写入的日志文件组成
+// 快照文件保存某一时刻的所有服务实例和墓碑，日志文件记录快照之后的每一次注册、心跳和注销
+// 启动时先加载快照，再重放日志，服务实例的过期时间按照最后一次心跳的时间计算
+// 生成快照时先将日志文件轮换为file + ".log.old"，快照写入成功之后再删除，
+// 进程在任意一步退出时，重放快照和两个日志文件都能恢复出完整的状态
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// defaultMaxLogSize 日志文件超过该大小时立即生成快照，避免没有设置快照间隔时日志无限增长
+const defaultMaxLogSize = 4 << 20
+
+// persistRecord 快照和日志文件中的一行记录
+type persistRecord struct {
+	Op       string        `json:"op"`
+	Index    uint64        `json:"index"`              // 记录写入时注册中心的修订号
+	Instance *Instance     `json:"instance,omitempty"` // put记录的服务实例，LastHeartbeat为注册时间
+	TTL      time.Duration `json:"ttl,omitempty"`      // put记录的过期时间
+	Addr     string        `json:"addr,omitempty"`     // delete记录的服务端地址
+	Deleted  time.Time     `json:"deleted,omitempty"`  // delete记录的注销时间，即集群复制的墓碑
+}
+
+// persister 注册中心的持久化状态
+type persister struct {
+	file    string   // 快照文件，日志文件为file + ".log"
+	log     *os.File // 日志文件
+	size    int64    // 日志文件的大小
+	maxSize int64    // 日志文件超过该大小时生成快照
+	rotated bool     // 上一次轮换的日志文件还没有被快照合并
+
+	snapshotting sync.Mutex    // 保证同一时间只有一个快照在写入
+	compact      chan struct{} // 日志文件过大时通知后台协程生成快照
+	stop         chan struct{}
+	done         chan struct{}
+	interval     time.Duration
+}
+
+// Persist 启用持久化，从file加载快照和日志，之后每隔interval生成一次快照
+// 需要在注册中心开始处理请求之前调用，interval为0时只在Close和日志文件过大时生成快照
+func (r *TinyRegistry) Persist(file string, interval time.Duration) error {
+	r.mu.Lock()
+	if r.persister != nil {
+		r.mu.Unlock()
+		return errors.New("rpc registry: persistence already enabled")
+	}
+	for _, f := range []string{file, file + ".log.old", file + ".log"} {
+		if err := r.load(f); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+	}
+	// 重启之后递增修订号，使watch请求重新获取服务列表
+	r.sweep(time.Now())
+	r.bump()
+
+	f, err := os.OpenFile(file+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	p := &persister{
+		file:     file,
+		log:      f,
+		maxSize:  defaultMaxLogSize,
+		compact:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+	if _, err = os.Stat(file + ".log.old"); err == nil {
+		p.rotated = true
+	}
+	r.persister = p
+	r.mu.Unlock()
+
+	// 合并加载的快照和日志
+	if err = r.snapshot(p); err != nil {
+		r.mu.Lock()
+		r.persister = nil
+		r.mu.Unlock()
+		_ = f.Close()
+		return err
+	}
+	go r.snapshotLoop(p)
+	return nil
+}
+
+// stopPersist 停止持久化，并生成最后一次快照
+func (r *TinyRegistry) stopPersist() error {
+	r.mu.Lock()
+	p := r.persister
+	r.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	close(p.stop)
+	<-p.done
+
+	err := r.snapshot(p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cerr := p.log.Close(); err == nil {
+		err = cerr
+	}
+	r.persister = nil
+	return err
+}
+
+// load 重放快照或者日志文件中的记录，文件不存在时忽略，调用方需要持有锁
+func (r *TinyRegistry) load(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec persistRecord
+		if err = dec.Decode(&rec); err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 日志的最后一行可能因为进程退出而不完整，忽略之后的记录
+			r.log().Warn("rpc registry: load error", "file", file, "err", err)
+			return nil
+		}
+		switch rec.Op {
+		case opPut:
+			if rec.Instance == nil || rec.Instance.Addr == "" {
+				continue
+			}
+			addr := rec.Instance.Addr
+			r.servers[addr] = &ServerItem{Instance: *rec.Instance, start: rec.Instance.LastHeartbeat, ttl: rec.TTL}
+			if deleted, ok := r.tombstones[addr]; ok && rec.Instance.LastHeartbeat.After(deleted) {
+				delete(r.tombstones, addr)
+			}
+		case opDelete:
+			// 与集群复制的墓碑相同，注销之后重新注册的服务实例不会被删除
+			// 旧版本的记录没有注销时间，直接删除
+			if s, ok := r.servers[rec.Addr]; ok && (rec.Deleted.IsZero() || !s.start.After(rec.Deleted)) {
+				r.deleteServer(rec.Addr)
+			}
+			if rec.Deleted.IsZero() {
+				break
+			}
+			if old, ok := r.tombstones[rec.Addr]; !ok || rec.Deleted.After(old) {
+				r.tombstones[rec.Addr] = rec.Deleted
+			}
+		}
+		if rec.Index > r.index {
+			r.index = rec.Index
+		}
+	}
+}
+
+// journal 将一次变更追加到日志文件，没有启用持久化时忽略，调用方需要持有锁
+// 日志文件超过maxSize时通知后台协程生成快照
+func (r *TinyRegistry) journal(rec persistRecord) {
+	p := r.persister
+	if p == nil {
+		return
+	}
+	rec.Index = r.index
+	data, err := json.Marshal(rec)
+	if err == nil {
+		var n int
+		n, err = p.log.Write(append(data, '\n'))
+		p.size += int64(n)
+	}
+	if err != nil {
+		r.log().Error("rpc registry: write log error", "err", err)
+	}
+	if p.maxSize > 0 && p.size >= p.maxSize {
+		select {
+		case p.compact <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// snapshotRecords 返回所有服务实例和墓碑的快照记录，调用方需要持有锁
+func (r *TinyRegistry) snapshotRecords() []persistRecord {
+	recs := make([]persistRecord, 0, len(r.servers)+len(r.tombstones))
+	for _, s := range r.servers {
+		recs = append(recs, persistRecord{Op: opPut, Index: r.index, Instance: s.Instance.clone(), TTL: s.ttl})
+	}
+	for addr, deleted := range r.tombstones {
+		recs = append(recs, persistRecord{Op: opDelete, Index: r.index, Addr: addr, Deleted: deleted})
+	}
+	return recs
+}
+
+// rotate 将日志文件轮换为file + ".log.old"，并打开新的日志文件，调用方需要持有锁
+// 上一次轮换的日志文件还没有被快照合并时不轮换，避免覆盖其中的记录
+func (p *persister) rotate() error {
+	if p.rotated {
+		return nil
+	}
+	name := p.file + ".log"
+	if err := os.Rename(name, name+".old"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		_ = os.Rename(name+".old", name)
+		return err
+	}
+	_ = p.log.Close()
+	p.log, p.size, p.rotated = f, 0, true
+	return nil
+}
+
+// snapshot 将所有服务实例和墓碑写入快照文件，并丢弃快照之前的日志，调用方不能持有r.mu
+// 只在锁内复制状态和轮换日志文件，写入和fsync快照文件时不阻塞注册、心跳和watch请求
+func (r *TinyRegistry) snapshot(p *persister) error {
+	p.snapshotting.Lock()
+	defer p.snapshotting.Unlock()
+
+	r.mu.Lock()
+	recs := r.snapshotRecords()
+	err := p.rotate()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = writeSnapshot(p.file, recs); err != nil {
+		return err
+	}
+	// 快照已经包含轮换之前的日志
+	if err = os.Remove(p.file + ".log.old"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r.mu.Lock()
+	p.rotated = false
+	r.mu.Unlock()
+	return nil
+}
+
+// writeSnapshot 将快照记录写入临时文件，fsync之后替换快照文件
+func writeSnapshot(file string, recs []persistRecord) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err = enc.Encode(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// snapshotLoop 定时生成快照，日志文件过大时立即生成快照
+func (r *TinyRegistry) snapshotLoop(p *persister) {
+	defer close(p.done)
+
+	var tick <-chan time.Time
+	if p.interval > 0 {
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-p.compact:
+		case <-p.stop:
+			return
+		}
+		if err := r.snapshot(p); err != nil {
+			r.log().Error("rpc registry: snapshot error", "err", err)
+		}
+	}
+}
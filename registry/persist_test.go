@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTinyRegistry_Persist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+
+	r := New(time.Minute)
+	_assert(r.Persist(file, 0) == nil, "failed to enable persistence")
+	defer func() { _ = r.Close() }() // 测试结束时才关闭，之前的步骤模拟进程退出
+	registry := startRegistry(t, r)
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@a", Services: []string{"Foo"}, Metadata: map[string]string{MetaZone: "z1"}})
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@b"})
+	_ = doJSON(t, "POST", registry+"/v1/instances", RegisterRequest{Instance: Instance{Addr: "tcp@c"}, TTL: 1}, nil)
+	_ = doJSON(t, "DELETE", registry+"/v1/instances/tcp@b", nil, nil)
+	index := r.Index()
+
+	// 不调用Close，模拟进程退出，只能从日志中恢复
+	data, _ := os.ReadFile(file + ".log")
+	_assert(strings.Count(string(data), "\n") == 4, "expect 4 log records, but got %q", data)
+
+	restarted := New(time.Minute)
+	_assert(restarted.Persist(file, time.Hour) == nil, "failed to reload")
+	_assert(restarted.Index() > index, "index should grow after restart")
+	registry = startRegistry(t, restarted)
+	got := strings.Join(getServers(t, registry+"?service=Foo"), ",")
+	_assert(got == "tcp@a,tcp@c", "expect tcp@a,tcp@c after restart, but got %s", got)
+	inst, ok := restarted.getServer("tcp@a")
+	_assert(ok && inst.Metadata[MetaZone] == "z1", "metadata should be restored, but got %+v", inst)
+	_assert(restarted.Close() == nil, "failed to close")
+
+	// 加载时按照最后一次心跳的时间计算过期时间
+	time.Sleep(time.Second + 100*time.Millisecond)
+	again := New(time.Minute)
+	_assert(again.Persist(file, 0) == nil, "failed to reload")
+	defer func() { _ = again.Close() }()
+	got = strings.Join(getServers(t, startRegistry(t, again)), ",")
+	_assert(got == "tcp@a", "tcp@c should expire, but got %s", got)
+	data, _ = os.ReadFile(file + ".log")
+	_assert(len(data) == 0, "log should be truncated after snapshot")
+}
+
+func TestTinyRegistry_PersistTombstone(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+
+	r := New(time.Minute)
+	_assert(r.Persist(file, 0) == nil, "failed to enable persistence")
+	r.putServer(&Instance{Addr: "tcp@a"}, 0)
+	registered := time.Now()
+	r.removeServer("tcp@a")
+	_assert(r.Close() == nil, "failed to close")
+
+	// 重启之后，尚未收到注销的节点推送的旧注册记录不能使服务实例复活
+	restarted := New(time.Minute)
+	_assert(restarted.Persist(file, 0) == nil, "failed to reload")
+	defer func() { _ = restarted.Close() }()
+	restarted.merge([]replicaEntry{{Instance: &Instance{Addr: "tcp@a", LastHeartbeat: registered}}})
+	_, ok := restarted.getServer("tcp@a")
+	_assert(!ok, "a deregistered instance should not come back after restart")
+}
+
+func TestTinyRegistry_PersistCompact(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+
+	r := New(time.Minute)
+	_assert(r.Persist(file, 0) == nil, "failed to enable persistence")
+	defer func() { _ = r.Close() }()
+	r.mu.Lock()
+	r.persister.maxSize = 1024
+	r.mu.Unlock()
+
+	// 没有设置快照间隔时，日志文件超过maxSize后生成快照
+	for i := 0; i < 100; i++ {
+		r.putServer(&Instance{Addr: "tcp@a"}, 0)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		info, err := os.Stat(file + ".log")
+		if err == nil && info.Size() < 1024 {
+			break
+		}
+		_assert(time.Now().Before(deadline), "log should be compacted, but got %v %v", info, err)
+		time.Sleep(time.Millisecond * 10)
+	}
+	data, _ := os.ReadFile(file)
+	_assert(strings.Contains(string(data), "tcp@a"), "snapshot should contain tcp@a, but got %q", data)
+}
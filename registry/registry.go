@@ -1,9 +1,12 @@
 package registry
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Asolmn/tinyrpc/logging"
 	"net/http"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,87 +17,281 @@ type TinyRegistry struct {
 	timeout time.Duration // 超时时间
 	mu      sync.Mutex
 	servers map[string]*ServerItem // 服务端列表
+	index   uint64                 // 修订号，服务列表每次发生变化时递增
+	changed chan struct{}          // 服务列表发生变化时关闭，并替换为新的信道，用于通知watch请求
+
+	persister  *persister              // 持久化状态，为nil表示没有启用持久化
+	cluster    *cluster                // 集群复制状态，为nil表示没有启用复制
+	tombstones map[string]time.Time    // 已经注销的服务端以及注销时间，用于集群复制
+	checker    *healthChecker          // 主动健康检查，为nil表示没有启用
+	health     map[string]*healthState // 主动健康检查的结果
+	logger     logging.Logger          // 输出日志使用的Logger，为nil时使用logging.Default()
+	path       string                  // 注册中心的挂载路径，REST API位于path+"/v1/"之下
 }
 
+// ServerItem 注册中心中的服务端
 type ServerItem struct {
-	Addr  string    // 服务端地址
-	start time.Time // 注册时间
+	Instance
+	start time.Time     // 注册时间
+	ttl   time.Duration // 实例的过期时间，为0时使用注册中心的超时时间
+}
+
+// expired 判断服务端是否已经过期
+func (s *ServerItem) expired(timeout time.Duration, now time.Time) bool {
+	if s.ttl > 0 {
+		timeout = s.ttl
+	}
+	// 条件为超时时间为0 或 服务端注册时间加上超时时间之后不超过当前时间
+	return timeout != 0 && !s.start.Add(timeout).After(now)
 }
 
 const (
 	defaultPath    = "/_tinyrpc_/registry" // 默认地址，注册中心采用HTTP协议
 	defaultTimeout = time.Minute * 5       // 默认超时时间5min，任何注册的服务端超过5min即视为不可用状态
+
+	defaultRequestTimeout = time.Second * 5 // 心跳、注销和集群复制请求的超时时间，超时后尝试下一个注册中心
 )
 
 // New 初始化注册中心
 func New(timeout time.Duration) *TinyRegistry {
 	return &TinyRegistry{
-		servers: make(map[string]*ServerItem),
-		timeout: timeout,
+		servers:    make(map[string]*ServerItem),
+		timeout:    timeout,
+		changed:    make(chan struct{}),
+		tombstones: make(map[string]time.Time),
+		health:     make(map[string]*healthState),
+		path:       defaultPath,
 	}
 }
 
+// Close 停止健康检查、集群复制和持久化，启用持久化时生成最后一次快照
+func (r *TinyRegistry) Close() error {
+	r.stopHealthCheck()
+	r.stopReplicate()
+	return r.stopPersist()
+}
+
+// SetLogger 设置注册中心输出日志使用的Logger，为nil时使用logging.Default()
+// 需要在注册中心开始处理请求之前调用
+func (r *TinyRegistry) SetLogger(l logging.Logger) {
+	r.logger = l
+}
+
+// SetPath 设置注册中心的挂载路径，只有path+"/v1/"之下的请求才由REST API处理
+// 默认为"/_tinyrpc_/registry"，HandleHTTP会自动设置，需要在注册中心开始处理请求之前调用
+func (r *TinyRegistry) SetPath(path string) {
+	r.path = strings.TrimSuffix(path, "/")
+}
+
+// log 返回注册中心输出日志使用的Logger，可以在持有锁时调用
+func (r *TinyRegistry) log() logging.Logger {
+	return logging.Or(r.logger)
+}
+
 // 默认注册中心
 var DefaultTinyRegister = New(defaultTimeout)
 
-// putServer 添加服务端实例，如果服务端已经存在，则更新start
-func (r *TinyRegistry) putServer(addr string) {
+// putServer 添加服务端实例，如果服务端已经存在，则更新start、元数据和服务列表
+// 地址中以查询字符串附带的元数据会合并到Metadata中，ttl为0时使用注册中心的超时时间
+// 返回服务端是否为新注册的实例
+func (r *TinyRegistry) putServer(inst *Instance, ttl time.Duration) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// 判断服务端是否已经在注册中心
+	// 以不包含元数据的地址作为键，同一服务端更新权重时不会重复注册
+	parsed := ParseInstance(inst.Addr)
+	item := &ServerItem{Instance: *inst.clone(), start: time.Now(), ttl: ttl}
+	item.Addr = parsed.Addr
+	for k, v := range parsed.Metadata {
+		if item.Metadata == nil {
+			item.Metadata = make(map[string]string)
+		}
+		if _, ok := item.Metadata[k]; !ok {
+			item.Metadata[k] = v
+		}
+	}
+	item.LastHeartbeat = item.start
+
+	// 服务端存在时直接覆盖，即更新注册时间、元数据和服务列表
+	old, ok := r.servers[item.Addr]
+	r.servers[item.Addr] = item
+	created := !ok || old.expired(r.timeout, item.start)
+	if created { // 重新注册的服务实例不沿用之前的健康检查结果
+		delete(r.health, item.Addr)
+	}
+	// 只是心跳时服务列表没有变化，不需要通知watch请求
+	if created || !old.Instance.equal(&item.Instance) {
+		r.bump()
+	}
+	delete(r.tombstones, item.Addr)
+	r.journal(persistRecord{Op: opPut, Instance: &item.Instance, TTL: item.ttl})
+	r.replicate(replicaEntry{Instance: item.Instance.clone(), TTL: item.ttl})
+	return created
+}
+
+// getServer 返回地址对应的可用服务实例，addr可以附带元数据
+func (r *TinyRegistry) getServer(addr string) (*Instance, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addr = ParseInstance(addr).Addr
 	s, ok := r.servers[addr]
-	if ok {
-		s.start = time.Now() // 服务端存在，则更新注册时间
-	} else {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+	if !ok {
+		return nil, false
+	}
+	if s.expired(r.timeout, time.Now()) {
+		r.deleteServer(addr)
+		r.bump()
+		return nil, false
 	}
+	return s.Instance.clone(), true
 }
 
-// aliveServers 返回可用的服务列表，如果存在超时的服务，则删除
-func (r *TinyRegistry) aliveServers() []string {
+// removeServer 删除服务实例，返回服务实例是否存在
+func (r *TinyRegistry) removeServer(addr string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	var alive []string
+	addr = ParseInstance(addr).Addr
+	now := time.Now()
+	s, ok := r.servers[addr]
+	if !ok {
+		return false
+	}
+	r.deleteServer(addr)
+	r.tombstones[addr] = now
+	r.bump()
+	r.journal(persistRecord{Op: opDelete, Addr: addr, Deleted: now})
+	r.replicate(replicaEntry{Addr: addr, Deleted: now})
+	return !s.expired(r.timeout, now)
+}
+
+// deleteServer 删除服务实例以及它的健康检查结果，调用方需要持有锁
+func (r *TinyRegistry) deleteServer(addr string) {
+	delete(r.servers, addr)
+	delete(r.health, addr)
+}
+
+// aliveInstances 返回提供指定服务的健康的可用服务实例，如果存在超时的服务，则删除
+// service为空时返回所有可用服务实例
+func (r *TinyRegistry) aliveInstances(service, version string) []*Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 删除超时的服务端
+	r.sweep(time.Now())
+
+	var alive []*Instance
 	for addr, s := range r.servers {
-		// 进行服务端可用性判断
-		// 条件为注册中心的超时时间为0 或 服务端注册时间加上超时时间之后不超过当前时间
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
-		} else {
-			delete(r.servers, addr)
+		// 跳过主动健康检查不通过的服务端
+		if s.Provides(service, version) && r.healthy(addr) {
+			alive = append(alive, s.Instance.clone())
 		}
 	}
 	// 对可用服务端按递增顺序排序
-	sort.Strings(alive)
+	sortInstances(alive)
+	return alive
+}
+
+// aliveServers 返回提供指定服务的可用服务列表，地址附带权重
+func (r *TinyRegistry) aliveServers(service, version string) []string {
+	var alive []string
+	for _, inst := range r.aliveInstances(service, version) {
+		alive = append(alive, inst.String())
+	}
 	return alive
 }
 
 func (r *TinyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	switch req.Method { // 根据请求中的GET或者POST方法，做出不同的处理
+	// 注册中心地址之下的/v1/为JSON格式的REST API
+	if prefix := r.path + apiPrefix; strings.HasPrefix(req.URL.EscapedPath(), prefix) {
+		r.serveAPI(w, req, strings.TrimPrefix(req.URL.EscapedPath(), prefix))
+		return
+	}
+
+	switch req.Method { // 根据请求中的GET、POST或者DELETE方法，做出不同的处理
 	case "GET": // 返回所有可用的服务列表，通过自定义字段X-Tinyrpc-Servers
-		w.Header().Set("X-Tinyrpc-Servers", strings.Join(r.aliveServers(), ","))
+		// 可以通过查询参数service和version，只返回提供该服务的服务端
+		// 携带index时为watch请求，等待服务列表发生变化
+		index, err := r.watch(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query := req.URL.Query()
+		instances := r.aliveInstances(query.Get("service"), query.Get("version"))
+		servers := make([]string, 0, len(instances))
+		for _, inst := range instances {
+			servers = append(servers, inst.String())
+		}
+		w.Header().Set("X-Tinyrpc-Servers", strings.Join(servers, ","))
+		w.Header().Set("X-Tinyrpc-Index", strconv.FormatUint(index, 10))
+
+		// 请求JSON格式时，在响应体中返回包含元数据的服务实例
+		if wantJSON(req) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(instancesResponse{Index: index, Instances: instances})
+		}
 
 	case "POST": // 添加服务实例或者发送心跳，通过自定义字段X-Tinyrpc-Server承载
+		// 请求体为JSON时，从请求体中获取包含元数据的服务实例
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			var inst Instance
+			if err := json.NewDecoder(req.Body).Decode(&inst); err != nil || inst.Addr == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.putServer(&inst, 0)
+			return
+		}
+
 		// 从Header中获取添加服务端的地址
 		addr := req.Header.Get("X-Tinyrpc-Server")
 		if addr == "" { // 如果地址为空，返回500报错
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		// 服务端注册的服务列表，通过自定义字段X-Tinyrpc-Services承载
+		var services []string
+		for _, svc := range strings.Split(req.Header.Get("X-Tinyrpc-Services"), ",") {
+			if svc = strings.TrimSpace(svc); svc != "" {
+				services = append(services, svc)
+			}
+		}
 		// 添加服务端到注册中心
-		r.putServer(addr)
+		r.putServer(&Instance{Addr: addr, Services: services}, 0)
+	case "DELETE": // 注销服务实例，通过自定义字段X-Tinyrpc-Server承载
+		addr := req.Header.Get("X-Tinyrpc-Server")
+		if addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !r.removeServer(addr) {
+			w.WriteHeader(http.StatusNotFound)
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// instancesResponse GET请求JSON格式的响应体
+type instancesResponse struct {
+	Index     uint64      `json:"index"` // 注册中心的修订号，用于watch请求
+	Instances []*Instance `json:"instances"`
+}
+
+// wantJSON 判断请求是否需要JSON格式的响应
+func wantJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json") || req.URL.Query().Get("format") == "json"
+}
+
 func (r *TinyRegistry) HandleHTTP(registryPath string) {
-	// 请求处理, 路由为"/_tinyrpc_/registry"
+	// 请求处理, 路由为"/_tinyrpc_/registry"，REST API的路由为"/_tinyrpc_/registry/v1/"
+	r.SetPath(registryPath)
 	http.Handle(registryPath, r)
+	http.Handle(strings.TrimSuffix(registryPath, "/")+apiPrefix, r)
 	// 日志输出rpc注册中心地址
-	log.Println("rpc registry path:", registryPath)
+	r.log().Info("rpc registry: serving", "path", registryPath)
 }
 
 func HandleHTTP() {
@@ -103,43 +300,166 @@ func HandleHTTP() {
 }
 
 // Heartbeat 定时向注册中心发送心跳，默认周期比注册中心设置的过期时间少1min
-func Heartbeat(registry, addr string, duration time.Duration) {
+// registry可以是以逗号分隔的多个注册中心地址，发送失败时依次尝试下一个注册中心
+// addr可以附带权重，例如tcp@localhost:5000?weight=3，客户端将按照权重进行加权负载均衡
+// services为服务端注册的服务，通常为Server.Services()，可以附带版本，例如Foo@v1
+// 返回的Heartbeater用于停止心跳，或者在服务端关闭时从注册中心注销
+func Heartbeat(registry, addr string, duration time.Duration, services ...string) *Heartbeater {
+	return HeartbeatInstance(registry, &Instance{Addr: addr, Services: services}, duration)
+}
+
+// HeartbeatInstance 定时向注册中心发送包含元数据的服务实例
+func HeartbeatInstance(registry string, inst *Instance, duration time.Duration) *Heartbeater {
 	if duration == 0 { // 如果间隔时间为0
 		// 发送心跳的间隔时间 = 默认超时时间 - 1分钟
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
 
-	var err error
+	h := &Heartbeater{
+		registries: ParseRegistries(registry),
+		inst:       inst.clone(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
 	// 发送心跳
-	err = sendHeartbeat(registry, addr)
-	go func() {
-		t := time.NewTicker(duration) // 创建一个定时器，并设置间隔时间
-		for err == nil {              // 进行无限循环发送心跳
-			// 从定时器通道中读取一个时间到达事件，才再次调用发送心跳函数
-			<-t.C
-			err = sendHeartbeat(registry, addr)
+	h.beat()
+	go h.run(duration)
+	return h
+}
+
+// Heartbeater 服务实例的心跳，发送失败时在下一个周期重试，直到调用Stop或者Deregister
+// 通常与Server.RegisterOnShutdown配合使用，服务端关闭时先从注册中心注销再等待请求处理完成
+//
+//	h := registry.Heartbeat(registryAddr, addr, 0, server.Services()...)
+//	server.RegisterOnShutdown(func() { _ = h.Deregister() })
+type Heartbeater struct {
+	registries []string // 注册中心地址
+	current    int      // 最近一次发送成功的注册中心，下一次优先使用
+	inst       *Instance
+	once       sync.Once
+	stop       chan struct{} // 关闭时停止发送心跳
+	done       chan struct{} // 心跳协程退出后关闭
+
+	mu     sync.Mutex
+	logger logging.Logger // 输出日志使用的Logger，为nil时使用logging.Default()
+}
+
+// SetLogger 设置输出日志使用的Logger，为nil时使用logging.Default()
+// 第一次心跳在创建Heartbeater时发送，使用logging.Default()
+func (h *Heartbeater) SetLogger(l logging.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.logger = l
+}
+
+// log 返回输出日志使用的Logger
+func (h *Heartbeater) log() logging.Logger {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return logging.Or(h.logger)
+}
+
+// beat 发送一次心跳，所有注册中心都发送失败时输出日志
+func (h *Heartbeater) beat() {
+	if err := h.send(func(registry string) error { return sendHeartbeat(registry, h.inst) }); err != nil {
+		h.log().Warn("rpc registry: heartbeat error", "addr", h.inst.Addr, "err", err)
+		return
+	}
+	h.log().Debug("rpc registry: heartbeat sent", "addr", h.inst.Addr, "registry", h.registries[h.current])
+}
+
+// send 从最近一次发送成功的注册中心开始依次尝试，直到发送成功，返回最后一个错误
+func (h *Heartbeater) send(f func(registry string) error) error {
+	err := errors.New("rpc registry: no registry address")
+	for i := 0; i < len(h.registries); i++ {
+		idx := (h.current + i) % len(h.registries)
+		if err = f(h.registries[idx]); err == nil {
+			h.current = idx
+			return nil
 		}
-	}()
+	}
+	return err
+}
 
+// run 定时发送心跳
+func (h *Heartbeater) run(duration time.Duration) {
+	defer close(h.done)
+
+	t := time.NewTicker(duration) // 创建一个定时器，并设置间隔时间
+	defer t.Stop()
+	for {
+		// 从定时器通道中读取一个时间到达事件，才再次调用发送心跳函数
+		select {
+		case <-t.C:
+			h.beat()
+		case <-h.stop:
+			return
+		}
+	}
 }
 
-// sendHeartbeat 发送心跳
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
+// Stop 停止发送心跳，服务实例在注册中心过期之前仍然可以被发现
+func (h *Heartbeater) Stop() {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+}
+
+// Deregister 停止发送心跳，并从注册中心注销服务实例
+func (h *Heartbeater) Deregister() error {
+	h.Stop()
+	if err := h.send(func(registry string) error { return sendDeregister(registry, h.inst.Addr) }); err != nil {
+		h.log().Warn("rpc registry: deregister error", "addr", h.inst.Addr, "err", err)
+		return err
+	}
+	h.log().Debug("rpc registry: deregistered", "addr", h.inst.Addr, "registry", h.registries[h.current])
+	return nil
+}
 
+// sendHeartbeat 发送心跳
+func sendHeartbeat(registry string, inst *Instance) error {
 	// 创建一个用于发送http请求的客户端
-	httpClient := &http.Client{}
+	httpClient := &http.Client{Timeout: defaultRequestTimeout}
 
-	// 创建一个post请求
-	req, _ := http.NewRequest("POST", registry, nil)
-	// 设置post的请求头中X-Tinyrpc-Server字段，用于承载服务端地址
-	req.Header.Set("X-Tinyrpc-Server", addr)
+	// 创建一个post请求，请求体为包含元数据的服务实例
+	body, _ := json.Marshal(inst)
+	req, _ := http.NewRequest("POST", registry, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// 同时设置post的请求头中X-Tinyrpc-Server字段，用于承载服务端地址，兼容只识别请求头的注册中心
+	req.Header.Set("X-Tinyrpc-Server", inst.String())
+	// 设置post的请求头中X-Tinyrpc-Services字段，用于承载服务端注册的服务列表
+	if len(inst.Services) > 0 {
+		req.Header.Set("X-Tinyrpc-Services", strings.Join(inst.Services, ","))
+	}
 
 	// 发送心跳请求
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	// 注册中心返回错误时也尝试下一个注册中心
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.New("rpc registry: heart beat " + resp.Status)
+	}
+	return nil
+}
+
+// sendDeregister 从注册中心注销服务实例
+func sendDeregister(registry, addr string) error {
+	req, _ := http.NewRequest("DELETE", registry, nil)
+	req.Header.Set("X-Tinyrpc-Server", addr)
+	resp, err := (&http.Client{Timeout: defaultRequestTimeout}).Do(req)
+	if err != nil {
 		return err
 	}
+	_ = resp.Body.Close()
 
+	// 服务实例不存在时视为已经注销
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return errors.New("rpc registry: deregister " + addr + ": " + resp.Status)
+	}
 	return nil
 }
@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// startRegistry 启动一个注册中心，返回注册中心的地址
+func startRegistry(t *testing.T, r *TinyRegistry) string {
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts.URL + defaultPath
+}
+
+// getServers 通过X-Tinyrpc-Servers获取服务端列表
+func getServers(t *testing.T, registry string) []string {
+	resp, err := http.Get(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if s := resp.Header.Get("X-Tinyrpc-Servers"); s != "" {
+		return strings.Split(s, ",")
+	}
+	return nil
+}
+
+func TestTinyRegistry_Services(t *testing.T) {
+	registry := startRegistry(t, New(time.Minute))
+
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@a", Services: []string{"Foo", "Bar@v1"}})
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@b", Services: []string{"Bar@v2"}})
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@c"}) // 没有上报服务列表，视为提供所有服务
+
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"", "tcp@a,tcp@b,tcp@c"},
+		{"?service=Foo", "tcp@a,tcp@c"},
+		{"?service=Bar", "tcp@a,tcp@b,tcp@c"},
+		{"?service=Bar&version=v2", "tcp@b,tcp@c"},
+		{"?service=Baz", "tcp@c"},
+	}
+	for _, c := range cases {
+		got := strings.Join(getServers(t, registry+c.query), ",")
+		_assert(got == c.want, "GET %s: expect %s, but got %s", c.query, c.want, got)
+	}
+}
+
+func TestTinyRegistry_Metadata(t *testing.T) {
+	registry := startRegistry(t, New(time.Minute))
+
+	_ = sendHeartbeat(registry, &Instance{
+		Addr:     "tcp@a",
+		Services: []string{"Foo"},
+		Metadata: map[string]string{MetaWeight: "3", MetaZone: "z1", MetaTags: "canary, ssd"},
+	})
+	// 旧版本的请求头协议，元数据附加在地址之后
+	req, _ := http.NewRequest("POST", registry, nil)
+	req.Header.Set("X-Tinyrpc-Server", "tcp@b?weight=2&zone=z2")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "legacy register failed: %v", err)
+	_ = resp.Body.Close()
+
+	got := strings.Join(getServers(t, registry), ",")
+	_assert(got == "tcp@a?weight=3,tcp@b?weight=2", "X-Tinyrpc-Servers should keep weights, but got %s", got)
+
+	resp, err = http.Get(registry + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var body instancesResponse
+	_assert(json.NewDecoder(resp.Body).Decode(&body) == nil, "failed to decode json body")
+	_assert(len(body.Instances) == 2, "expect 2 instances, but got %d", len(body.Instances))
+
+	a, b := body.Instances[0], body.Instances[1]
+	_assert(a.Addr == "tcp@a" && a.Metadata[MetaZone] == "z1" && a.Weight(1) == 3, "unexpected instance %+v", a)
+	_assert(a.HasTag("ssd") && !a.HasTag("ssd, canary"), "unexpected tags %v", a.Tags())
+	_assert(b.Addr == "tcp@b" && b.Metadata[MetaZone] == "z2" && b.Weight(1) == 2, "unexpected instance %+v", b)
+	_assert(!a.LastHeartbeat.IsZero(), "last heartbeat should be set")
+}
+
+func TestHeartbeater_Deregister(t *testing.T) {
+	registry := startRegistry(t, New(time.Minute))
+
+	a := Heartbeat(registry, "tcp@a", 10*time.Millisecond, "Foo")
+	b := Heartbeat(registry, "tcp@b", 10*time.Millisecond, "Foo")
+	got := strings.Join(getServers(t, registry), ",")
+	_assert(got == "tcp@a,tcp@b", "expect tcp@a,tcp@b, but got %s", got)
+
+	_assert(a.Deregister() == nil, "failed to deregister tcp@a")
+	time.Sleep(50 * time.Millisecond) // 停止之后不会再次注册
+	got = strings.Join(getServers(t, registry), ",")
+	_assert(got == "tcp@b", "expect tcp@b, but got %s", got)
+
+	// 已经注销的实例再次注销不返回错误
+	_assert(a.Deregister() == nil, "deregister twice should not fail")
+	b.Stop()
+	got = strings.Join(getServers(t, registry), ",")
+	_assert(got == "tcp@b", "stopped instance should be kept until it expires, but got %s", got)
+}
+
+func TestTinyRegistry_Watch(t *testing.T) {
+	r := New(time.Minute)
+	registry := startRegistry(t, r)
+
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@a"})
+	index := r.Index()
+	// 只是心跳时修订号不变
+	_ = sendHeartbeat(registry, &Instance{Addr: "tcp@a"})
+	_assert(r.Index() == index, "heartbeat should not change the index")
+
+	watch := func(wait string) (instancesResponse, time.Duration) {
+		start := time.Now()
+		var body instancesResponse
+		_ = doJSON(t, "GET", fmt.Sprintf("%s/v1/instances?index=%d&wait=%s", registry, index, wait), nil, &body)
+		return body, time.Since(start)
+	}
+
+	// 没有变化时等待超时后返回
+	body, cost := watch("100ms")
+	_assert(body.Index == index && cost >= 100*time.Millisecond, "watch should block until timeout, got index %d after %s", body.Index, cost)
+
+	// 服务列表变化时立即返回
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = sendHeartbeat(registry, &Instance{Addr: "tcp@b"})
+	}()
+	body, cost = watch("5s")
+	_assert(body.Index > index && len(body.Instances) == 2 && cost < time.Second, "watch should return on change, got %+v after %s", body, cost)
+
+	// 服务端过期时也会返回
+	_ = doJSON(t, "POST", registry+"/v1/instances", RegisterRequest{Instance: Instance{Addr: "tcp@c"}, TTL: 1}, nil)
+	index = r.Index()
+	body, cost = watch("5s")
+	_assert(body.Index > index && len(body.Instances) == 2 && cost < 2*time.Second, "watch should return on expiry, got %+v after %s", body, cost)
+}
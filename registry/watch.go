@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// watch请求通过查询参数index携带上一次响应的修订号，注册中心在修订号发生变化或者等待超时后返回
+// 响应中通过X-Tinyrpc-Index和响应体中的index返回当前的修订号
+//
+//	GET <path>/v1/instances?service=Foo&index=12&wait=30s
+const (
+	defaultWatchWait = time.Second * 30 // 没有指定wait时的等待时间
+	maxWatchWait     = time.Minute * 5  // 最长等待时间
+)
+
+// Index 返回注册中心当前的修订号
+func (r *TinyRegistry) Index() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.index
+}
+
+// bump 递增修订号，并通知正在等待的watch请求，调用方需要持有锁
+func (r *TinyRegistry) bump() {
+	r.index++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// sweep 删除超时的服务端和墓碑，调用方需要持有锁
+func (r *TinyRegistry) sweep(now time.Time) {
+	removed := false
+	for key, s := range r.servers {
+		if s.expired(r.timeout, now) {
+			r.deleteServer(key)
+			removed = true
+		}
+	}
+	if removed {
+		r.bump()
+	}
+	for addr, deleted := range r.tombstones {
+		if deleted.Add(r.tombstoneTTL()).Before(now) {
+			delete(r.tombstones, addr)
+		}
+	}
+}
+
+// nextExpiry 返回最早过期的服务端的过期时间，没有会过期的服务端时返回零值，调用方需要持有锁
+func (r *TinyRegistry) nextExpiry() time.Time {
+	var next time.Time
+	for _, s := range r.servers {
+		timeout := r.timeout
+		if s.ttl > 0 {
+			timeout = s.ttl
+		}
+		if timeout == 0 {
+			continue
+		}
+		if expiry := s.start.Add(timeout); next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+	return next
+}
+
+// waitIndex 等待修订号不等于index，服务端过期也会使修订号变化
+// 等待超时或者ctx结束时返回当前的修订号
+func (r *TinyRegistry) waitIndex(ctx context.Context, index uint64, wait time.Duration) uint64 {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		r.mu.Lock()
+		r.sweep(time.Now())
+		current, changed, next := r.index, r.changed, r.nextExpiry()
+		r.mu.Unlock()
+
+		// 注册中心重启之后修订号可能变小，因此判断是否不相等
+		if current != index {
+			return current
+		}
+
+		// 最早过期的服务端过期时，重新检查
+		var expiry *time.Timer
+		var expired <-chan time.Time
+		if !next.IsZero() {
+			expiry = time.NewTimer(time.Until(next))
+			expired = expiry.C
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		case <-timeout.C:
+			return current
+		case <-ctx.Done():
+			return current
+		}
+		if expiry != nil {
+			expiry.Stop()
+		}
+	}
+}
+
+// watch 如果请求携带了index，则等待修订号发生变化，返回用于响应的修订号
+// wait的格式为time.ParseDuration支持的格式，例如30s，缺省时为30s，最长为5min
+func (r *TinyRegistry) watch(req *http.Request) (uint64, error) {
+	query := req.URL.Query()
+	if query.Get("index") == "" {
+		return r.Index(), nil
+	}
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	wait := defaultWatchWait
+	if s := query.Get("wait"); s != "" {
+		if wait, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if wait > maxWatchWait {
+		wait = maxWatchWait
+	}
+	return r.waitIndex(req.Context(), index, wait), nil
+}
@@ -0,0 +1,374 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/codec"
+	"html/template"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestTraceOption 请求追踪的配置
+type RequestTraceOption struct {
+	Size          int           // 每个方法保留的最近请求数和慢请求数，默认为100
+	SampleRate    float64       // 采样率，取值范围为(0, 1]，默认为1表示记录所有请求，慢请求不受采样率影响
+	SlowThreshold time.Duration // 处理时间不小于该值的请求为慢请求，单独保留并在页面中高亮，默认为100ms
+}
+
+// 请求追踪的默认配置
+const (
+	defaultTraceSize          = 100
+	defaultTraceSlowThreshold = 100 * time.Millisecond
+)
+
+// requestTraces 最近处理的请求，每个方法分别保留最近的请求和慢请求
+// 类似于net/trace的/debug/requests，只记录交给服务方法处理的请求
+type requestTraces struct {
+	opt RequestTraceOption
+
+	mu      sync.Mutex
+	seq     uint64                   // 请求的编号
+	active  map[uint64]*requestTrace // 被采样的正在处理的请求
+	methods map[string]*methodTraces // 以服务名.方法名为键
+}
+
+// methodTraces 一个方法已经完成的请求
+type methodTraces struct {
+	recent traceRing // 被采样的请求
+	slow   traceRing // 慢请求，不会被大量的快请求挤出
+}
+
+// traceRing 固定大小的环形缓冲区，写满之后覆盖最早的请求
+type traceRing struct {
+	buf  []*requestTrace
+	next int // 下一个写入的位置
+}
+
+// add 添加一个请求，size为缓冲区的大小
+func (r *traceRing) add(t *requestTrace, size int) {
+	if len(r.buf) < size {
+		r.buf = append(r.buf, t)
+		return
+	}
+	r.buf[r.next] = t
+	r.next = (r.next + 1) % size
+}
+
+// list 返回缓冲区中的请求，最新的在前
+func (r *traceRing) list() []*requestTrace {
+	traces := make([]*requestTrace, 0, len(r.buf))
+	for i := len(r.buf) - 1; i >= 0; i-- {
+		traces = append(traces, r.buf[(r.next+i)%len(r.buf)])
+	}
+	return traces
+}
+
+// requestTrace 一个请求的记录，完成之后的字段在finish中设置
+type requestTrace struct {
+	traces      *requestTraces
+	id          uint64
+	method      string
+	peer        string
+	start       time.Time
+	requestSize int64
+	sampled     bool // 是否被采样，没有被采样的请求只有在成为慢请求时才被记录
+
+	duration     time.Duration
+	err          string
+	responseSize int64
+}
+
+// newRequestTraces 根据配置创建请求追踪，没有设置的字段使用默认值
+func newRequestTraces(opt *RequestTraceOption) *requestTraces {
+	var o RequestTraceOption
+	if opt != nil {
+		o = *opt
+	}
+	if o.Size <= 0 {
+		o.Size = defaultTraceSize
+	}
+	if o.SampleRate <= 0 || o.SampleRate > 1 {
+		o.SampleRate = 1
+	}
+	if o.SlowThreshold <= 0 {
+		o.SlowThreshold = defaultTraceSlowThreshold
+	}
+	return &requestTraces{
+		opt:     o,
+		active:  make(map[uint64]*requestTrace),
+		methods: make(map[string]*methodTraces),
+	}
+}
+
+// begin 开始记录一个请求，r为nil时返回nil
+func (r *requestTraces) begin(method, peer string, requestSize int64) *requestTrace {
+	if r == nil {
+		return nil
+	}
+	t := &requestTrace{
+		traces:      r,
+		method:      method,
+		peer:        peer,
+		start:       time.Now(),
+		requestSize: requestSize,
+		sampled:     r.opt.SampleRate >= 1 || rand.Float64() < r.opt.SampleRate,
+	}
+	if t.sampled {
+		r.mu.Lock()
+		r.seq++
+		t.id = r.seq
+		r.active[t.id] = t
+		r.mu.Unlock()
+	}
+	return t
+}
+
+// finish 记录请求的结果，err为nil表示调用成功，t为nil时不记录
+func (t *requestTrace) finish(err error, responseSize int64) {
+	if t == nil {
+		return
+	}
+	r := t.traces
+	duration := time.Since(t.start)
+	slow := duration >= r.opt.SlowThreshold
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.sampled {
+		delete(r.active, t.id)
+	} else if !slow {
+		return
+	} else {
+		r.seq++
+		t.id = r.seq
+	}
+	t.duration, t.responseSize = duration, responseSize
+	if err != nil {
+		t.err = err.Error()
+	}
+
+	mt := r.methods[t.method]
+	if mt == nil {
+		mt = new(methodTraces)
+		r.methods[t.method] = mt
+	}
+	if t.sampled {
+		mt.recent.add(t, r.opt.Size)
+	}
+	if slow {
+		mt.slow.add(t, r.opt.Size)
+	}
+}
+
+// EnableRequestTrace 记录最近处理的请求，opt为nil时使用默认配置
+// 需要在开始接受连接和调用HandleHTTP之前调用，HandleHTTP会在/debug/tinyrpc/requests展示这些请求
+func (server *Server) EnableRequestTrace(opt *RequestTraceOption) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.traces = newRequestTraces(opt)
+}
+
+// bytesRead 返回编解码器已经读取的字节数，编解码器不支持统计时返回0
+func bytesRead(cc codec.Codec) int64 {
+	if c, ok := cc.(codec.ByteCounter); ok {
+		return c.BytesRead()
+	}
+	return 0
+}
+
+// bytesWritten 返回编解码器已经写入的字节数，编解码器不支持统计时返回0
+func bytesWritten(cc codec.Codec) int64 {
+	if c, ok := cc.(codec.ByteCounter); ok {
+		return c.BytesWritten()
+	}
+	return 0
+}
+
+const requestsText = `<html>
+	<head>
+	<title>tinyrpc Requests</title>
+	<style>
+	table { border-collapse: collapse; }
+	td, th { padding: 2px 8px; }
+	tr.slow { color: #c00; font-weight: bold; }
+	</style>
+	</head>
+	<body>
+	Sample rate {{.SampleRate}}, slow requests take at least {{.SlowThreshold}}
+	<hr>
+	<table>
+	<th align=left>Method</th><th align=center>In flight</th><th align=center>Recent</th><th align=center>Slow</th>
+	{{range .Methods}}
+		<tr>
+		<td align=left><a href="?method={{.Name}}&slow={{$.SlowThreshold}}">{{.Name}}</a></td>
+		<td align=center>{{len .Active}}</td>
+		<td align=center>{{len .Recent}}</td>
+		<td align=center>{{len .Slow}}</td>
+		</tr>
+	{{end}}
+	</table>
+	{{range .Methods}}
+	<hr>
+	Method {{.Name}}
+	{{template "requests" (section "In flight" .Active)}}
+	{{template "requests" (section "Slow" .Slow)}}
+	{{template "requests" (section "Recent" .Recent)}}
+	{{end}}
+	</body>
+	</html>
+{{define "requests"}}{{if .Requests}}
+	<h4>{{.Title}}</h4>
+	<table>
+	<th align=center>Start</th><th align=center>Duration</th><th align=left>Peer</th><th align=center>Request bytes</th><th align=center>Response bytes</th><th align=left>Error</th>
+	{{range .Requests}}
+		<tr{{if .Slow}} class="slow"{{end}}>
+		<td align=center>{{.Start.Format "15:04:05.000000"}}</td>
+		<td align=right>{{.Duration}}</td>
+		<td align=left>{{.Peer}}</td>
+		<td align=right>{{.RequestSize}}</td>
+		<td align=right>{{.ResponseSize}}</td>
+		<td align=left>{{.Error}}</td>
+		</tr>
+	{{end}}
+	</table>
+{{end}}{{end}}`
+
+var requestsTemplate = template.Must(template.New("RPC requests").Funcs(template.FuncMap{
+	// section 将标题和请求列表组合起来，传给requests模板
+	"section": func(title string, requests []debugRequest) interface{} {
+		return struct {
+			Title    string
+			Requests []debugRequest
+		}{title, requests}
+	},
+}).Parse(requestsText))
+
+// debugRequests 请求追踪页面的数据，时间的单位为纳秒
+type debugRequests struct {
+	SampleRate    float64              `json:"sample_rate"`
+	SlowThreshold time.Duration        `json:"slow_threshold"`
+	Methods       []debugRequestMethod `json:"methods"`
+}
+
+// debugRequestMethod 一个方法正在处理的请求、慢请求和最近的请求，最新的在前
+type debugRequestMethod struct {
+	Name   string         `json:"name"`
+	Active []debugRequest `json:"active"`
+	Slow   []debugRequest `json:"slow"`
+	Recent []debugRequest `json:"recent"`
+}
+
+// debugRequest 一个请求，正在处理的请求的Duration为目前经过的时间
+type debugRequest struct {
+	ID           uint64        `json:"id"`
+	Start        time.Time     `json:"start"`
+	Duration     time.Duration `json:"duration"`
+	Peer         string        `json:"peer"`
+	RequestSize  int64         `json:"request_size"`
+	ResponseSize int64         `json:"response_size"`
+	Error        string        `json:"error,omitempty"`
+	Slow         bool          `json:"slow"` // 处理时间不小于页面的慢请求阈值
+}
+
+// snapshot 返回请求追踪的数据，method不为空时只包含该方法，慢请求按照slow高亮
+func (r *requestTraces) snapshot(method string, slow time.Duration) *debugRequests {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	convert := func(traces []*requestTrace, active bool) []debugRequest {
+		requests := make([]debugRequest, 0, len(traces))
+		for _, t := range traces {
+			d := t.duration
+			if active {
+				d = now.Sub(t.start)
+			}
+			requests = append(requests, debugRequest{
+				ID:           t.id,
+				Start:        t.start,
+				Duration:     d,
+				Peer:         t.peer,
+				RequestSize:  t.requestSize,
+				ResponseSize: t.responseSize,
+				Error:        t.err,
+				Slow:         d >= slow,
+			})
+		}
+		return requests
+	}
+
+	// 正在处理的请求按方法分组，开始时间较晚的在前
+	active := make(map[string][]*requestTrace)
+	for _, t := range r.active {
+		active[t.method] = append(active[t.method], t)
+	}
+	names := make(map[string]bool)
+	for name := range r.methods {
+		names[name] = true
+	}
+	for name, traces := range active {
+		names[name] = true
+		sort.Slice(traces, func(i, j int) bool { return traces[i].id > traces[j].id })
+	}
+
+	info := &debugRequests{
+		SampleRate:    r.opt.SampleRate,
+		SlowThreshold: slow,
+		Methods:       []debugRequestMethod{},
+	}
+	for name := range names {
+		if method != "" && name != method {
+			continue
+		}
+		dm := debugRequestMethod{Name: name, Active: convert(active[name], true)}
+		if mt := r.methods[name]; mt != nil {
+			dm.Slow = convert(mt.slow.list(), false)
+			dm.Recent = convert(mt.recent.list(), false)
+		} else {
+			dm.Slow, dm.Recent = []debugRequest{}, []debugRequest{}
+		}
+		info.Methods = append(info.Methods, dm)
+	}
+	sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+	return info
+}
+
+// serveRequests 展示最近处理的请求，没有启用时返回404
+// 支持的查询参数：method只展示该方法，slow覆盖高亮的阈值，例如slow=250ms，format=json返回JSON
+func (server *Server) serveRequests(w http.ResponseWriter, req *http.Request) {
+	server.mu.Lock()
+	traces := server.traces
+	server.mu.Unlock()
+
+	if traces == nil {
+		http.Error(w, "rpc server: request trace not enabled", http.StatusNotFound)
+		return
+	}
+	query := req.URL.Query()
+	slow := traces.opt.SlowThreshold
+	if s := query.Get("slow"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "rpc server: invalid slow threshold: "+s, http.StatusBadRequest)
+			return
+		}
+		slow = d
+	}
+
+	info := traces.snapshot(query.Get("method"), slow)
+	if query.Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+		return
+	}
+	if err := requestsTemplate.Execute(w, info); err != nil {
+		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+	}
+}
@@ -0,0 +1,75 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTrace(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+	server.EnableRequestTrace(&RequestTraceOption{Size: 2, SlowThreshold: 100 * time.Millisecond})
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	for _, ms := range []int{150, 1, 2, 3} {
+		_assert(client.Call(context.Background(), "Slow.Sleep", ms, &reply) == nil, "call failed")
+	}
+	call := client.Go("Slow.Sleep", 500, &reply, make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+
+	snapshot := func(url string) *debugRequests {
+		rec := httptest.NewRecorder()
+		server.serveRequests(rec, httptest.NewRequest("GET", url, nil))
+		_assert(rec.Code == 200 && rec.Header().Get("Content-Type") == "application/json", "expect json response, but got %d", rec.Code)
+		var info debugRequests
+		_assert(json.NewDecoder(rec.Body).Decode(&info) == nil, "invalid json")
+		return &info
+	}
+
+	info := snapshot("/debug/tinyrpc/requests?format=json")
+	_assert(len(info.Methods) == 1 && info.Methods[0].Name == "Slow.Sleep", "unexpected methods %+v", info.Methods)
+	m := info.Methods[0]
+	// 环形缓冲区只保留最近的两个请求，最新的在前，慢请求单独保留
+	_assert(len(m.Recent) == 2 && m.Recent[0].ID > m.Recent[1].ID && !m.Recent[0].Slow, "unexpected recent requests %+v", m.Recent)
+	_assert(len(m.Slow) == 1 && m.Slow[0].Slow && m.Slow[0].Duration >= 150*time.Millisecond, "unexpected slow requests %+v", m.Slow)
+	_assert(len(m.Active) == 1 && m.Active[0].ResponseSize == 0, "unexpected active requests %+v", m.Active)
+	r := m.Recent[0]
+	_assert(r.Peer != "" && r.RequestSize > 0 && r.ResponseSize > 0 && r.Error == "", "unexpected request %+v", r)
+
+	// 降低阈值之后，所有请求都被高亮，过滤不存在的方法时为空
+	info = snapshot("/debug/tinyrpc/requests?format=json&slow=1ns")
+	_assert(info.Methods[0].Recent[0].Slow && info.Methods[0].Active[0].Slow, "expect all requests highlighted")
+	info = snapshot("/debug/tinyrpc/requests?format=json&method=Foo.Bar")
+	_assert(len(info.Methods) == 0, "expect no methods, but got %d", len(info.Methods))
+
+	rec := httptest.NewRecorder()
+	server.serveRequests(rec, httptest.NewRequest("GET", "/debug/tinyrpc/requests", nil))
+	body := rec.Body.String()
+	_assert(strings.Contains(body, "tinyrpc Requests") && strings.Contains(body, `class="slow"`), "unexpected html")
+	<-call.Done
+
+	rec = httptest.NewRecorder()
+	NewServer().serveRequests(rec, httptest.NewRequest("GET", "/debug/tinyrpc/requests", nil))
+	_assert(rec.Code == 404, "expect 404 when request trace is not enabled, but got %d", rec.Code)
+}
+
+func TestRequestTrace_Sampling(t *testing.T) {
+	traces := newRequestTraces(&RequestTraceOption{SampleRate: 1e-9, SlowThreshold: 10 * time.Millisecond})
+	traces.begin("Slow.Sleep", "", 0).finish(nil, 0)
+	slow := traces.begin("Slow.Sleep", "", 0)
+	time.Sleep(20 * time.Millisecond)
+	slow.finish(errors.New("boom"), 0)
+
+	// 没有被采样的请求不会出现在最近的请求中，但是慢请求总是被记录
+	m := traces.snapshot("", traces.opt.SlowThreshold).Methods
+	_assert(len(m) == 1 && len(m[0].Recent) == 0 && len(m[0].Active) == 0, "unexpected methods %+v", m)
+	_assert(len(m[0].Slow) == 1 && m[0].Slow[0].Error == "boom", "unexpected slow requests %+v", m[0].Slow)
+}
@@ -1,17 +1,23 @@
 package tinyrpc
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/metrics"
+	"github.com/Asolmn/tinyrpc/tracing"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +31,11 @@ type Option struct {
 	CodecType      codec.Type // 客户端选择不同的编解码器堆正文进行编码
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+
+	// 以下字段只在本地使用，不参与协议交换
+	Metrics *metrics.Registry `json:"-"` // 客户端在其中记录每个服务端的调用指标，为nil表示不记录
+	Tracer  *tracing.Tracer   `json:"-"` // 客户端为每次调用和建立连接创建span，为nil表示不追踪
+	Logger  logging.Logger    `json:"-"` // 客户端输出日志使用的Logger，为nil时使用logging.Default()
 }
 
 /*
@@ -41,11 +52,78 @@ var DefaultOption = &Option{
 // Server rpc服务器实例，包含一个service哈希表
 type Server struct {
 	serviceMap sync.Map
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{} // Accept使用的监听器，关闭时停止接受连接
+	conns      map[*serverConn]struct{}  // 正在服务的连接
+	onShutdown []func()                  // 关闭时，在等待请求处理完成之前调用
+	inShutdown atomic.Bool               // 是否正在关闭
+	health     *Health                   // 内置的健康检查服务，为nil表示没有启用
+	metricsReg *metrics.Registry         // 记录指标的集合，为nil表示没有启用
+	metrics    *serverMetrics            // 服务器的指标
+	traces     *requestTraces            // 最近处理的请求，为nil表示没有启用
+	tracer     *tracing.Tracer           // 为每个请求创建span，为nil表示没有启用
+	logger     logging.Logger            // 输出日志使用的Logger，为nil时使用logging.Default()
+	started    time.Time                 // 服务器的创建时间，用于计算运行时间
+}
+
+// 服务端返回给客户端的错误，客户端收到的ServerError可以通过errors.Is判断
+var (
+	ErrServerShutdown   = errors.New("rpc server: server is shutting down")           // 服务器正在关闭，不再处理新的请求
+	ErrIllFormedRequest = errors.New("rpc server: service/method request ill-formed") // ServiceMethod的格式不是<service>.<method>
+	ErrServiceNotFound  = errors.New("rpc server: can't find service")                // 服务没有注册
+	ErrMethodNotFound   = errors.New("rpc server: can't find method")                 // 服务没有该方法
+	ErrHandleTimeout    = errors.New("rpc server: request handle timeout")            // 处理请求超过了HandleTimeout
+)
+
+// serverConn 服务器上的一个连接，记录正在处理的请求数
+type serverConn struct {
+	rwc        io.Closer
+	remoteAddr string    // 客户端地址，连接不是net.Conn时为空
+	since      time.Time // 建立连接的时间
+	mu         sync.Mutex
+	codec      codec.Type // 协议交换之后确定的编解码方式
+	requests   uint64     // 已经开始处理的请求数
+	active     int        // 正在处理的请求数
+	closed     bool       // 连接是否已经被服务器关闭
+}
+
+// begin 开始处理一个请求，连接已经被关闭时返回false
+func (c *serverConn) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.requests++
+	c.active++
+	return true
+}
+
+// end 请求处理完成
+func (c *serverConn) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active--
+}
+
+// closeIfIdle 连接上没有正在处理的请求时关闭连接，返回连接是否已经关闭
+func (c *serverConn) closeIfIdle(force bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed && (c.active == 0 || force) {
+		c.closed = true
+		_ = c.rwc.Close()
+	}
+	return c.closed
 }
 
 // NewServer 返回一个新的Server
 func NewServer() *Server {
-	return &Server{}
+	return &Server{started: time.Now()}
 }
 
 var DefaultServer *Server = NewServer() // Server的默认实例
@@ -55,16 +133,40 @@ var DefaultServer *Server = NewServer() // Server的默认实例
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 
 	defer func() { _ = conn.Close() }()
+	sc, ok := server.trackConn(conn)
+	if !ok { // 服务器正在关闭，不再接受新的连接
+		return
+	}
+	defer server.untrackConn(sc)
+
+	server.mu.Lock()
+	m, traces, tracer := server.metrics, server.traces, server.tracer
+	server.mu.Unlock()
+	if m != nil {
+		conn = &countingConn{ReadWriteCloser: conn, m: m}
+		m.connections.Inc()
+		defer m.connections.Dec()
+	}
+
+	// 启用追踪时记录协议交换的时间
+	_, hs := tracer.Start(context.Background(), "tinyrpc.Handshake", tracing.KindServer)
+	hs.SetAttribute(tracing.AttrPeer, sc.remoteAddr)
+
 	var opt Option
 
 	// 通过json.NewDecoder反序列化得到Option实例
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error:", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		server.log().Warn("rpc server: options error", "remote", sc.remoteAddr, "err", err)
+		m.handshakeFailed("options")
+		hs.End(err)
 		return
 	}
 	// 检查是否是tinyrpc的请求标记
 	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+		server.log().Warn("rpc server: invalid magic number", "remote", sc.remoteAddr, "magic", fmt.Sprintf("%x", opt.MagicNumber))
+		m.handshakeFailed("magic_number")
+		hs.End(fmt.Errorf("rpc server: invalid magic number %x", opt.MagicNumber))
 		return
 	}
 
@@ -74,13 +176,50 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	// 实际返回的gob.go中的NewGobCodec函数
 	var f codec.NewCodecFunc = codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+		server.log().Warn("rpc server: invalid codec type", "remote", sc.remoteAddr, "codec", opt.CodecType)
+		m.handshakeFailed("codec")
+		hs.End(fmt.Errorf("rpc server: invalid codec type %s", opt.CodecType))
 		return
 	}
+	sc.mu.Lock()
+	sc.codec = opt.CodecType
+	sc.mu.Unlock()
+	hs.SetAttribute(tracing.AttrCodec, string(opt.CodecType))
+	hs.End(nil)
+
 	// f(conn)返回一个GobCodec实例,等价于直接调用NewGobCodec(conn)
-	server.serveCodec(f(conn), &opt)
+	server.serveCodec(newCodec(f, handshakeConn(dec, conn), server.log()), &opt, sc, m, traces, tracer)
+}
+
+// newCodec 使用f创建编解码器，编解码器实现了codec.LoggerSetter时，编码错误输出到l
+func newCodec(f codec.NewCodecFunc, conn io.ReadWriteCloser, l logging.Logger) codec.Codec {
+	cc := f(conn)
+	if ls, ok := cc.(codec.LoggerSetter); ok {
+		ls.SetLogger(l)
+	}
+	return cc
 }
 
+// handshakeConn 返回协议交换之后交给编解码器的连接
+// json.Decoder读取Option时会按块预读，客户端在Option之后立即发送的请求可能已经被读入它的缓冲区，
+// 直接把conn交给编解码器会丢失这部分数据，导致第一个请求没有响应，因此先读取缓冲区中剩余的数据
+// 同时跳过json.Encoder在Option之后追加的换行符
+func handshakeConn(dec *json.Decoder, conn io.ReadWriteCloser) io.ReadWriteCloser {
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	return &bufferedConn{Reader: r, ReadWriteCloser: conn}
+}
+
+// bufferedConn 读取时优先读取已经被缓冲的数据，写入和关闭直接作用于原连接
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
 // 发生错误时响应argv的占位符
 var invalidRequest = struct{}{}
 
@@ -90,7 +229,7 @@ serveCodec 主要阶段
 处理请求handleRequest
 回复请求sendResponse
 */
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
+func (server *Server) serveCodec(cc codec.Codec, opt *Option, sc *serverConn, m *serverMetrics, traces *requestTraces, tracer *tracing.Tracer) {
 	sending := new(sync.Mutex) // 确保发送完整的响应
 	wg := new(sync.WaitGroup)  // 等待，直到所有请求都得到处理
 
@@ -101,15 +240,32 @@ func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
 			if req == nil {
 				break
 			}
+			code := codeBadRequest
+			if req.mtype == nil {
+				code = codeNotFound
+			}
+			m.requestRejected(requestLabel(req), code)
 			// 设置请求头的错误
 			req.h.Error = err.Error()
 			// 发送回复，附带编解码器，请求头，错误响应，
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
+		// 服务器正在关闭时，不再处理新的请求，健康检查请求除外，调用方可以得到NOT_SERVING
+		if (server.inShutdown.Load() && req.svc.name != HealthServiceName) || !sc.begin() {
+			m.requestRejected(requestLabel(req), codeUnavailable)
+			req.h.Error = ErrServerShutdown.Error()
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
+		req.trace = traces.begin(req.h.ServiceMethod, sc.remoteAddr, req.size)
+		req.span = startServerSpan(tracer, req, sc.remoteAddr, opt.CodecType)
 		wg.Add(1)
 		// 处理请求是并发的，但是回复请求必须是逐个发送，所以需要使用锁进行保证
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		go func(req *request) {
+			server.handleRequest(cc, req, sending, wg, opt.HandleTimeout, m)
+			sc.end()
+		}(req)
 	}
 	// 等待所有请求完成
 	wg.Wait()
@@ -123,6 +279,9 @@ type request struct {
 	argv, replyv reflect.Value // reflect.Value可以表示任意类型的值的类型
 	mtype        *methodType   // 方法实例
 	svc          *service      // 服务实例
+	size         int64         // 请求头和请求体的字节数，编解码器不支持统计时为0
+	trace        *requestTrace // 请求追踪的记录，没有启用时为nil
+	span         *tracing.Span // 服务端的span，没有启用追踪时为nil
 }
 
 // readRequestHeader 读取请求头
@@ -132,8 +291,9 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	if err := cc.ReadHeader(&h); err != nil {
 		// io.EOF错误表示已达文件或者流的末尾
 		// io.ErrUnexpectedEOF错误表示已经意外地到达文件或者流的末尾
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+		// 服务器关闭连接导致的错误也不需要输出
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !server.inShutdown.Load() {
+			server.log().Error("rpc server: read header error", "err", err)
 		}
 		return nil, err
 	}
@@ -144,6 +304,7 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 // readRequest 读取请求
 func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	// 获取请求头
+	start := bytesRead(cc)
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
@@ -155,6 +316,11 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	// 通过请求头中的服务名.方法名，获取service和method实例
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// 仍然要读取并丢弃请求体，否则下一次ReadHeader会把请求体当作请求头解析，
+		// 一个调用了不存在的服务或方法的请求会使连接上之后的所有请求出错
+		if e := cc.ReadBody(nil); e != nil {
+			return nil, e
+		}
 		return req, err
 	}
 
@@ -172,23 +338,26 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 
 	// 将请求报文反序列化为第一个入参argv
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err: ", err)
+		server.log().Warn("rpc server: read body error", "method", h.ServiceMethod, "err", err)
 		return req, err
 	}
 
 	// 返回请求信息
+	req.size = bytesRead(cc) - start
 	return req, nil
 }
 
-// sendResponse 回复请求
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
+// sendResponse 回复请求，返回响应的字节数
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) int64 {
 	sending.Lock()
 	defer sending.Unlock()
 
 	// 将请求头和主体写入回复
+	start := bytesWritten(cc)
 	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error: ", err)
+		server.log().Error("rpc server: write response error", "method", h.ServiceMethod, "err", err)
 	}
+	return bytesWritten(cc) - start
 }
 
 // handleRequest 处理请求
@@ -196,38 +365,79 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 // called 信道接受消息，代表处理没有超时
 // time.After()先于called，则处理已经超时
 // called和sent都将被阻塞
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration, m *serverMetrics) {
 	defer wg.Done()
 
-	called := make(chan struct{})
-	sent := make(chan struct{})
+	// 记录正在处理的请求数、处理时间和结果
+	start, code := time.Now(), codeOK
+	m.requestStart(req.h.ServiceMethod)
+	defer func() { m.requestDone(req.h.ServiceMethod, code, time.Since(start)) }()
+
+	// 记录请求的错误和响应的大小，供请求追踪使用
+	var reqErr error
+	var respSize int64
+	defer func() {
+		req.trace.finish(reqErr, respSize)
+		req.span.End(reqErr)
+	}()
+
+	// 信道带有缓冲，处理超时之后，调用方法的协程仍然可以退出
+	called := make(chan struct{}, 1)
+	sent := make(chan struct{}, 1)
 
+	// 服务方法可以通过ctx读取请求的元数据，处理超时或者完成之后ctx被取消
+	ctx := context.Background()
+	if req.h.Metadata != nil {
+		ctx = context.WithValue(ctx, incomingMetadataKey{}, req.h.Metadata)
+		req.h.Metadata = nil // 响应不需要携带元数据
+	}
+	// 服务方法使用ctx发起的调用，会成为服务端span的子span
+	if req.span != nil {
+		ctx = tracing.ContextWithSpan(ctx, req.span)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var callErr error  // 服务方法返回的错误，called之后可以读取
+	var callSize int64 // 响应的字节数，sent之后可以读取
 	go func() {
 		// 调用req.svc.method(req.argv, req.replyv)
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
+		err := req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+		req.span.AddEvent(eventExecuted)
+		callErr = err
 		called <- struct{}{} // 通知信道，方法已经调用
 		if err != nil {      // 如果发生错误，设置错误信息，并发送回client
 			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			callSize = server.sendResponse(cc, req.h, invalidRequest, sending)
 			sent <- struct{}{} // 通知发送信道
 			return
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending) // 执行正确调用，发送给client
-		sent <- struct{}{}                                              // 通知发送信道，sendResponse已执行
+		callSize = server.sendResponse(cc, req.h, req.replyv.Interface(), sending) // 执行正确调用，发送给client
+		sent <- struct{}{}                                                         // 通知发送信道，sendResponse已执行
 	}()
 
 	if timeout == 0 { // 如果超时设置为0，直接通过called和sent信道，结束生命周期
 		<-called
 		<-sent
+		respSize = callSize
+		if callErr != nil {
+			code, reqErr = codeError, callErr
+		}
 		return
 	}
 
 	select {
 	case <-time.After(timeout): // 处理超时，发送错误信息给client
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+		code = codeTimeout
+		reqErr = fmt.Errorf("%w: expect within %s", ErrHandleTimeout, timeout)
+		req.h.Error = reqErr.Error()
+		respSize = server.sendResponse(cc, req.h, invalidRequest, sending)
 	case <-called: // 成功执行
 		<-sent // 通知发送信道
+		respSize = callSize
+		if callErr != nil {
+			code, reqErr = codeError, callErr
+		}
 	}
 }
 
@@ -237,7 +447,7 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	// 获得服务名与方法名分割位置的下标
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = fmt.Errorf("%w: %s", ErrIllFormedRequest, serviceMethod)
 		return
 	}
 
@@ -246,7 +456,7 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	// 从serviceMap中找到对应的service实例
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = fmt.Errorf("%w %s", ErrServiceNotFound, serviceName)
 		return
 	}
 
@@ -255,7 +465,7 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	// 通过方法名获得对应的方法
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = fmt.Errorf("%w %s", ErrMethodNotFound, methodName)
 	}
 
 	return
@@ -263,10 +473,18 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 
 // Accept 接受网络监听器上的连接并提供请求
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) { // 服务器正在关闭
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
+
 	for { // 循环等待socket连接建立
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error", err)
+			if !server.inShutdown.Load() {
+				server.log().Error("rpc server: accept error", "err", err)
+			}
 			return
 		}
 		// 开启子协程，处理过程交给ServerConn
@@ -277,6 +495,121 @@ func (server *Server) Accept(lis net.Listener) {
 // Accept 接受监听器上的连接并提供请求
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
+// trackListener 记录或者删除Accept使用的监听器，服务器正在关闭时无法记录，返回false
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.inShutdown.Load() {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录正在服务的连接，服务器正在关闭时返回false
+func (server *Server) trackConn(conn io.Closer) (*serverConn, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.inShutdown.Load() {
+		return nil, false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*serverConn]struct{})
+	}
+	sc := &serverConn{rwc: conn, since: time.Now()}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		sc.remoteAddr = c.RemoteAddr().String()
+	}
+	server.conns[sc] = struct{}{}
+	return sc, true
+}
+
+func (server *Server) untrackConn(sc *serverConn) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	delete(server.conns, sc)
+}
+
+// RegisterOnShutdown 注册服务器关闭时调用的函数，例如从注册中心注销
+// 函数按照注册顺序在关闭监听器之后、等待请求处理完成之前依次调用
+func (server *Server) RegisterOnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.onShutdown = append(server.onShutdown, f)
+}
+
+// shutdownPollInterval 等待请求处理完成时，检查连接状态的最大间隔
+const shutdownPollInterval = 500 * time.Millisecond
+
+// Shutdown 优雅地关闭服务器
+// 首先将健康检查服务设置为NOT_SERVING并关闭所有监听器，然后调用RegisterOnShutdown注册的函数，
+// 之后不再处理新的请求，等待正在处理的请求完成后关闭连接
+// 如果ctx在请求处理完成之前结束，强制关闭所有连接，并返回ctx的错误
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	if server.inShutdown.Swap(true) {
+		server.mu.Unlock()
+		return ErrServerShutdown
+	}
+	if server.health != nil {
+		server.health.Shutdown()
+	}
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	hooks := server.onShutdown
+	server.mu.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
+
+	// 从较短的间隔开始检查，逐渐增加到shutdownPollInterval
+	interval := time.Millisecond
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		if server.closeConns(false) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			server.closeConns(true)
+			return ctx.Err()
+		case <-timer.C:
+			if interval *= 2; interval > shutdownPollInterval {
+				interval = shutdownPollInterval
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+// closeConns 关闭空闲的连接，force为true时关闭所有连接，返回是否所有连接都已关闭
+func (server *Server) closeConns(force bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	closed := true
+	for sc := range server.conns {
+		if !sc.closeIfIdle(force) {
+			closed = false
+		}
+	}
+	return closed
+}
+
 // Register method在server中发布
 // 满足以下条件的receiver值
 // 导出类型的导出方法
@@ -285,15 +618,48 @@ func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 // 一个返回值，类型为error
 func (server *Server) Register(rcvr interface{}) error {
 	// 生成service实例
-	s := newService(rcvr)
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
 
 	// 将service实例添加到服务器中
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
+	for name := range s.method {
+		server.log().Debug("rpc server: register", "service", s.name, "method", name)
+	}
 	return nil
 }
 
+// SetLogger 设置服务器输出日志使用的Logger，为nil时使用logging.Default()，需要在开始接受连接之前调用
+func (server *Server) SetLogger(l logging.Logger) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.logger = l
+}
+
+// log 返回服务器输出日志使用的Logger
+func (server *Server) log() logging.Logger {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return logging.Or(server.logger)
+}
+
+// Services 返回服务器中已经注册的服务名，按字母顺序排序
+func (server *Server) Services() []string {
+	var services []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		services = append(services, namei.(string))
+		return true
+	})
+	sort.Strings(services)
+	return services
+}
+
 // Register 在DefaultServer中发布receiver的方法
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
@@ -301,6 +667,9 @@ const (
 	connected        = "200 Connected to tinyrpc"
 	defaultRPCPath   = "/_tinyrpc_"
 	defaultDebugPath = "/debug/tinyrpc"
+
+	defaultMetricsPath  = "/debug/tinyrpc/metrics"
+	defaultRequestsPath = "/debug/tinyrpc/requests"
 )
 
 // ServeHTTP 实现了一个回答RPC请求的http.Handler
@@ -321,7 +690,7 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// 也就是将HTTP对应的TCP连接取出，最后划给Hijacker管理
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ":", err.Error())
+		server.log().Error("rpc server: hijacking error", "remote", req.RemoteAddr, "err", err)
 		return
 	}
 
@@ -332,11 +701,27 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 // HandleHTTP 为rpcPath上的RPC消息注册一个HTTP处理程序
+// 调用之前启用了指标或者请求追踪时，同时注册/debug/tinyrpc/metrics或者/debug/tinyrpc/requests
 func (server *Server) HandleHTTP() {
+	server.handleHTTP(http.DefaultServeMux)
+}
+
+// handleHTTP 在mux上注册HTTP处理程序
+// 没有启用的调试页面不注册，已经使用这些路径的程序不会因为重复注册而panic
+func (server *Server) handleHTTP(mux *http.ServeMux) {
+	server.mu.Lock()
+	metricsEnabled, tracesEnabled := server.metricsReg != nil, server.traces != nil
+	server.mu.Unlock()
+
 	// Handle注册HTTP处理器handler和对应的模式pattern
-	http.Handle(defaultRPCPath, server)
-	http.Handle(defaultDebugPath, debugHTTP{server})
-	//log.Println("rpc server debug path: ", defaultDebugPath)
+	mux.Handle(defaultRPCPath, server)
+	mux.Handle(defaultDebugPath, debugHTTP{server})
+	if metricsEnabled {
+		mux.HandleFunc(defaultMetricsPath, server.serveMetrics)
+	}
+	if tracesEnabled {
+		mux.HandleFunc(defaultRequestsPath, server.serveRequests)
+	}
 }
 
 // HandleHTTP 默认服务器注册HTTP处理程序的一种方便方法
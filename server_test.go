@@ -0,0 +1,205 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/metrics"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Slow 处理时间由参数指定的服务，单位为毫秒
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func startSlowServer(t *testing.T) (*Server, string) {
+	server := NewServer()
+	var s Slow
+	_ = server.Register(&s)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+
+	var order []string
+	server.RegisterOnShutdown(func() { order = append(order, "deregister") })
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 正在处理的请求在关闭时可以正常完成
+	call := client.Go("Slow.Sleep", 300, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	err = server.Shutdown(context.Background())
+	_assert(err == nil, "shutdown failed: %v", err)
+	_assert(len(order) == 1, "shutdown hook should be called")
+	_assert(time.Since(start) >= 200*time.Millisecond, "shutdown should wait for in-flight requests")
+
+	<-call.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 300, "in-flight call should succeed: %v", call.Error)
+
+	// 关闭之后无法建立新的连接
+	_, err = Dial("tcp", addr)
+	_assert(err != nil, "dial should fail after shutdown")
+	_assert(server.Shutdown(context.Background()) == ErrServerShutdown, "shutdown twice should return ErrServerShutdown")
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	t.Parallel()
+	server, addr := startSlowServer(t)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Slow.Sleep", 2000, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(err == context.DeadlineExceeded, "expect deadline exceeded, but got %v", err)
+
+	// 连接被强制关闭，调用返回错误
+	<-call.Done
+	_assert(call.Error != nil, "call should fail when the connection is closed")
+}
+
+func TestServer_PipelinedHandshake(t *testing.T) {
+	t.Parallel()
+	_, addr := startSlowServer(t)
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = conn.Close() }()
+
+	// Option和第一个请求在同一次写入中发送，服务端读取Option时会预读请求数据
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(DefaultOption)
+	enc := gob.NewEncoder(&buf)
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Slow.Sleep", Seq: 1})
+	_ = enc.Encode(1)
+	_, err = conn.Write(buf.Bytes())
+	_assert(err == nil, "failed to write: %v", err)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	dec := gob.NewDecoder(conn)
+	var h codec.Header
+	var reply int
+	_assert(dec.Decode(&h) == nil && dec.Decode(&reply) == nil, "expect a response to the pipelined request")
+	_assert(h.Seq == 1 && h.Error == "" && reply == 1, "unexpected response %+v %d", h, reply)
+}
+
+func TestServer_UnknownMethod(t *testing.T) {
+	t.Parallel()
+	_, addr := startSlowServer(t)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 请求体被丢弃之后，同一连接上的下一个请求仍然可以正常处理
+	var reply int
+	for _, serviceMethod := range []string{"Nope.Sleep", "Slow.Nope", "ill-formed"} {
+		err = client.Call(context.Background(), serviceMethod, 1, &reply)
+		_assert(err != nil, "call %s should fail", serviceMethod)
+		err = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+		_assert(err == nil && reply == 1, "call after %s should succeed, but got %d %v", serviceMethod, reply, err)
+	}
+}
+
+// recordLogger 记录每一条日志的级别和消息
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *recordLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+func (l *recordLogger) has(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if len(e) >= len(prefix) && e[:len(prefix)] == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServer_SetLogger(t *testing.T) {
+	t.Parallel()
+	logger := new(recordLogger)
+	server := NewServer()
+	server.SetLogger(logger)
+	var s Slow
+	_ = server.Register(&s)
+	_assert(logger.has("DEBUG rpc server: register [service Slow method Sleep]"), "registration should be logged at debug level")
+
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	_ = json.NewEncoder(conn).Encode(&Option{MagicNumber: 1})
+	// 服务端拒绝协议交换之后关闭连接
+	_, _ = conn.Read(make([]byte, 1))
+	_ = conn.Close()
+	_assert(logger.has("WARN rpc server: invalid magic number"), "handshake failure should be logged")
+}
+
+func TestServer_HandleHTTP(t *testing.T) {
+	pattern := func(mux *http.ServeMux, path string) string {
+		_, p := mux.Handler(httptest.NewRequest("GET", path, nil))
+		return p
+	}
+
+	// 程序已经使用了调试页面的路径，没有启用指标和请求追踪时不会重复注册
+	mux := http.NewServeMux()
+	mux.HandleFunc(defaultMetricsPath, func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc(defaultRequestsPath, func(http.ResponseWriter, *http.Request) {})
+	NewServer().handleHTTP(mux)
+	_assert(pattern(mux, defaultRPCPath) == defaultRPCPath, "rpc path should be registered")
+
+	mux = http.NewServeMux()
+	NewServer().handleHTTP(mux)
+	_assert(pattern(mux, defaultMetricsPath) == "", "metrics page should not be registered when disabled")
+	_assert(pattern(mux, defaultRequestsPath) == "", "requests page should not be registered when disabled")
+
+	server := NewServer()
+	server.EnableMetrics(metrics.NewRegistry())
+	server.EnableRequestTrace(nil)
+	mux = http.NewServeMux()
+	server.handleHTTP(mux)
+	_assert(pattern(mux, defaultMetricsPath) == defaultMetricsPath, "metrics page should be registered when enabled")
+	_assert(pattern(mux, defaultRequestsPath) == defaultRequestsPath, "requests page should be registered when enabled")
+}
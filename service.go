@@ -1,10 +1,12 @@
 package tinyrpc
 
 import (
+	"context"
+	"errors"
 	"go/ast"
-	"log"
 	"reflect"
 	"sync/atomic"
+	"time"
 )
 
 // methodType 包含一个方法的完整信息
@@ -13,6 +15,12 @@ type methodType struct {
 	ArgType   reflect.Type   // 第一个参数的类型
 	ReplyType reflect.Type   // 第二个参数的类型
 	numCalls  uint64         // 用于后续统计方法调用次数
+	withCtx   bool           // 第一个参数是否为context.Context
+
+	numErrors    uint64 // 返回错误的调用次数
+	inFlight     int64  // 正在执行的调用数
+	totalLatency int64  // 所有调用的执行时间之和，单位为纳秒
+	maxLatency   int64  // 最长的执行时间，单位为纳秒
 }
 
 func (m *methodType) NumCalls() uint64 {
@@ -20,6 +28,46 @@ func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// NumErrors 返回方法返回错误的次数
+func (m *methodType) NumErrors() uint64 {
+	return atomic.LoadUint64(&m.numErrors)
+}
+
+// InFlight 返回正在执行的调用数
+func (m *methodType) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
+// MeanLatency 返回已经完成的调用的平均执行时间
+func (m *methodType) MeanLatency() time.Duration {
+	// 调用开始时numCalls已经加1，需要去除正在执行的调用
+	done := int64(m.NumCalls()) - m.InFlight()
+	if done <= 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&m.totalLatency) / done)
+}
+
+// MaxLatency 返回最长的执行时间
+func (m *methodType) MaxLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.maxLatency))
+}
+
+// observe 记录一次调用的执行时间和结果
+func (m *methodType) observe(latency time.Duration, err error) {
+	if err != nil {
+		atomic.AddUint64(&m.numErrors, 1)
+	}
+	atomic.AddInt64(&m.totalLatency, int64(latency))
+	for {
+		max := atomic.LoadInt64(&m.maxLatency)
+		if int64(latency) <= max || atomic.CompareAndSwapInt64(&m.maxLatency, max, int64(latency)) {
+			break
+		}
+	}
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
 // newArgv 用于创建对应类型的实例
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
@@ -77,7 +125,8 @@ type service struct {
 }
 
 // newService 构造函数，参数为任意需要映射为服务的结构体实例
-func newService(rcvr interface{}) *service {
+// 结构体的类型名不可导出时返回错误
+func newService(rcvr interface{}) (*service, error) {
 
 	s := new(service)              // 创建一个service实例
 	s.rcvr = reflect.ValueOf(rcvr) // 将rcvr封装为reflect.Value类型
@@ -89,12 +138,12 @@ func newService(rcvr interface{}) *service {
 
 	// 判断所映射的结构体是否可以导出，如果不可以导出，则进行报错
 	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+		return nil, errors.New("rpc server: " + s.name + " is not a valid service name")
 	}
 
 	s.registerMethods() // 注册所有结构体的所有方法
 
-	return s // 返回service实例
+	return s, nil // 返回service实例
 }
 
 // registerMethods 注册方法到服务
@@ -109,7 +158,10 @@ func (s *service) registerMethods() {
 		mType := method.Type      // 获取method的方法类型
 
 		// 如果method方法的参数不等于3，且返回个数不等于1，则跳过当前method
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 方法也可以写成func (t *T) Method(ctx context.Context, args T1, reply *T2) error，此时参数个数为4，
+		// 服务方法通过ctx读取请求的元数据(MetadataFromContext)和追踪的span，请求处理超时之后ctx被取消
+		withCtx := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+		if (mType.NumIn() != 3 && !withCtx) || mType.NumOut() != 1 {
 			continue
 		}
 		// 如果method方法的第0个返回值，不等于error类型，则跳过当前method
@@ -119,6 +171,9 @@ func (s *service) registerMethods() {
 
 		// 获取method第一个和第二个参数，分别赋予argType和replyType
 		argType, replyType := mType.In(1), mType.In(2)
+		if withCtx {
+			argType, replyType = mType.In(2), mType.In(3)
+		}
 
 		// 如果argType和replyType不可以导出或者不为内建类型，则跳过当前method
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
@@ -130,12 +185,14 @@ func (s *service) registerMethods() {
 			method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			withCtx:   withCtx,
 		}
-		// 输出rpc服务注册信息
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+// typeOfContext context.Context的类型
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // isExportedOrBuiltinType 校验方法条件，是否可以导出和为内建类型
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	// PkgPath返回类型的包路径，即明确指定包的import路径，如"encoding/base64"
@@ -147,8 +204,15 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 
 // call 实现通过反射值调用方法
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext 实现通过反射值调用方法，方法的第一个参数为context.Context时传入ctx
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	// 调用次数+1
+	atomic.AddInt64(&m.inFlight, 1)
 	atomic.AddUint64(&m.numCalls, 1)
+	start := time.Now()
 
 	// 获取方法的值
 	f := m.method.Func
@@ -157,12 +221,17 @@ func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
 	// Call的执行调用，相当于一种方法表达式，所有s.rcvr作为方法的接受者，则成为函数的第一个形参
 	// 等价于调用s.rcvr.method(argv, replyv)
 	// 最后方法的返回结果为reflect.Value封装的Slice
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withCtx {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 
 	// 获取方法返回的错误信息
+	var err error
 	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+		err = errInter.(error)
 	}
-
-	return nil
+	m.observe(time.Since(start), err)
+	return err
 }
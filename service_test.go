@@ -1,9 +1,13 @@
 package tinyrpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -31,17 +35,31 @@ func _assert(condition bool, msg string, v ...interface{}) {
 func TestNewService(t *testing.T) {
 	var foo Foo
 
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
 }
 
+type unexported int
+
+func (u unexported) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestNewService_Unexported(t *testing.T) {
+	var u unexported
+	_, err := newService(&u)
+	_assert(err != nil, "unexported service should return an error")
+	_assert(NewServer().Register(&u) != nil, "registering an unexported service should fail")
+}
+
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
 
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
@@ -51,3 +69,62 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+// Ctx 第一个参数为context.Context的服务
+type Ctx struct {
+	canceled chan struct{}
+}
+
+func (c *Ctx) Sum(_ context.Context, args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Wait 等待ctx被取消
+func (c *Ctx) Wait(ctx context.Context, _ int, _ *int) error {
+	<-ctx.Done()
+	close(c.canceled)
+	return ctx.Err()
+}
+
+// Misplaced context.Context不是第一个参数，不会被注册
+func (c *Ctx) Misplaced(_ Args, _ context.Context, _ *int) error {
+	return nil
+}
+
+func TestNewService_Context(t *testing.T) {
+	s, _ := newService(&Ctx{})
+	_assert(len(s.method) == 2, "expect Sum and Wait, but got %d methods", len(s.method))
+	mType := s.method["Sum"]
+	_assert(mType != nil && mType.withCtx && mType.ArgType == reflect.TypeOf(Args{}), "Sum should take a context, but got %+v", mType)
+
+	argv := mType.newArgv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+	replyv := mType.newReplyv()
+	err := s.call(mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 4, "failed to call Ctx.Sum")
+}
+
+func TestServer_ContextCanceled(t *testing.T) {
+	t.Parallel()
+	c := &Ctx{canceled: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(c)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{HandleTimeout: 50 * time.Millisecond})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 处理超时之后，服务方法的ctx被取消
+	var reply int
+	err = client.Call(context.Background(), "Ctx.Wait", 0, &reply)
+	_assert(errors.Is(err, ErrHandleTimeout), "expect a handle timeout, but got %v", err)
+	select {
+	case <-c.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("ctx should be canceled after the handle timeout")
+	}
+}
@@ -0,0 +1,49 @@
+package tinyrpc
+
+import (
+	"context"
+	"github.com/Asolmn/tinyrpc/codec"
+	"github.com/Asolmn/tinyrpc/tracing"
+)
+
+// span中记录的事件
+const (
+	eventConnected = "connected" // 建立连接，之后开始协议交换
+	eventSent      = "sent"      // 请求已经发送，之后等待回复
+	eventExecuted  = "executed"  // 服务方法执行完成，之后发送回复
+)
+
+// EnableTracing 为服务器处理的每个请求和每次协议交换创建span，需要在开始接受连接之前调用
+// 请求头的元数据中带有span上下文时，服务端的span作为调用方span的子span
+func (server *Server) EnableTracing(tracer *tracing.Tracer) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.tracer = tracer
+}
+
+// startServerSpan 为请求创建服务端的span，tracer为nil时返回nil
+func startServerSpan(tracer *tracing.Tracer, req *request, peer string, ct codec.Type) *tracing.Span {
+	if tracer == nil {
+		return nil
+	}
+	ctx := context.Background()
+	if sc, ok := tracing.Extract(req.h.Metadata); ok {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	_, span := tracer.Start(ctx, req.h.ServiceMethod, tracing.KindServer)
+	span.SetAttribute(tracing.AttrMethod, req.h.ServiceMethod)
+	span.SetAttribute(tracing.AttrPeer, peer)
+	span.SetAttribute(tracing.AttrCodec, string(ct))
+	return span
+}
+
+// injectSpan 返回加入了span上下文的元数据，不修改md
+func injectSpan(md map[string]string, span *tracing.Span) map[string]string {
+	merged := make(map[string]string, len(md)+2)
+	for k, v := range md {
+		merged[k] = v
+	}
+	tracing.Inject(span.SpanContext(), merged)
+	return merged
+}
@@ -0,0 +1,311 @@
+// Package tracing 不依赖第三方库的分布式追踪
+// 调用方和服务端分别为每次调用创建span，trace和span的ID通过请求头的元数据传递，结束的span交给Exporter导出
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/Asolmn/tinyrpc/logging"
+	"sync"
+	"time"
+)
+
+// 在请求头元数据中传递span上下文使用的键
+const (
+	TraceIDKey = "trace-id"
+	SpanIDKey  = "span-id"
+)
+
+// span属性的键
+const (
+	AttrMethod = "rpc.method" // 服务名.方法名
+	AttrPeer   = "net.peer"   // 对端地址
+	AttrCodec  = "rpc.codec"  // 编解码方式
+)
+
+// TraceID 一次追踪的ID，同一次追踪中的所有span相同
+type TraceID [16]byte
+
+// SpanID span的ID
+type SpanID [8]byte
+
+// IsValid 全为0的ID无效
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String 返回十六进制表示
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// MarshalText 以十六进制编码，无效的ID编码为空字符串
+func (t TraceID) MarshalText() ([]byte, error) {
+	if !t.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText 解析十六进制表示，空字符串解析为无效的ID
+func (t *TraceID) UnmarshalText(text []byte) error { return decodeID(t[:], text) }
+
+// IsValid 全为0的ID无效
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String 返回十六进制表示
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// MarshalText 以十六进制编码，无效的ID编码为空字符串
+func (s SpanID) MarshalText() ([]byte, error) {
+	if !s.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText 解析十六进制表示，空字符串解析为无效的ID
+func (s *SpanID) UnmarshalText(text []byte) error { return decodeID(s[:], text) }
+
+// decodeID 将十六进制表示解码到id中，长度必须一致
+func decodeID(id []byte, text []byte) error {
+	if len(text) == 0 {
+		for i := range id {
+			id[i] = 0
+		}
+		return nil
+	}
+	if hex.DecodedLen(len(text)) != len(id) {
+		return errors.New("tracing: invalid id length: " + string(text))
+	}
+	_, err := hex.Decode(id, text)
+	return err
+}
+
+// SpanContext 跨进程传递的span标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid trace和span的ID都有效时span上下文有效
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Inject 将span上下文写入元数据，sc无效时不写入
+func Inject(sc SpanContext, md map[string]string) {
+	if sc.IsValid() {
+		md[TraceIDKey] = sc.TraceID.String()
+		md[SpanIDKey] = sc.SpanID.String()
+	}
+}
+
+// Extract 从元数据中读取span上下文，没有或者格式错误时返回false
+func Extract(md map[string]string) (SpanContext, bool) {
+	var sc SpanContext
+	if sc.TraceID.UnmarshalText([]byte(md[TraceIDKey])) != nil || sc.SpanID.UnmarshalText([]byte(md[SpanIDKey])) != nil {
+		return SpanContext{}, false
+	}
+	return sc, sc.IsValid()
+}
+
+// Kind span的类型
+type Kind int
+
+const (
+	KindInternal Kind = iota // 进程内部的操作，例如广播调用的扇出
+	KindClient               // 调用方发起的调用
+	KindServer               // 服务端处理的请求
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindClient:
+		return "client"
+	case KindServer:
+		return "server"
+	}
+	return "internal"
+}
+
+// MarshalText 以名称编码
+func (k Kind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// UnmarshalText 解析名称，未知的名称解析为KindInternal
+func (k *Kind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "client":
+		*k = KindClient
+	case "server":
+		*k = KindServer
+	default:
+		*k = KindInternal
+	}
+	return nil
+}
+
+// Event span中的一个时间点，例如协议交换完成、服务方法执行完成
+type Event struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// SpanData 已经结束的span，导出之后不会再被修改
+type SpanData struct {
+	Name       string            `json:"name"`
+	Kind       Kind              `json:"kind"`
+	TraceID    TraceID           `json:"trace_id"`
+	SpanID     SpanID            `json:"span_id"`
+	ParentID   SpanID            `json:"parent_id"` // 根span为空
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Events     []Event           `json:"events,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Duration 返回span的持续时间
+func (d *SpanData) Duration() time.Duration { return d.End.Sub(d.Start) }
+
+// Exporter 导出已经结束的span，需要支持并发调用
+type Exporter interface {
+	Export(span *SpanData) error
+}
+
+// Tracer 创建span，并在span结束时交给Exporter导出
+// nil的Tracer不创建span，方便调用方在没有启用追踪时不做判断
+type Tracer struct {
+	exporter Exporter
+	logger   logging.Logger // 输出导出错误使用的Logger，为nil时使用logging.Default()
+}
+
+// NewTracer 创建使用exporter导出span的Tracer
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// SetLogger 设置输出导出错误使用的Logger，为nil时使用logging.Default()，需要在创建span之前调用
+func (t *Tracer) SetLogger(l logging.Logger) {
+	if t != nil {
+		t.logger = l
+	}
+}
+
+// Start 创建一个span，并返回附带该span的context
+// ctx中有span时作为父span，否则使用ContextWithRemoteSpanContext设置的远程span，都没有时开始新的追踪
+// t为nil时返回ctx和nil的span
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: time.Now()}}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	}
+	if parent.IsValid() {
+		span.data.TraceID, span.data.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		randomID(span.data.TraceID[:])
+	}
+	randomID(span.data.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// randomID 生成随机的ID
+func randomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		panic("tracing: failed to generate id: " + err.Error())
+	}
+}
+
+// Span 正在进行的操作，所有方法都可以在nil上调用
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext 返回span的标识，s为nil时返回无效的SpanContext
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+
+// SetAttribute 设置span的属性，例如方法名、对端地址和编解码方式，span结束之后不再生效
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended { // 已经导出的span不能再修改
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// AddEvent 在span中记录当前时间点，span结束之后不再生效
+func (s *Span) AddEvent(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now()})
+	}
+}
+
+// End 结束span并导出，err不为nil时记录错误，重复调用只有第一次生效
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+
+	if s.tracer.exporter == nil {
+		return
+	}
+	if err := s.tracer.exporter.Export(&data); err != nil {
+		logging.Or(s.tracer.logger).Warn("rpc tracing: export span error", "span", data.Name, "err", err)
+	}
+}
+
+// spanKey context中保存当前span的键
+type spanKey struct{}
+
+// remoteSpanContextKey context中保存远程父span的键
+type remoteSpanContextKey struct{}
+
+// ContextWithSpan 返回附带span的context，之后在该context上创建的span都是它的子span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 返回context中的span，没有时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext 返回附带远程span上下文的context，服务端使用它作为span的父span
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
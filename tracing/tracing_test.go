@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindInternal)
+	_, child := tracer.Start(ctx, "child", KindClient)
+	child.SetAttribute(AttrMethod, "Foo.Sum")
+	child.AddEvent("sent")
+	child.End(errors.New("boom"))
+	child.SetAttribute(AttrPeer, "ignored")
+	child.End(nil)
+	root.End(nil)
+
+	spans := exporter.Spans()
+	_assert(len(spans) == 2, "expect 2 spans, but got %d", len(spans))
+	c, r := spans[0], spans[1]
+	_assert(!r.ParentID.IsValid() && r.TraceID.IsValid(), "root span should start a new trace")
+	_assert(c.TraceID == r.TraceID && c.ParentID == r.SpanID && c.SpanID != r.SpanID, "child should be linked to root")
+	_assert(c.Error == "boom" && len(c.Events) == 1 && len(c.Attributes) == 1, "unexpected child span %+v", c)
+
+	// 元数据传递的span上下文作为远程父span
+	md := make(map[string]string)
+	Inject(root.SpanContext(), md)
+	sc, ok := Extract(md)
+	_assert(ok && sc == root.SpanContext(), "extracted span context should match")
+	_, server := tracer.Start(ContextWithRemoteSpanContext(context.Background(), sc), "server", KindServer)
+	_assert(server.SpanContext().TraceID == sc.TraceID && server.data.ParentID == sc.SpanID, "server span should be a child of the remote span")
+	_, ok = Extract(map[string]string{TraceIDKey: "xyz", SpanIDKey: "1"})
+	_assert(!ok, "invalid ids should not be extracted")
+
+	// nil的Tracer不创建span
+	var nilTracer *Tracer
+	ctx2, span := nilTracer.Start(ctx, "noop", KindClient)
+	span.SetAttribute("k", "v")
+	span.End(nil)
+	_assert(span == nil && ctx2 == ctx, "nil tracer should not create spans")
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&buf))
+	ctx, root := tracer.Start(context.Background(), "root", KindInternal)
+	_, child := tracer.Start(ctx, "child", KindServer)
+	child.End(nil)
+	root.End(nil)
+
+	dec := json.NewDecoder(&buf)
+	var spans []SpanData
+	for dec.More() {
+		var s SpanData
+		_assert(dec.Decode(&s) == nil, "invalid json line")
+		spans = append(spans, s)
+	}
+	_assert(len(spans) == 2, "expect 2 lines, but got %d", len(spans))
+	_assert(spans[0].Kind == KindServer && spans[0].ParentID == spans[1].SpanID && spans[0].TraceID == root.SpanContext().TraceID, "unexpected span %+v", spans[0])
+	_assert(!spans[1].ParentID.IsValid() && spans[1].Duration() >= 0, "unexpected root span %+v", spans[1])
+}
+
+// failingExporter 每次导出都返回错误
+type failingExporter struct{}
+
+func (failingExporter) Export(*SpanData) error { return errors.New("export failed") }
+
+// recordLogger 记录每一条日志的级别和消息
+type recordLogger struct{ entries []string }
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.entries = append(l.entries, "DEBUG "+msg) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.entries = append(l.entries, "INFO "+msg) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.entries = append(l.entries, "WARN "+msg) }
+func (l *recordLogger) Error(msg string, args ...any) { l.entries = append(l.entries, "ERROR "+msg) }
+
+func TestTracer_SetLogger(t *testing.T) {
+	logger := new(recordLogger)
+	tracer := NewTracer(failingExporter{})
+	tracer.SetLogger(logger)
+	_, span := tracer.Start(context.Background(), "root", KindInternal)
+	span.End(nil)
+	_assert(len(logger.entries) == 1 && logger.entries[0] == "WARN rpc tracing: export span error", "export error should be logged by the tracer logger, but got %v", logger.entries)
+
+	var nilTracer *Tracer
+	nilTracer.SetLogger(logger)
+}
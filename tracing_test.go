@@ -0,0 +1,80 @@
+package tinyrpc
+
+import (
+	"context"
+	"github.com/Asolmn/tinyrpc/tracing"
+	"net"
+	"testing"
+	"time"
+)
+
+// Relay 将调用转发给另一个服务器
+type Relay struct {
+	client *Client
+}
+
+func (r *Relay) Forward(ctx context.Context, ms int, reply *int) error {
+	return r.client.Call(ctx, "Slow.Sleep", ms, reply)
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+	exporter := tracing.NewInMemoryExporter()
+	tracer := tracing.NewTracer(exporter)
+	opt := &Option{Tracer: tracer}
+
+	backend, backendAddr := startSlowServer(t)
+	backend.EnableTracing(tracer)
+	relayClient, err := Dial("tcp", backendAddr, opt)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = relayClient.Close() }()
+
+	frontend := NewServer()
+	frontend.EnableTracing(tracer)
+	_ = frontend.Register(&Relay{client: relayClient})
+	l, _ := net.Listen("tcp", ":0")
+	go frontend.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), opt)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call(context.Background(), "Relay.Forward", 10, &reply) == nil && reply == 10, "call failed")
+
+	// 两次建立连接和协议交换，两次调用在调用方和服务端各有一个span
+	var spans []*tracing.SpanData
+	for i := 0; i < 100 && len(spans) < 8; i++ {
+		time.Sleep(10 * time.Millisecond)
+		spans = exporter.Spans()
+	}
+	_assert(len(spans) == 8, "expect 8 spans, but got %d", len(spans))
+	find := func(name string, kind tracing.Kind) []*tracing.SpanData {
+		var found []*tracing.SpanData
+		for _, s := range spans {
+			if s.Name == name && s.Kind == kind {
+				found = append(found, s)
+			}
+		}
+		return found
+	}
+
+	dials, handshakes := find("tinyrpc.Dial", tracing.KindClient), find("tinyrpc.Handshake", tracing.KindServer)
+	_assert(len(dials) == 2 && len(handshakes) == 2, "expect 2 dial and 2 handshake spans")
+	_assert(len(dials[0].Events) == 1 && dials[0].Events[0].Name == eventConnected, "dial span should record the connection")
+	_assert(handshakes[0].Attributes[tracing.AttrCodec] == string(DefaultOption.CodecType) && handshakes[0].Error == "", "unexpected handshake span %+v", handshakes[0])
+
+	clientSpan, serverSpan := find("Relay.Forward", tracing.KindClient), find("Relay.Forward", tracing.KindServer)
+	relaySpan, backendSpan := find("Slow.Sleep", tracing.KindClient), find("Slow.Sleep", tracing.KindServer)
+	_assert(len(clientSpan) == 1 && len(serverSpan) == 1 && len(relaySpan) == 1 && len(backendSpan) == 1, "expect one span for each side of the calls")
+
+	// 调用方 -> 服务端 -> 服务方法发起的调用 -> 下游服务端
+	chain := []*tracing.SpanData{clientSpan[0], serverSpan[0], relaySpan[0], backendSpan[0]}
+	_assert(!chain[0].ParentID.IsValid(), "client span should be a root span")
+	for i := 1; i < len(chain); i++ {
+		_assert(chain[i].TraceID == chain[0].TraceID, "spans should belong to the same trace")
+		_assert(chain[i].ParentID == chain[i-1].SpanID, "%s should be a child of %s", chain[i].Name, chain[i-1].Name)
+	}
+	_assert(chain[0].Attributes[tracing.AttrPeer] == client.target && chain[0].Events[0].Name == eventSent, "unexpected client span %+v", chain[0])
+	_assert(chain[3].Attributes[tracing.AttrMethod] == "Slow.Sleep" && chain[3].Attributes[tracing.AttrPeer] != "", "unexpected server span %+v", chain[3])
+	_assert(chain[3].Events[0].Name == eventExecuted && chain[3].Events[0].Time.Sub(chain[3].Start) >= 10*time.Millisecond, "server span should record the execution time")
+}
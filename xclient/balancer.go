@@ -0,0 +1,177 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// CallInfo 一次调用的信息，负载均衡器根据它选择服务实例
+type CallInfo struct {
+	ServiceMethod string      // <service>.<method>
+	Args          interface{} // 调用参数
+	Key           string      // 一致性哈希的key，通过WithHashKey或者XClient.SetHashKeyFunc设置
+
+	weight   func(addr string) int               // 由服务发现提供的权重
+	metadata func(addr string) map[string]string // 由服务发现提供的元数据
+	stat     func(addr string) ServerStat        // 由XClient提供的负载统计
+	reserve  func(addr string)                   // 由XClient提供，为服务实例占用一个未完成的调用数
+	reserved string                              // 本次Pick中已经占用未完成调用数的服务实例
+}
+
+// Weight 返回服务实例的权重，服务发现不支持权重时返回DefaultWeight
+func (info *CallInfo) Weight(addr string) int {
+	if info == nil || info.weight == nil {
+		return DefaultWeight
+	}
+	return info.weight(addr)
+}
+
+// Metadata 返回服务实例的元数据，服务发现不支持元数据时返回nil
+func (info *CallInfo) Metadata(addr string) map[string]string {
+	if info == nil || info.metadata == nil {
+		return nil
+	}
+	return info.metadata(addr)
+}
+
+// Stat 返回XClient统计的服务实例负载，包括未完成的调用数和EWMA延迟
+// 没有统计数据时返回只有地址的ServerStat
+func (info *CallInfo) Stat(addr string) ServerStat {
+	if info == nil || info.stat == nil {
+		return ServerStat{Addr: addr}
+	}
+	return info.stat(addr)
+}
+
+// Reserve 为选中的服务实例占用一个未完成的调用数，之后的Stat立即反映这次选择
+// 依赖未完成调用数的负载均衡器在Pick返回之前调用，并与统计的读取一起加锁，
+// 避免并发的Pick看到相同的统计而选中同一个实例；每次Pick最多调用一次
+// 占用的调用数由XClient在调用结束或撤销选择时释放，负载均衡器没有调用Reserve时由XClient在Pick之后占用
+func (info *CallInfo) Reserve(addr string) {
+	if info == nil || info.reserve == nil {
+		return
+	}
+	info.reserve(addr)
+	info.reserved = addr
+}
+
+// DoneInfo 调用结束后反馈给负载均衡器的信息
+type DoneInfo struct {
+	Err      error         // 调用的错误信息
+	Latency  time.Duration // 调用耗时，包括建立连接的时间
+	Canceled bool          // 调用方主动取消了调用，调用结果不能反映服务实例的状态
+}
+
+// Balancer 负载均衡器，在Discovery.GetAll返回的服务列表之上选择服务实例
+// XClient对每次实际发起调用的Pick都会调用一次Done，负载均衡器可以借此统计服务实例的负载
+// 选中之后没有发起调用的Pick不会调用Done，负载均衡器实现了Releaser时改为调用Release
+type Balancer interface {
+	// Pick 从servers中选择一个服务实例，servers不为空，且已经去除了熔断的实例
+	Pick(ctx context.Context, servers []string, info *CallInfo) (string, error)
+	// Done 反馈调用结果
+	Done(addr string, info *CallInfo, done DoneInfo)
+}
+
+// Releaser 可以撤销一次选择的负载均衡器
+// 选中的服务实例没有被调用时调用Release，例如熔断器在选中之后才打开，
+// 负载均衡器只需释放Pick时占用的状态，例如未完成的调用数，不应记录调用结果和延迟
+type Releaser interface {
+	Release(addr string, info *CallInfo)
+}
+
+// release 撤销负载均衡器的一次选择，负载均衡器没有实现Releaser时什么也不做
+func release(b Balancer, addr string, info *CallInfo) {
+	if r, ok := b.(Releaser); ok {
+		r.Release(addr, info)
+	}
+}
+
+// BalancerBuilder 创建负载均衡器，每个XClient持有各自的负载均衡器实例
+type BalancerBuilder func() Balancer
+
+var (
+	balancersMu sync.RWMutex
+	balancers   = map[SelectMode]BalancerBuilder{
+		RandomSelect:             func() Balancer { return newRandomBalancer() },
+		RoundRobinSelect:         func() Balancer { return newRoundRobinBalancer() },
+		WeightedRoundRobinSelect: func() Balancer { return newWeightedRoundRobinBalancer() },
+		WeightedRandomSelect:     func() Balancer { return newWeightedRandomBalancer() },
+		ConsistentHashSelect:     func() Balancer { return newConsistentHashBalancer(DefaultReplicas) },
+		LeastPendingSelect:       func() Balancer { return newLeastPendingBalancer() },
+		P2CSelect:                func() Balancer { return newP2CBalancer() },
+	}
+)
+
+// RegisterBalancer 注册负载均衡策略，已经存在的策略会被覆盖
+// 自定义策略建议使用较大的SelectMode值，避免与内置策略冲突
+func RegisterBalancer(mode SelectMode, builder BalancerBuilder) {
+	balancersMu.Lock()
+	defer balancersMu.Unlock()
+
+	balancers[mode] = builder
+}
+
+// NewBalancer 根据负载均衡策略创建负载均衡器
+func NewBalancer(mode SelectMode) (Balancer, error) {
+	balancersMu.RLock()
+	builder, ok := balancers[mode]
+	balancersMu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("rpc discovery: not supported select mode")
+	}
+	return builder(), nil
+}
+
+// errBalancer 不支持的负载均衡策略，每次选择都返回错误
+type errBalancer struct{ err error }
+
+func (b errBalancer) Pick(context.Context, []string, *CallInfo) (string, error) { return "", b.err }
+func (b errBalancer) Done(string, *CallInfo, DoneInfo)                          {}
+
+// randomBalancer 随机选择
+type randomBalancer struct {
+	mu sync.Mutex
+	r  *rand.Rand // 生成随机数
+}
+
+func newRandomBalancer() *randomBalancer {
+	// 初始化使用时间戳设定随机数种子
+	return &randomBalancer{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) Pick(_ context.Context, servers []string, _ *CallInfo) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return servers[b.r.Intn(len(servers))], nil
+}
+
+func (b *randomBalancer) Done(string, *CallInfo, DoneInfo) {}
+
+// roundRobinBalancer Robin算法轮询选择
+type roundRobinBalancer struct {
+	mu    sync.Mutex
+	index int // 记录Robin算法的轮询到的位置
+}
+
+func newRoundRobinBalancer() *roundRobinBalancer {
+	// 从随机位置开始轮询，避免多个客户端同时从第一个实例开始
+	return &roundRobinBalancer{index: rand.Intn(math.MaxInt32 - 1)}
+}
+
+func (b *roundRobinBalancer) Pick(_ context.Context, servers []string, _ *CallInfo) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(servers)
+	s := servers[b.index%n]     // 从服务发现列表中，获取需要调度执行的服务
+	b.index = (b.index + 1) % n // 通过Robin算法更新轮询位置
+	return s, nil
+}
+
+func (b *roundRobinBalancer) Done(string, *CallInfo, DoneInfo) {}
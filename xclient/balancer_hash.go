@@ -0,0 +1,108 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultReplicas 一致性哈希中每个权重单位对应的虚拟节点数
+const DefaultReplicas = 160
+
+// hashRing 一致性哈希环
+// 每个服务实例在环上对应多个虚拟节点，服务列表变化时只有少量key会被重新映射
+type hashRing struct {
+	keys  []uint32          // 排序后的虚拟节点哈希值
+	nodes map[uint32]string // 虚拟节点哈希值与服务地址的映射
+}
+
+// newHashRing 根据服务列表构建哈希环，虚拟节点数与权重成正比
+func newHashRing(replicas int, servers []string, weight func(addr string) int) *hashRing {
+	r := &hashRing{nodes: make(map[uint32]string)}
+	for _, addr := range servers {
+		for i := 0; i < replicas*weight(addr); i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + addr))
+			if _, ok := r.nodes[hash]; ok { // 哈希冲突时保留先加入的节点
+				continue
+			}
+			r.keys = append(r.keys, hash)
+			r.nodes[hash] = addr
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+	return r
+}
+
+// get 顺时针找到key对应的第一个虚拟节点，返回其服务地址
+func (r *hashRing) get(key string) string {
+	if len(r.keys) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= hash })
+	return r.nodes[r.keys[idx%len(r.keys)]]
+}
+
+// consistentHashBalancer 一致性哈希选择，相同key的调用落到同一个服务实例上
+type consistentHashBalancer struct {
+	replicas int
+
+	mu        sync.Mutex
+	ring      *hashRing
+	signature string // 构建哈希环时的服务列表与权重，变化时重建哈希环
+}
+
+func newConsistentHashBalancer(replicas int) *consistentHashBalancer {
+	return &consistentHashBalancer{replicas: replicas}
+}
+
+func (b *consistentHashBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	if info == nil || info.Key == "" {
+		return "", errors.New("rpc xclient: consistent hash select requires a key, use WithHashKey or SetHashKeyFunc")
+	}
+
+	var sb strings.Builder
+	for _, addr := range servers {
+		sb.WriteString(addr)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Itoa(info.Weight(addr)))
+		sb.WriteByte(',')
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 服务列表发生变化，重建哈希环
+	if signature := sb.String(); b.ring == nil || signature != b.signature {
+		b.ring = newHashRing(b.replicas, servers, info.Weight)
+		b.signature = signature
+	}
+	if s := b.ring.get(info.Key); s != "" {
+		return s, nil
+	}
+	return "", errNoPositiveWeight
+}
+
+func (b *consistentHashBalancer) Done(string, *CallInfo, DoneInfo) {}
+
+// hashKey context中存放哈希key的键
+type hashKey struct{}
+
+// WithHashKey 返回携带一致性哈希key的context
+// 使用ConsistentHashSelect策略时，相同key的调用会落到同一个服务实例上
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext 返回context中的一致性哈希key
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
+
+// HashKeyFunc 根据方法名和参数提取一致性哈希key，例如用户ID或者分片号
+type HashKeyFunc func(serviceMethod string, args interface{}) string
@@ -0,0 +1,149 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConsistentHashBalancer(t *testing.T) {
+	servers := make([]string, 10)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("tcp@10.0.0.%d:5000", i)
+	}
+	b := newConsistentHashBalancer(DefaultReplicas)
+	pick := func(servers []string, key string) string {
+		s, err := b.Pick(context.Background(), servers, &CallInfo{Key: key})
+		_assert(err == nil, "unexpected error: %v", err)
+		return s
+	}
+
+	const n = 10000
+	before := make([]string, n)
+	for i := 0; i < n; i++ {
+		before[i] = pick(servers, fmt.Sprintf("user-%d", i))
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		for i := 0; i < n; i++ {
+			s := pick(servers, fmt.Sprintf("user-%d", i))
+			_assert(s == before[i], "the same key should always be mapped to the same server")
+		}
+	})
+
+	t.Run("add server", func(t *testing.T) {
+		added := "tcp@10.0.0.10:5000"
+		moved := 0
+		for i := 0; i < n; i++ {
+			s := pick(append(servers[:10:10], added), fmt.Sprintf("user-%d", i))
+			if s != before[i] {
+				_assert(s == added, "keys should only move to the added server, but %s moved to %s", before[i], s)
+				moved++
+			}
+		}
+		// 理想情况下移动1/11的key
+		_assert(moved > 0 && moved < n*2/11, "expect about 1/11 keys to move, but got %d/%d", moved, n)
+	})
+
+	t.Run("remove server", func(t *testing.T) {
+		removed := servers[3]
+		for i := 0; i < n; i++ {
+			s := pick(append(servers[:3:3], servers[4:]...), fmt.Sprintf("user-%d", i))
+			if before[i] != removed {
+				_assert(s == before[i], "only keys on the removed server should move")
+			} else {
+				_assert(s != removed, "keys should not be mapped to the removed server")
+			}
+		}
+	})
+
+	_, err := b.Pick(context.Background(), servers, &CallInfo{})
+	_assert(err != nil, "Pick without key should fail for consistent hash select")
+}
+
+// firstBalancer 总是选择第一个服务实例，并记录反馈的次数
+type firstBalancer struct{ done int }
+
+func (b *firstBalancer) Pick(_ context.Context, servers []string, _ *CallInfo) (string, error) {
+	return servers[0], nil
+}
+
+func (b *firstBalancer) Done(string, *CallInfo, DoneInfo) { b.done++ }
+
+func TestRegisterBalancer(t *testing.T) {
+	const firstSelect SelectMode = 100
+	b := new(firstBalancer)
+	RegisterBalancer(firstSelect, func() Balancer { return b })
+
+	d := NewMultiServerDiscovery([]string{startServer(t), startServer(t)})
+	xc := NewXClient(d, firstSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "failed to call Foo.Sum: %v", err)
+	}
+	_assert(b.done == 3, "expect 3 feedbacks, but got %d", b.done)
+
+	servers, _ := d.GetAll()
+	s, err := d.Get(firstSelect)
+	_assert(err == nil && s == servers[0], "Get should use the registered balancer, but got %s %v", s, err)
+
+	xc = NewXClient(d, SelectMode(-1), nil)
+	err = xc.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	_assert(err != nil, "expect an error for unsupported select mode")
+}
+
+// releaseBalancer 总是选择第一个服务实例，记录反馈和撤销的服务实例，onPick在选中之后调用
+type releaseBalancer struct {
+	onPick   func(addr string)
+	done     []string
+	released []string
+}
+
+func (b *releaseBalancer) Pick(_ context.Context, servers []string, _ *CallInfo) (string, error) {
+	if b.onPick != nil {
+		b.onPick(servers[0])
+	}
+	return servers[0], nil
+}
+
+func (b *releaseBalancer) Done(addr string, _ *CallInfo, _ DoneInfo) { b.done = append(b.done, addr) }
+
+func (b *releaseBalancer) Release(addr string, _ *CallInfo) { b.released = append(b.released, addr) }
+
+func TestBalancer_Release(t *testing.T) {
+	const releaseSelect SelectMode = 101
+	b := new(releaseBalancer)
+	RegisterBalancer(releaseSelect, func() Balancer { return b })
+
+	first, second := "tcp@127.0.0.1:1", "tcp@127.0.0.1:2"
+	d := NewMultiServerDiscovery([]string{first, second})
+	s, err := d.Get(releaseSelect)
+	_assert(err == nil && s == first, "expect %s, but got %s %v", first, s, err)
+	_assert(len(b.done) == 0, "Get should not report a call result, but got %v", b.done)
+	_assert(len(b.released) == 1 && b.released[0] == first, "Get should release the pick, but got %v", b.released)
+
+	xc := NewXClient(d, releaseSelect, nil)
+	defer func() { _ = xc.Close() }()
+	_ = xc.EnableBreaker(&BreakerOption{MaxFailures: 1, OpenTimeout: time.Millisecond * 10})
+	cb := xc.Breakers().Get(first)
+	g, _ := cb.Allow()
+	cb.Done(g, errors.New("fail"))
+	time.Sleep(time.Millisecond * 20)
+
+	// 半开状态的熔断器通过了Ready检查，在选中之后探测名额被其它调用占用
+	b.released = nil
+	b.onPick = func(addr string) {
+		if addr == first {
+			_, _ = cb.Allow()
+		}
+	}
+	rpcAddr, _, err := xc.pick(context.Background(), []string{first, second}, xc.callInfo(context.Background(), "", nil))
+	_assert(err == nil && rpcAddr == second, "expect %s, but got %s %v", second, rpcAddr, err)
+	_assert(len(b.done) == 0, "an abandoned pick should not report a call result, but got %v", b.done)
+	_assert(len(b.released) == 1 && b.released[0] == first, "expect %s to be released, but got %v", first, b.released)
+}
@@ -0,0 +1,99 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errNoPositiveWeight = errors.New("rpc discovery: no severs with positive weight")
+
+// weightedRoundRobinBalancer 平滑加权轮询(nginx)
+// 每次选择时，所有实例的当前权重加上各自的配置权重，选择当前权重最大的实例，
+// 然后将被选中实例的当前权重减去总权重，权重大的实例被选中得更多，且选择结果分散
+type weightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[string]int // 每个实例的当前权重
+}
+
+func newWeightedRoundRobinBalancer() *weightedRoundRobinBalancer {
+	return &weightedRoundRobinBalancer{current: make(map[string]int)}
+}
+
+func (b *weightedRoundRobinBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best, total := "", 0
+	for _, addr := range servers {
+		weight := info.Weight(addr)
+		if weight <= 0 { // 权重为0的实例不参与选择
+			continue
+		}
+		b.current[addr] += weight
+		total += weight
+		if best == "" || b.current[addr] > b.current[best] {
+			best = addr
+		}
+	}
+	if best == "" {
+		return "", errNoPositiveWeight
+	}
+	b.current[best] -= total
+
+	// 服务列表变化后，清理已经下线的实例
+	if len(b.current) > len(servers) {
+		alive := make(map[string]int, len(servers))
+		for _, addr := range servers {
+			if cur, ok := b.current[addr]; ok {
+				alive[addr] = cur
+			}
+		}
+		b.current = alive
+	}
+	return best, nil
+}
+
+func (b *weightedRoundRobinBalancer) Done(string, *CallInfo, DoneInfo) {}
+
+// weightedRandomBalancer 加权随机选择
+type weightedRandomBalancer struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newWeightedRandomBalancer() *weightedRandomBalancer {
+	return &weightedRandomBalancer{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *weightedRandomBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	total := 0
+	for _, addr := range servers {
+		if weight := info.Weight(addr); weight > 0 {
+			total += weight
+		}
+	}
+	if total == 0 {
+		return "", errNoPositiveWeight
+	}
+
+	b.mu.Lock()
+	n := b.r.Intn(total) // [0, 总权重)之间的随机数
+	b.mu.Unlock()
+
+	for _, addr := range servers {
+		weight := info.Weight(addr)
+		if weight <= 0 {
+			continue
+		}
+		if n < weight {
+			return addr, nil
+		}
+		n -= weight
+	}
+	return "", errNoPositiveWeight
+}
+
+func (b *weightedRandomBalancer) Done(string, *CallInfo, DoneInfo) {}
@@ -0,0 +1,305 @@
+package xclient
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // 关闭状态，请求正常通过
+	StateOpen                         // 打开状态，请求直接被拒绝
+	StateHalfOpen                     // 半开状态，只允许少量探测请求通过
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitOpen 服务实例的熔断器处于打开状态
+var ErrCircuitOpen = errors.New("rpc xclient: circuit breaker is open")
+
+// BreakerOption 熔断器配置
+type BreakerOption struct {
+	MaxFailures      int           // 连续失败次数阈值，达到后打开熔断器，0表示不按连续失败熔断
+	ErrorRate        float64       // 统计窗口内的错误率阈值，取值(0, 1]，0表示不按错误率熔断
+	MinRequests      int           // 统计窗口内至少达到该请求数，才计算错误率
+	Window           time.Duration // 错误率的统计窗口，窗口结束后统计清零
+	OpenTimeout      time.Duration // 打开状态的持续时间，超过后进入半开状态
+	HalfOpenRequests int           // 半开状态允许通过的探测请求数，全部成功后关闭熔断器
+
+	// OnStateChange 状态变化时的回调，在熔断器的锁之外调用
+	OnStateChange func(addr string, from, to BreakerState)
+}
+
+// DefaultBreakerOption 默认熔断器配置
+var DefaultBreakerOption = &BreakerOption{
+	MaxFailures:      5,
+	ErrorRate:        0.5,
+	MinRequests:      20,
+	Window:           time.Second * 10,
+	OpenTimeout:      time.Second * 5,
+	HalfOpenRequests: 1,
+}
+
+// BreakerCounts 熔断器当前统计窗口内的计数
+type BreakerCounts struct {
+	Requests            uint64 // 请求数
+	Successes           uint64 // 成功数
+	Failures            uint64 // 失败数
+	ConsecutiveFailures uint64 // 连续失败数
+}
+
+// CircuitBreaker 单个服务实例的熔断器
+type CircuitBreaker struct {
+	addr string
+	opt  *BreakerOption
+
+	mu          sync.Mutex
+	state       BreakerState
+	counts      BreakerCounts
+	windowStart time.Time // 当前统计窗口的开始时间
+	openedAt    time.Time // 进入打开状态的时间
+	probing     int       // 半开状态下正在进行的探测请求数
+	probed      int       // 半开状态下已经成功的探测请求数
+	generation  uint64    // 状态代数，每次切换状态时递增，用于识别在之前的状态中放行的请求
+}
+
+// NewCircuitBreaker 创建一个服务实例的熔断器
+func NewCircuitBreaker(addr string, opt *BreakerOption) *CircuitBreaker {
+	if opt == nil {
+		opt = DefaultBreakerOption
+	}
+	return &CircuitBreaker{
+		addr:        addr,
+		opt:         opt,
+		windowStart: time.Now(),
+	}
+}
+
+// Ready 判断熔断器是否允许请求通过，不占用半开状态的探测名额
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mu.Lock()
+	from := cb.state
+	to := cb.currentState(time.Now())
+	ready := to == StateClosed || (to == StateHalfOpen && cb.probing < cb.halfOpenRequests())
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return ready
+}
+
+// Allow 判断熔断器是否允许请求通过，并返回放行时的状态代数
+// 返回true时，调用方必须在请求结束后使用返回的代数调用Done或Cancel
+func (cb *CircuitBreaker) Allow() (uint64, bool) {
+	cb.mu.Lock()
+	from := cb.state
+	now := time.Now()
+	allow := false
+	switch cb.currentState(now) {
+	case StateClosed:
+		allow = true
+	case StateHalfOpen:
+		if cb.probing < cb.halfOpenRequests() {
+			cb.probing++
+			allow = true
+		}
+	}
+	to, generation := cb.state, cb.generation
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return generation, allow
+}
+
+// Done 汇报请求结果，generation为Allow返回的代数，err为nil表示请求成功
+// 熔断器在请求放行之后已经切换过状态时，结果不计入统计，
+// 例如关闭状态下放行、半开状态下才结束的请求，不会被当作探测请求
+func (cb *CircuitBreaker) Done(generation uint64, err error) {
+	cb.mu.Lock()
+	from := cb.state
+	now := time.Now()
+
+	state := cb.currentState(now)
+	switch {
+	case generation != cb.generation: // 请求在之前的状态中放行，结果不计入
+	case state == StateClosed:
+		cb.counts.Requests++
+		if err == nil {
+			cb.counts.Successes++
+			cb.counts.ConsecutiveFailures = 0
+		} else {
+			cb.counts.Failures++
+			cb.counts.ConsecutiveFailures++
+			if cb.tripped() {
+				cb.setState(StateOpen, now)
+			}
+		}
+	case state == StateHalfOpen:
+		if cb.probing > 0 {
+			cb.probing--
+		}
+		if err != nil { // 探测失败，重新打开熔断器
+			cb.setState(StateOpen, now)
+			break
+		}
+		cb.probed++
+		if cb.probed >= cb.halfOpenRequests() { // 探测全部成功，关闭熔断器
+			cb.setState(StateClosed, now)
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// Cancel 请求被调用方取消，不计入统计，只释放半开状态的探测名额，generation为Allow返回的代数
+func (cb *CircuitBreaker) Cancel(generation uint64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen && generation == cb.generation && cb.probing > 0 {
+		cb.probing--
+	}
+}
+
+// State 返回熔断器当前状态
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	from := cb.state
+	to := cb.currentState(time.Now())
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return to
+}
+
+// Counts 返回当前统计窗口内的计数
+func (cb *CircuitBreaker) Counts() BreakerCounts {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.counts
+}
+
+// currentState 根据时间推进状态，打开状态超时后进入半开状态，统计窗口结束后清零计数
+func (cb *CircuitBreaker) currentState(now time.Time) BreakerState {
+	switch cb.state {
+	case StateClosed:
+		if cb.opt.Window > 0 && cb.windowStart.Add(cb.opt.Window).Before(now) {
+			cb.counts = BreakerCounts{}
+			cb.windowStart = now
+		}
+	case StateOpen:
+		if cb.openedAt.Add(cb.opt.OpenTimeout).Before(now) {
+			cb.setState(StateHalfOpen, now)
+		}
+	}
+	return cb.state
+}
+
+// tripped 判断是否达到熔断条件
+func (cb *CircuitBreaker) tripped() bool {
+	if cb.opt.MaxFailures > 0 && cb.counts.ConsecutiveFailures >= uint64(cb.opt.MaxFailures) {
+		return true
+	}
+	if cb.opt.ErrorRate > 0 && cb.counts.Requests >= uint64(cb.opt.MinRequests) && cb.counts.Requests > 0 {
+		return float64(cb.counts.Failures)/float64(cb.counts.Requests) >= cb.opt.ErrorRate
+	}
+	return false
+}
+
+// setState 切换状态，并重置对应的计数
+func (cb *CircuitBreaker) setState(state BreakerState, now time.Time) {
+	cb.state = state
+	cb.generation++
+	cb.counts = BreakerCounts{}
+	cb.windowStart = now
+	cb.probing, cb.probed = 0, 0
+	if state == StateOpen {
+		cb.openedAt = now
+	}
+}
+
+func (cb *CircuitBreaker) halfOpenRequests() int {
+	if cb.opt.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return cb.opt.HalfOpenRequests
+}
+
+// notify 状态发生变化时调用回调
+func (cb *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && cb.opt.OnStateChange != nil {
+		cb.opt.OnStateChange(cb.addr, from, to)
+	}
+}
+
+// BreakerStat 单个服务实例熔断器的状态快照，用于暴露监控指标
+type BreakerStat struct {
+	Addr   string
+	State  BreakerState
+	Counts BreakerCounts
+}
+
+// Breakers 按服务地址管理熔断器
+type Breakers struct {
+	opt      *BreakerOption
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewBreakers 创建熔断器集合，所有服务实例共用同一份配置
+func NewBreakers(opt *BreakerOption) *Breakers {
+	if opt == nil {
+		opt = DefaultBreakerOption
+	}
+	return &Breakers{
+		opt:      opt,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get 返回服务地址对应的熔断器，不存在则创建
+func (b *Breakers) Get(addr string) *CircuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb, ok := b.breakers[addr]
+	if !ok {
+		cb = NewCircuitBreaker(addr, b.opt)
+		b.breakers[addr] = cb
+	}
+	return cb
+}
+
+// Stats 返回所有熔断器的状态快照，按地址排序
+func (b *Breakers) Stats() []BreakerStat {
+	b.mu.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(b.breakers))
+	for _, cb := range b.breakers {
+		breakers = append(breakers, cb)
+	}
+	b.mu.Unlock()
+
+	stats := make([]BreakerStat, 0, len(breakers))
+	for _, cb := range breakers {
+		stats = append(stats, BreakerStat{Addr: cb.addr, State: cb.State(), Counts: cb.Counts()})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
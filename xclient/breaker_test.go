@@ -0,0 +1,94 @@
+package xclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []BreakerState
+	cb := NewCircuitBreaker("tcp@localhost:0", &BreakerOption{
+		MaxFailures:      3,
+		OpenTimeout:      time.Millisecond * 50,
+		HalfOpenRequests: 1,
+		OnStateChange: func(addr string, from, to BreakerState) {
+			changes = append(changes, to)
+		},
+	})
+	errFail := errors.New("fail")
+
+	t.Run("consecutive failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			g, ok := cb.Allow()
+			_assert(ok, "closed breaker should allow requests")
+			cb.Done(g, errFail)
+		}
+		_assert(cb.State() == StateOpen, "expect open, but got %s", cb.State())
+		_, ok := cb.Allow()
+		_assert(!ok, "open breaker should reject requests")
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 60)
+		g, ok := cb.Allow()
+		_assert(ok, "half-open breaker should allow a probe")
+		_, ok = cb.Allow()
+		_assert(!ok, "half-open breaker should allow only one probe")
+		cb.Done(g, errFail)
+		_assert(cb.State() == StateOpen, "failed probe should reopen the breaker")
+
+		time.Sleep(time.Millisecond * 60)
+		g, ok = cb.Allow()
+		_assert(ok, "half-open breaker should allow a probe")
+		cb.Done(g, nil)
+		_assert(cb.State() == StateClosed, "successful probe should close the breaker")
+	})
+
+	want := []BreakerState{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}
+	_assert(len(changes) == len(want), "expect %d state changes, but got %v", len(want), changes)
+	for i := range want {
+		_assert(changes[i] == want[i], "expect state changes %v, but got %v", want, changes)
+	}
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	cb := NewCircuitBreaker("tcp@localhost:0", &BreakerOption{
+		ErrorRate:   0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		OpenTimeout: time.Minute,
+	})
+	errFail := errors.New("fail")
+
+	for _, err := range []error{nil, errFail, nil} {
+		g, _ := cb.Allow()
+		cb.Done(g, err)
+	}
+	_assert(cb.State() == StateClosed, "breaker should stay closed below MinRequests")
+	g, _ := cb.Allow()
+	cb.Done(g, errFail)
+	_assert(cb.State() == StateOpen, "breaker should open when error rate reaches the threshold")
+}
+
+func TestCircuitBreaker_StaleResult(t *testing.T) {
+	cb := NewCircuitBreaker("tcp@localhost:0", &BreakerOption{MaxFailures: 1, OpenTimeout: time.Millisecond * 50})
+	errFail := errors.New("fail")
+
+	// slow在关闭状态下放行，熔断器打开并进入半开状态之后才结束
+	slow, _ := cb.Allow()
+	g, _ := cb.Allow()
+	cb.Done(g, errFail)
+	time.Sleep(time.Millisecond * 60)
+	_assert(cb.State() == StateHalfOpen, "expect half-open, but got %s", cb.State())
+
+	cb.Done(slow, nil)
+	_assert(cb.State() == StateHalfOpen, "a request admitted while closed should not count as a probe")
+	probe, ok := cb.Allow()
+	_assert(ok, "the probe slot should still be free")
+	cb.Cancel(slow)
+	_, ok = cb.Allow()
+	_assert(!ok, "a stale cancel should not release the probe slot")
+	cb.Done(probe, nil)
+	_assert(cb.State() == StateClosed, "successful probe should close the breaker")
+}
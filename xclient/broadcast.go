@@ -0,0 +1,145 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BroadcastMode 广播调用的成功条件
+type BroadcastMode int
+
+const (
+	BroadcastAll        BroadcastMode = iota // 所有实例都成功才算成功，任意实例失败时取消其余调用
+	BroadcastQuorum                          // 至少Quorum个实例成功才算成功，无法达到时取消其余调用
+	BroadcastBestEffort                      // 尽力而为，等待所有实例返回，不因失败返回错误
+)
+
+func (m BroadcastMode) String() string {
+	switch m {
+	case BroadcastAll:
+		return "all"
+	case BroadcastQuorum:
+		return "quorum"
+	case BroadcastBestEffort:
+		return "best-effort"
+	default:
+		return "unknown"
+	}
+}
+
+// BroadcastOption 广播调用的选项
+type BroadcastOption struct {
+	Mode   BroadcastMode
+	Quorum int // BroadcastQuorum模式下需要成功的实例数
+}
+
+// BroadcastResult 单个服务实例的调用结果
+type BroadcastResult struct {
+	Addr  string      // 服务实例地址
+	Reply interface{} // 调用成功时的回复，与传入的reply类型相同
+	Err   error       // 调用失败时的错误
+}
+
+// BroadcastError 广播调用没有满足成功条件
+type BroadcastError struct {
+	Mode      BroadcastMode
+	Succeeded int   // 成功的实例数
+	Total     int   // 实例总数
+	Required  int   // 需要成功的实例数
+	Err       error // 第一个失败实例的错误
+}
+
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("rpc xclient: broadcast %s: %d/%d servers succeeded, %d required: %v",
+		e.Mode, e.Succeeded, e.Total, e.Required, e.Err)
+}
+
+func (e *BroadcastError) Unwrap() error { return e.Err }
+
+// BroadcastResults 请求广播到所有的服务实例，返回每个实例的调用结果
+// reply作为回复的原型，每个实例的回复都是新创建的同类型实例，为nil时不接收回复
+// 结果的顺序与服务发现返回的服务列表一致，没有满足成功条件时返回*BroadcastError
+func (xc *XClient) BroadcastResults(ctx context.Context, serviceMethod string, args, reply interface{}, opt *BroadcastOption) ([]BroadcastResult, error) {
+	if opt == nil {
+		opt = &BroadcastOption{Mode: BroadcastAll}
+	}
+	// 获取服务列表
+	servers, err := xc.servers(serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	// 需要成功的实例数
+	required := 0
+	switch opt.Mode {
+	case BroadcastAll:
+		required = len(servers)
+	case BroadcastQuorum:
+		if opt.Quorum <= 0 || opt.Quorum > len(servers) {
+			return nil, fmt.Errorf("rpc xclient: invalid quorum %d for %d servers", opt.Quorum, len(servers))
+		}
+		required = opt.Quorum
+	case BroadcastBestEffort:
+	default:
+		return nil, fmt.Errorf("rpc xclient: unknown broadcast mode %d", opt.Mode)
+	}
+
+	ctx, span := xc.startSpan(ctx, "xclient.BroadcastResults", serviceMethod)
+	results := make([]BroadcastResult, len(servers))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	succeeded, failed := 0, 0
+
+	// 无法满足成功条件时，取消其余的调用
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i, rpcAddr := range servers {
+		wg.Add(1)
+		go func(i int, rpcAddr string) {
+			defer wg.Done()
+
+			// 为每个实例创建新的回复
+			var cloneReply interface{}
+			if reply != nil {
+				cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.callServer(rpcAddr, ctx, serviceMethod, args, cloneReply)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[i] = BroadcastResult{Addr: rpcAddr, Err: err}
+			if err == nil {
+				results[i].Reply = cloneReply
+				succeeded++
+				return
+			}
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if opt.Mode != BroadcastBestEffort && len(servers)-failed < required {
+				cancel() // 已经无法满足成功条件
+			}
+		}(i, rpcAddr)
+	}
+	wg.Wait()
+
+	if succeeded < required {
+		err := &BroadcastError{
+			Mode:      opt.Mode,
+			Succeeded: succeeded,
+			Total:     len(servers),
+			Required:  required,
+			Err:       firstErr,
+		}
+		span.End(err)
+		return results, err
+	}
+	span.End(nil)
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"github.com/Asolmn/tinyrpc"
+	"github.com/Asolmn/tinyrpc/tracing"
+	"testing"
+)
+
+func TestXClient_BroadcastResults(t *testing.T) {
+	alive1, alive2, dead := startServer(t), startServer(t), deadAddr(t)
+	d := NewMultiServerDiscovery([]string{alive1, dead, alive2})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	args := Args{Num1: 1, Num2: 2}
+	var reply int
+
+	check := func(results []BroadcastResult) {
+		_assert(len(results) == 3, "expect 3 results, but got %d", len(results))
+		for i, addr := range []string{alive1, dead, alive2} {
+			_assert(results[i].Addr == addr, "results should follow the server list")
+		}
+		_assert(results[1].Err != nil, "expect an error from the dead server")
+	}
+
+	t.Run("all", func(t *testing.T) {
+		results, err := xc.BroadcastResults(context.Background(), "Foo.Sum", args, &reply, &BroadcastOption{Mode: BroadcastAll})
+		var be *BroadcastError
+		_assert(errors.As(err, &be) && be.Required == 3, "expect a broadcast error, but got %v", err)
+		check(results)
+	})
+
+	t.Run("quorum", func(t *testing.T) {
+		results, err := xc.BroadcastResults(context.Background(), "Foo.Sum", args, &reply, &BroadcastOption{Mode: BroadcastQuorum, Quorum: 2})
+		_assert(err == nil, "quorum of 2 should succeed, but got %v", err)
+		check(results)
+		_assert(*results[0].Reply.(*int) == 3 && *results[2].Reply.(*int) == 3, "expect replies from alive servers")
+
+		_, err = xc.BroadcastResults(context.Background(), "Foo.Sum", args, &reply, &BroadcastOption{Mode: BroadcastQuorum, Quorum: 4})
+		_assert(err != nil, "quorum larger than the number of servers should fail")
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		results, err := xc.BroadcastResults(context.Background(), "Foo.Sum", args, nil, &BroadcastOption{Mode: BroadcastBestEffort})
+		_assert(err == nil, "best effort should not fail, but got %v", err)
+		check(results)
+		_assert(results[0].Err == nil && results[2].Err == nil, "alive servers should succeed")
+	})
+}
+
+func TestXClient_BroadcastTracing(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	d := NewMultiServerDiscovery([]string{startServer(t), startServer(t)})
+	xc := NewXClient(d, RandomSelect, &tinyrpc.Option{Tracer: tracing.NewTracer(exporter)})
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	err := xc.Broadcaset(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "broadcast failed: %v", err)
+
+	// 建立连接和发送给每个实例的调用都是广播span的子span
+	spans := exporter.Spans()
+	_assert(len(spans) == 5, "expect 5 spans, but got %d", len(spans))
+	parent := spans[len(spans)-1]
+	_assert(parent.Name == "xclient.Broadcast" && parent.Kind == tracing.KindInternal, "the broadcast span should end last")
+	calls, dials := 0, 0
+	for _, s := range spans[:len(spans)-1] {
+		_assert(s.TraceID == parent.TraceID && s.ParentID == parent.SpanID, "%s should be a child of the broadcast span", s.Name)
+		switch s.Name {
+		case "Foo.Sum":
+			calls++
+		case "tinyrpc.Dial":
+			dials++
+		}
+	}
+	_assert(calls == 2 && dials == 2, "expect 2 calls and 2 dials, but got %d and %d", calls, dials)
+}
@@ -3,50 +3,111 @@
 package xclient
 
 import (
+	"context"
 	"errors"
-	"math"
-	"math/rand"
+	"github.com/Asolmn/tinyrpc/registry"
 	"sync"
-	"time"
 )
 
-type SelectMode int // 表示不同的负载均衡策略
+type SelectMode int // 表示不同的负载均衡策略，每种策略对应一个Balancer
 
-// 只实现Random和RoundRobin两种策略
 const (
-	RandomSelect     SelectMode = iota // Random选择
-	RoundRobinSelect                   // Robbin算法选择
+	RandomSelect             SelectMode = iota // Random选择
+	RoundRobinSelect                           // Robbin算法选择
+	WeightedRoundRobinSelect                   // 平滑加权轮询选择
+	WeightedRandomSelect                       // 加权随机选择
+	ConsistentHashSelect                       // 一致性哈希选择，需要通过WithHashKey或者XClient.SetHashKeyFunc指定key
+	LeastPendingSelect                         // 选择未完成调用数最少的实例
+	P2CSelect                                  // 随机选择两个实例，选择EWMA延迟与未完成调用数评分更低的实例
 )
 
 // Discovery 包含服务发现所需要的最基本的接口
+// 服务发现只负责找到服务实例，从中选择一个实例由Balancer负责
 type Discovery interface {
-	Refresh() error                      // 从注册中心更新服务列表
-	Update(servers []string) error       // 手动更新服务列表
-	Get(mode SelectMode) (string, error) // 根据负载均衡策略，选择一个服务实例
-	GetAll() ([]string, error)           // 返回所有服务实例
+	Refresh() error                // 从注册中心更新服务列表
+	Update(servers []string) error // 手动更新服务列表，地址可以附带权重，例如tcp@localhost:5000?weight=3
+	GetAll() ([]string, error)     // 返回所有服务实例
+}
+
+// WeightedDiscovery 可以提供服务实例权重的服务发现，加权负载均衡策略使用
+type WeightedDiscovery interface {
+	Discovery
+	Weight(addr string) int // 返回服务实例的权重
+}
+
+// ServiceDiscovery 可以按服务名返回服务实例的服务发现
+// XClient调用时只从提供该服务的服务实例中选择
+type ServiceDiscovery interface {
+	Discovery
+	GetService(service string) ([]string, error) // 返回提供service的服务实例
+}
+
+// MetadataDiscovery 可以提供服务实例元数据的服务发现，例如可用区、版本、标签
+// 负载均衡器可以通过CallInfo.Metadata获取元数据，实现按元数据路由
+type MetadataDiscovery interface {
+	Discovery
+	Metadata(addr string) map[string]string // 返回服务实例的元数据，不存在时返回nil
+}
+
+// DefaultWeight 未设置权重的服务实例的默认权重
+const DefaultWeight = 1
+
+// ParseServer 解析带有元数据的服务地址，返回服务地址和权重
+// 元数据以查询字符串的形式附加在地址之后，例如: tcp@localhost:5000?weight=3
+// 没有设置或者设置了非法权重时，返回DefaultWeight
+func ParseServer(server string) (addr string, weight int) {
+	inst := registry.ParseInstance(server)
+	return inst.Addr, inst.Weight(DefaultWeight)
 }
 
 // MultiServerDiscovery 没有注册中心的多服务发现
 // 用户改为显示提供服务器地址
 type MultiServerDiscovery struct {
-	r       *rand.Rand // 生成随机数
-	mu      sync.RWMutex
-	servers []string // 服务列表
-	index   int      // 记录Robin算法的轮询到的位置
+	mu        sync.RWMutex
+	servers   []string                     // 服务列表，不包含元数据
+	weights   map[string]int               // 服务实例的权重
+	metadata  map[string]map[string]string // 服务实例的元数据
+	balancers map[SelectMode]Balancer      // Get使用的负载均衡器，按策略懒加载
 }
 
 // NewMultiServerDiscovery 创建要一个MultiServerDiscovery实例
 func NewMultiServerDiscovery(servers []string) *MultiServerDiscovery {
 	d := &MultiServerDiscovery{
-		servers: servers,
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())), // 初始化使用时间戳设定随机数种子
+		balancers: make(map[SelectMode]Balancer),
 	}
-	d.index = d.r.Intn(math.MaxInt32 - 1) // 生成要给整数的随机数
+	d.setServers(servers)
 	return d
 }
 
-// 验证MultiServerDiscovery是否满足Discovery接口
-var _ Discovery = (*MultiServerDiscovery)(nil)
+// setServers 解析服务地址中的元数据，设置服务列表，调用方需要持有锁
+func (d *MultiServerDiscovery) setServers(servers []string) {
+	instances := make([]*registry.Instance, 0, len(servers))
+	for _, server := range servers {
+		instances = append(instances, registry.ParseInstance(server))
+	}
+	d.setInstances(instances)
+}
+
+// setInstances 设置服务列表以及服务实例的元数据，权重从元数据中获取，调用方需要持有锁
+func (d *MultiServerDiscovery) setInstances(instances []*registry.Instance) {
+	d.servers = make([]string, 0, len(instances))
+	d.weights = make(map[string]int, len(instances))
+	d.metadata = make(map[string]map[string]string, len(instances))
+	for _, inst := range instances {
+		if inst.Addr == "" {
+			continue
+		}
+		d.servers = append(d.servers, inst.Addr)
+		d.weights[inst.Addr] = inst.Weight(DefaultWeight)
+		d.metadata[inst.Addr] = inst.Metadata
+	}
+}
+
+// 验证MultiServerDiscovery是否满足WeightedDiscovery和MetadataDiscovery接口
+var (
+	_ WeightedDiscovery = (*MultiServerDiscovery)(nil)
+	_ MetadataDiscovery = (*MultiServerDiscovery)(nil)
+)
 
 // Refresh 从注册中心更新服务列表
 func (d *MultiServerDiscovery) Refresh() error {
@@ -59,30 +120,81 @@ func (d *MultiServerDiscovery) Update(servers []string) error {
 	defer d.mu.Unlock()
 
 	// 重新设置服务列表
-	d.servers = servers
+	d.setServers(servers)
 	return nil
 }
 
-// Get 根据负载均衡策略，选择一个服务实例
-func (d *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
+// SetWeight 设置服务实例的权重，权重为0的实例不会被加权策略选中
+func (d *MultiServerDiscovery) SetWeight(addr string, weight int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if weight < 0 {
+		return errors.New("rpc discovery: weight must not be negative")
+	}
+	if _, ok := d.weights[addr]; !ok {
+		return errors.New("rpc discovery: unknown server " + addr)
+	}
+	d.weights[addr] = weight
+	return nil
+}
+
+// Weight 返回服务实例的权重
+func (d *MultiServerDiscovery) Weight(addr string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if weight, ok := d.weights[addr]; ok {
+		return weight
+	}
+	return DefaultWeight
+}
+
+// UpdateInstances 手动更新服务列表，服务实例可以携带任意元数据
+func (d *MultiServerDiscovery) UpdateInstances(instances []*registry.Instance) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	n := len(d.servers) // 获取服务数量
-	if n == 0 {
+	d.setInstances(instances)
+	return nil
+}
+
+// Metadata 返回服务实例的元数据
+func (d *MultiServerDiscovery) Metadata(addr string) map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.metadata[addr]
+}
+
+// Get 根据负载均衡策略，选择一个服务实例
+// 保留用于兼容，XClient使用GetAll和Balancer选择服务实例
+func (d *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 { // 获取服务数量
 		return "", errors.New("rpc discovery: no available severs")
 	}
 
-	switch mode { // 选择不同的负载均衡策略
-	case RandomSelect: // 随机选择
-		return d.servers[d.r.Intn(n)], nil
-	case RoundRobinSelect: // Robin算法
-		s := d.servers[d.index%n]   // 从服务发现列表中，获取需要调度执行的服务
-		d.index = (d.index + 1) % n // 通过Robin算法更新轮询位置
-		return s, nil
-	default:
-		return "", errors.New("rpc discovery: not supported select mode")
+	d.mu.Lock()
+	b, ok := d.balancers[mode]
+	if !ok {
+		if b, err = NewBalancer(mode); err != nil {
+			d.mu.Unlock()
+			return "", err
+		}
+		d.balancers[mode] = b
+	}
+	d.mu.Unlock()
+
+	info := &CallInfo{weight: d.Weight, metadata: d.Metadata}
+	s, err := b.Pick(context.Background(), servers, info)
+	if err == nil { // 只返回地址，不会发起调用，撤销这次选择
+		release(b, s, info)
 	}
+	return s, err
 }
 
 // GetAll 返回所有的服务实例
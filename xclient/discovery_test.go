@@ -0,0 +1,54 @@
+package xclient
+
+import "testing"
+
+func TestParseServer(t *testing.T) {
+	addr, weight := ParseServer("tcp@localhost:5000?weight=3")
+	_assert(addr == "tcp@localhost:5000" && weight == 3, "expect tcp@localhost:5000 with weight 3, but got %s %d", addr, weight)
+
+	addr, weight = ParseServer(" http@localhost:5000 ")
+	_assert(addr == "http@localhost:5000" && weight == DefaultWeight, "expect default weight, but got %s %d", addr, weight)
+
+	_, weight = ParseServer("tcp@localhost:5000?weight=-1")
+	_assert(weight == DefaultWeight, "negative weight should fall back to default, but got %d", weight)
+}
+
+func TestMultiServerDiscovery_Weighted(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a?weight=5", "tcp@b?weight=1", "tcp@c?weight=1"})
+
+	all, _ := d.GetAll()
+	_assert(len(all) == 3 && all[0] == "tcp@a", "GetAll should return addresses without metadata, but got %v", all)
+
+	t.Run("smooth weighted round robin", func(t *testing.T) {
+		var picks []string
+		for i := 0; i < 7; i++ {
+			s, err := d.Get(WeightedRoundRobinSelect)
+			_assert(err == nil, "unexpected error: %v", err)
+			picks = append(picks, s)
+		}
+		// nginx平滑加权轮询对{5,1,1}的经典选择序列
+		want := []string{"tcp@a", "tcp@a", "tcp@b", "tcp@a", "tcp@c", "tcp@a", "tcp@a"}
+		for i := range want {
+			_assert(picks[i] == want[i], "expect %v, but got %v", want, picks)
+		}
+	})
+
+	t.Run("weighted random", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 7000; i++ {
+			s, _ := d.Get(WeightedRandomSelect)
+			counts[s]++
+		}
+		_assert(counts["tcp@a"] > 4000 && counts["tcp@b"] > 600 && counts["tcp@c"] > 600,
+			"picks should follow weights 5:1:1, but got %v", counts)
+	})
+
+	t.Run("zero weight", func(t *testing.T) {
+		_ = d.SetWeight("tcp@a", 0)
+		for i := 0; i < 10; i++ {
+			s, _ := d.Get(WeightedRoundRobinSelect)
+			_assert(s != "tcp@a", "server with zero weight should not be selected")
+		}
+		_assert(d.SetWeight("tcp@unknown", 1) != nil, "expect an error for unknown server")
+	})
+}
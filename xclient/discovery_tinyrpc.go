@@ -1,24 +1,47 @@
 package xclient
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"github.com/Asolmn/tinyrpc/logging"
+	"github.com/Asolmn/tinyrpc/registry"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // TinyRegistryDiscory 带有注册中心的服务端发现实例
 type TinyRegistryDiscory struct {
-	*MultiServerDiscovery               // 嵌套没有注册中心的服务发现，方便复用
-	registry              string        // 注册中心的地址
-	timeout               time.Duration // 服务列表的过期时间
-	lastUpdate            time.Time     // 最后从注册中心更新服务列表的时间，默认是10s
+	*MultiServerDiscovery                          // 嵌套没有注册中心的服务发现，方便复用
+	registries            []string                 // 注册中心的地址，请求失败时依次尝试下一个
+	current               atomic.Int32             // 最近一次请求成功的注册中心
+	timeout               time.Duration            // 服务列表的过期时间
+	lastUpdate            time.Time                // 最后从注册中心更新服务列表的时间，默认是10s
+	version               string                   // 只发现该版本的服务，为空表示不限制版本
+	instances             []*registry.Instance     // 所有服务实例，已经按照version过滤
+	services              map[string]*serviceCache // 按服务名缓存的服务列表
+	fetching              map[string]bool          // 正在从注册中心更新的服务，空字符串表示所有服务实例
+	watcher               *registryWatcher         // 后台watch注册中心，为nil表示没有启用watch
+	logger                logging.Logger           // 输出日志使用的Logger，为nil时使用logging.Default()
 }
 
+// serviceCache 单个服务的服务列表缓存
+type serviceCache struct {
+	servers    []string             // 提供该服务的服务端，不包含元数据
+	instances  []*registry.Instance // 提供该服务的服务实例，包含权重和元数据
+	lastUpdate time.Time            // 最后从注册中心更新的时间
+}
+
+// 验证TinyRegistryDiscory是否满足ServiceDiscovery接口
+var _ ServiceDiscovery = (*TinyRegistryDiscory)(nil)
+
 // 默认注册中心服务列表过期时间
 const defaultUpdateTimeout = time.Second * 10
 
 // NewTinyRegistryDiscovery 初始化服务端发现实例
+// registerAddr可以是以逗号分隔的多个注册中心地址，例如注册中心集群的所有节点
 func NewTinyRegistryDiscovery(registerAddr string, timeout time.Duration) *TinyRegistryDiscory {
 	// 设置服务列表的过期时间
 	if timeout == 0 {
@@ -28,61 +51,285 @@ func NewTinyRegistryDiscovery(registerAddr string, timeout time.Duration) *TinyR
 	// 初始化实例
 	d := &TinyRegistryDiscory{
 		MultiServerDiscovery: NewMultiServerDiscovery(make([]string, 0)),
-		registry:             registerAddr,
+		registries:           registry.ParseRegistries(registerAddr),
 		timeout:              timeout,
+		services:             make(map[string]*serviceCache),
+		fetching:             make(map[string]bool),
 	}
 	return d
 }
 
+// SetVersion 只发现指定版本的服务，GetAll和GetService都只返回该版本的服务实例，需要在发起调用之前设置
+func (d *TinyRegistryDiscory) SetVersion(version string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.version = version
+	d.services = make(map[string]*serviceCache)
+	if d.watched() { // 从watch维护的服务列表中重新过滤
+		d.setInstances(versioned(d.watcher.instances, version))
+		return
+	}
+	d.lastUpdate = time.Time{} // 服务列表属于旧版本，下次使用时从注册中心更新
+}
+
+// SetLogger 设置输出日志使用的Logger，为nil时使用logging.Default()，需要在发起调用之前设置
+func (d *TinyRegistryDiscory) SetLogger(l logging.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.logger = l
+}
+
+// log 返回输出日志使用的Logger
+func (d *TinyRegistryDiscory) log() logging.Logger {
+	return logging.Or(d.logger)
+}
+
 // Update 手动更新服务列表
 func (d *TinyRegistryDiscory) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.servers = servers
+	instances := make([]*registry.Instance, 0, len(servers))
+	for _, server := range servers {
+		instances = append(instances, registry.ParseInstance(server))
+	}
+	d.setInstances(instances)
 	d.lastUpdate = time.Now()
 
 	return nil
 }
 
+// setInstances 设置所有服务实例，并根据它和按服务缓存的服务实例重建权重和元数据，调用方需要持有锁
+// 权重和元数据只包含最近一次从注册中心获取的服务实例，已经离开注册中心的地址不会一直保留
+func (d *TinyRegistryDiscory) setInstances(instances []*registry.Instance) {
+	d.instances = instances
+	d.MultiServerDiscovery.setInstances(instances)
+	for _, cache := range d.services {
+		for _, inst := range cache.instances {
+			d.weights[inst.Addr] = inst.Weight(DefaultWeight)
+			d.metadata[inst.Addr] = inst.Metadata
+		}
+	}
+}
+
+// versioned 返回提供version版本服务的服务实例，version为空时返回所有服务实例
+func versioned(instances []*registry.Instance, version string) []*registry.Instance {
+	if version == "" {
+		return instances
+	}
+	var matched []*registry.Instance
+	for _, inst := range instances {
+		if inst.Provides("", version) {
+			matched = append(matched, inst)
+		}
+	}
+	return matched
+}
+
 // Refresh 从注册中心更新服务列表
+// 请求注册中心时不持有锁，已经有服务列表时，请求失败或者其他协程正在更新都继续使用旧的服务列表
 func (d *TinyRegistryDiscory) Refresh() error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-
 	// 检查最后的更新时间是否超过设置默认更新时间间隔
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+	// 启用watch时，服务列表由后台协程更新
+	if d.watched() || d.lastUpdate.Add(d.timeout).After(time.Now()) {
+		d.mu.Unlock()
+		return nil
+	}
+	stale := !d.lastUpdate.IsZero()
+	if stale && d.fetching[""] {
+		d.mu.Unlock()
 		return nil
 	}
+	d.fetching[""] = true
+	version := d.version
+	d.mu.Unlock()
+
+	instances, err := d.fetch("", version)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.fetching, "")
+	if err != nil {
+		if stale {
+			return nil
+		}
+		return err
+	}
+	// 设置服务发现实例中的服务列表和元数据，空地址会被忽略
+	// 旧版本的注册中心不按照version过滤，请求期间调用了SetVersion时也需要按照当前的版本过滤
+	d.setInstances(versioned(instances, d.version))
+	// 更新设置服务列表的时间
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// registryClient 请求注册中心使用的客户端，超时后尝试下一个注册中心
+var registryClient = &http.Client{Timeout: time.Second * 10}
+
+// failover 从最近一次请求成功的注册中心开始依次尝试，直到请求成功，返回最后一个错误
+func (d *TinyRegistryDiscory) failover(f func(registry string) error) error {
+	err := errors.New("rpc registry: no registry address")
+	start := int(d.current.Load())
+	for i := 0; i < len(d.registries); i++ {
+		idx := (start + i) % len(d.registries)
+		if err = f(d.registries[idx]); err == nil {
+			d.current.Store(int32(idx))
+			return nil
+		}
+	}
+	return err
+}
+
+// fetch 从注册中心获取提供service的服务实例，service为空时获取提供version版本任意服务的实例，version为空表示不限制版本
+// 优先使用注册中心的REST API，注册中心不支持时使用X-Tinyrpc-Servers
+// 请求可能在多个注册中心之间重试，调用方不能持有锁
+func (d *TinyRegistryDiscory) fetch(service, version string) ([]*registry.Instance, error) {
+	query := url.Values{}
+	if service != "" {
+		query.Set("service", service)
+	}
+	if version != "" {
+		query.Set("version", version)
+	}
+
+	var instances []*registry.Instance
+	err := d.failover(func(registryAddr string) error {
+		var ok bool
+		var err error
+		if instances, ok, err = d.fetchAPI(registryAddr, query); ok || err != nil {
+			return err
+		}
+		instances, err = d.fetchLegacy(registryAddr, query)
+		return err
+	})
+	return instances, err
+}
+
+// fetchAPI 通过REST API获取服务实例，注册中心不支持REST API时返回false
+func (d *TinyRegistryDiscory) fetchAPI(registryAddr string, query url.Values) ([]*registry.Instance, bool, error) {
+	registryAddr = strings.TrimSuffix(registryAddr, "/") + "/v1/instances"
+	if len(query) > 0 {
+		registryAddr += "?" + query.Encode()
+	}
 
 	// 向注册中心发送get请求，获取响应
-	log.Println("rpc registry: refresh servers from register", d.registry)
-	resp, err := http.Get(d.registry)
+	d.log().Debug("rpc registry: refresh servers from registry", "registry", registryAddr)
+	resp, err := registryClient.Get(registryAddr)
 
 	// 检验更新服务列表情况
 	if err != nil {
-		log.Println("rpc registry refresh err: ", resp)
-		return err
+		d.log().Warn("rpc registry: refresh error", "registry", registryAddr, "err", err)
+		return nil, false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// 旧版本的注册中心没有REST API，返回404或者不返回JSON
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		err = errors.New("rpc registry: " + resp.Status + " " + body.Error)
+		d.log().Warn("rpc registry: refresh error", "registry", registryAddr, "err", err)
+		return nil, true, err
+	}
+
+	var body struct {
+		Instances []*registry.Instance `json:"instances"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		d.log().Warn("rpc registry: refresh error", "registry", registryAddr, "err", err)
+		return nil, true, err
 	}
+	return body.Instances, true, nil
+}
 
-	// 获取服务端列表
-	servers := strings.Split(resp.Header.Get("X-Tinyrpc-Servers"), ",")
-	// 初始化服务发现实例中的服务列表
-	d.servers = make([]string, 0, len(servers))
+// fetchLegacy 通过X-Tinyrpc-Servers获取服务实例，服务端地址可能附带权重
+func (d *TinyRegistryDiscory) fetchLegacy(registryAddr string, query url.Values) ([]*registry.Instance, error) {
+	if len(query) > 0 {
+		registryAddr += "?" + query.Encode()
+	}
 
-	// 遍历从响应中获取的服务端列表
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" { // 如果服务端地址不为空
-			// 添加到服务发现列表
-			d.servers = append(d.servers, strings.TrimSpace(server))
+	d.log().Debug("rpc registry: refresh servers from registry", "registry", registryAddr)
+	resp, err := registryClient.Get(registryAddr)
+	if err != nil {
+		d.log().Warn("rpc registry: refresh error", "registry", registryAddr, "err", err)
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc registry: " + resp.Status)
+	}
+
+	var instances []*registry.Instance
+	for _, server := range strings.Split(resp.Header.Get("X-Tinyrpc-Servers"), ",") {
+		instances = append(instances, registry.ParseInstance(server))
+	}
+	return instances, nil
+}
+
+// GetService 返回提供service的服务实例，按服务名缓存，过期后从注册中心更新
+// 请求注册中心时不持有锁，已经有缓存时，请求失败或者其他协程正在更新都返回旧的缓存
+func (d *TinyRegistryDiscory) GetService(service string) ([]string, error) {
+	d.mu.Lock()
+	// 启用watch时，从后台协程维护的服务列表中过滤
+	if d.watched() {
+		defer d.mu.Unlock()
+		return d.filter(service), nil
+	}
+
+	cache, ok := d.services[service]
+	if ok && (cache.lastUpdate.Add(d.timeout).After(time.Now()) || d.fetching[service]) {
+		defer d.mu.Unlock()
+		return cache.copyServers(), nil
+	}
+	d.fetching[service] = true
+	version := d.version
+	d.mu.Unlock()
+
+	instances, err := d.fetch(service, version)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.fetching, service)
+	if err != nil {
+		if ok {
+			return cache.copyServers(), nil
 		}
+		return nil, err
 	}
-	// 更新设置服务列表的时间
-	d.lastUpdate = time.Now()
-	return nil
+	cache = &serviceCache{lastUpdate: time.Now()}
+	for _, inst := range instances {
+		if inst.Addr == "" {
+			continue
+		}
+		cache.servers = append(cache.servers, inst.Addr)
+		cache.instances = append(cache.instances, inst)
+	}
+	// 请求期间调用了SetVersion时，结果属于旧版本，不写入缓存
+	if version == d.version {
+		d.services[service] = cache
+		// 权重和元数据按服务端地址记录，供负载均衡使用，使用新的缓存重建，去掉已经离开注册中心的地址
+		d.setInstances(d.instances)
+	}
+	return cache.copyServers(), nil
+}
+
+// copyServers 返回服务列表的副本，调用方需要持有锁
+func (c *serviceCache) copyServers() []string {
+	servers := make([]string, len(c.servers))
+	copy(servers, c.servers)
+	return servers
 }
 
 // Get 根据负载策略，返回一个服务端地址
+// 保留用于兼容，XClient使用GetAll和Balancer选择服务实例
 func (d *TinyRegistryDiscory) Get(mode SelectMode) (string, error) {
 	// 从注册中心更新服务端列表
 	if err := d.Refresh(); err != nil {
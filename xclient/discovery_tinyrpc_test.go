@@ -0,0 +1,283 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"github.com/Asolmn/tinyrpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startRegistry 启动一个注册中心，返回注册中心的地址
+func startRegistry(t *testing.T) string {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	t.Cleanup(ts.Close)
+	return ts.URL + "/_tinyrpc_/registry"
+}
+
+func TestTinyRegistryDiscovery_Services(t *testing.T) {
+	reg := startRegistry(t)
+	foo, other := startServer(t), startServer(t)
+	registry.Heartbeat(reg, foo, time.Minute, "Foo")
+	registry.Heartbeat(reg, other, time.Minute, "Bar")
+
+	d := NewTinyRegistryDiscovery(reg, 0)
+	all, err := d.GetAll()
+	_assert(err == nil && len(all) == 2, "expect 2 servers, but got %v %v", all, err)
+
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == foo, "expect only %s, but got %v %v", foo, servers, err)
+
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	results, err := xc.BroadcastResults(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, nil)
+	_assert(err == nil && len(results) == 1 && results[0].Addr == foo, "broadcast should only reach %s, but got %v %v", foo, results, err)
+	for i := 0; i < 5; i++ {
+		err = xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "failed to call Foo.Sum: %v", err)
+	}
+
+	_, err = xc.Race(context.Background(), 2, "Baz.Sum", Args{}, &reply)
+	_assert(err != nil, "expect an error for a service without servers")
+}
+
+func TestTinyRegistryDiscovery_Metadata(t *testing.T) {
+	reg := startRegistry(t)
+	a, b := startServer(t), startServer(t)
+	registry.HeartbeatInstance(reg, &registry.Instance{
+		Addr:     a,
+		Services: []string{"Foo"},
+		Metadata: map[string]string{registry.MetaZone: "z1", registry.MetaWeight: "4"},
+	}, time.Minute)
+	registry.Heartbeat(reg, b+"?zone=z2", time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(reg, 0)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 2, "expect 2 servers, but got %v %v", servers, err)
+	_assert(d.Metadata(a)[registry.MetaZone] == "z1" && d.Weight(a) == 4, "unexpected metadata of %s: %v", a, d.Metadata(a))
+	_assert(d.Metadata(b)[registry.MetaZone] == "z2" && d.Weight(b) == DefaultWeight, "unexpected metadata of %s: %v", b, d.Metadata(b))
+
+	// 负载均衡器通过CallInfo获取元数据，只选择z2的服务实例
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	zb := &zoneBalancer{zone: "z2"}
+	xc.SetBalancer(zb)
+	var reply int
+	for i := 0; i < 5; i++ {
+		_assert(xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply) == nil, "failed to call Foo.Sum")
+		_assert(zb.picked == b, "expect %s in zone z2, but picked %s", b, zb.picked)
+	}
+}
+
+// zoneBalancer 只选择指定可用区的服务实例
+type zoneBalancer struct {
+	zone   string
+	picked string
+}
+
+func (b *zoneBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	for _, s := range servers {
+		if info.Metadata(s)[registry.MetaZone] == b.zone {
+			b.picked = s
+			return s, nil
+		}
+	}
+	return "", errors.New("no server in zone " + b.zone)
+}
+
+func (b *zoneBalancer) Done(string, *CallInfo, DoneInfo) {}
+
+func TestTinyRegistryDiscovery_Legacy(t *testing.T) {
+	// 旧版本的注册中心只支持X-Tinyrpc-Servers
+	r := registry.New(time.Minute)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/v1/") {
+			http.NotFound(w, req)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	t.Cleanup(ts.Close)
+	reg := ts.URL + "/_tinyrpc_/registry"
+
+	foo := startServer(t)
+	registry.Heartbeat(reg, foo+"?weight=3", time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(reg, 0)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == foo, "expect only %s, but got %v %v", foo, servers, err)
+	_assert(d.Weight(foo) == 3, "expect weight 3, but got %d", d.Weight(foo))
+}
+
+func TestTinyRegistryDiscovery_Watch(t *testing.T) {
+	reg := startRegistry(t)
+	foo := startServer(t)
+	registry.Heartbeat(reg, foo, time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(reg, time.Hour) // 过期时间很长，只能通过watch获取变化
+	_assert(d.Watch() == nil, "failed to watch")
+	defer func() { _ = d.Close() }()
+
+	servers, _ := d.GetService("Foo")
+	_assert(len(servers) == 1 && servers[0] == foo, "expect only %s, but got %v", foo, servers)
+	revision := d.Revision()
+
+	bar := startServer(t)
+	h := registry.Heartbeat(reg, bar, time.Minute, "Foo")
+	waitFor := func(n int) []string {
+		for i := 0; i < 100; i++ {
+			if servers, _ = d.GetService("Foo"); len(servers) == n {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return servers
+	}
+	servers = waitFor(2)
+	_assert(len(servers) == 2 && d.Revision() > revision, "expect 2 servers after register, but got %v (revision %d)", servers, d.Revision())
+
+	_ = h.Deregister()
+	servers = waitFor(1)
+	_assert(len(servers) == 1 && servers[0] == foo, "expect only %s after deregister, but got %v", foo, servers)
+}
+
+func TestTinyRegistryDiscovery_Failover(t *testing.T) {
+	dead := httptest.NewServer(registry.New(time.Minute))
+	dead.Close()
+	reg := startRegistry(t)
+	foo := startServer(t)
+	registries := dead.URL + "/_tinyrpc_/registry," + reg
+	registry.Heartbeat(registries, foo, time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(registries, 0)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == foo, "expect %s from the second registry, but got %v %v", foo, servers, err)
+
+	w := NewTinyRegistryDiscovery(registries, 0)
+	_assert(w.Watch() == nil, "watch should fail over to the second registry")
+	defer func() { _ = w.Close() }()
+	servers, err = w.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == foo, "expect %s from watch, but got %v %v", foo, servers, err)
+}
+
+func TestTinyRegistryDiscovery_Stale(t *testing.T) {
+	var down atomic.Bool
+	release := make(chan struct{})
+	r := registry.New(time.Minute)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if down.Load() { // 注册中心不可用，请求阻塞到release关闭之后返回错误
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	reg := ts.URL + "/_tinyrpc_/registry"
+	foo := startServer(t)
+	registry.Heartbeat(reg, foo+"?weight=3", time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(reg, time.Millisecond*10)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1, "expect 1 server, but got %v %v", servers, err)
+	all, err := d.GetAll()
+	_assert(err == nil && len(all) == 1, "expect 1 server, but got %v %v", all, err)
+
+	down.Store(true)
+	time.Sleep(time.Millisecond * 20)
+	type result struct {
+		servers []string
+		err     error
+	}
+	ch := make(chan result, 2)
+	go func() {
+		servers, err := d.GetService("Foo")
+		ch <- result{servers, err}
+	}()
+	go func() {
+		servers, err := d.GetAll()
+		ch <- result{servers, err}
+	}()
+
+	// 请求注册中心期间，读取权重和缓存不会被阻塞
+	time.Sleep(time.Millisecond * 20)
+	_assert(d.Weight(foo) == 3, "expect weight 3, but got %d", d.Weight(foo))
+	servers, err = d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1, "expect the stale cache while refreshing, but got %v %v", servers, err)
+	all, err = d.GetAll()
+	_assert(err == nil && len(all) == 1, "expect the stale list while refreshing, but got %v %v", all, err)
+
+	// 注册中心返回错误时，继续使用旧的服务列表
+	close(release)
+	for i := 0; i < 2; i++ {
+		res := <-ch
+		_assert(res.err == nil && len(res.servers) == 1 && res.servers[0] == foo, "expect the stale %s, but got %v %v", foo, res.servers, res.err)
+	}
+}
+
+func TestTinyRegistryDiscovery_Version(t *testing.T) {
+	reg := startRegistry(t)
+	v1, v2 := startServer(t), startServer(t)
+	registry.Heartbeat(reg, v1, time.Minute, "Foo@v1")
+	registry.Heartbeat(reg, v2, time.Minute, "Foo@v2")
+
+	d := NewTinyRegistryDiscovery(reg, 0)
+	d.SetVersion("v1")
+	all, err := d.GetAll()
+	_assert(err == nil && len(all) == 1 && all[0] == v1, "expect only %s of v1, but got %v %v", v1, all, err)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == v1, "expect only %s of v1, but got %v %v", v1, servers, err)
+
+	// watch获取所有服务实例，GetAll同样只返回指定版本的服务实例
+	w := NewTinyRegistryDiscovery(reg, 0)
+	_assert(w.Watch() == nil, "failed to watch")
+	defer func() { _ = w.Close() }()
+	w.SetVersion("v2")
+	all, err = w.GetAll()
+	_assert(err == nil && len(all) == 1 && all[0] == v2, "expect only %s of v2 from watch, but got %v %v", v2, all, err)
+}
+
+func TestTinyRegistryDiscovery_Prune(t *testing.T) {
+	reg := startRegistry(t)
+	a, b := startServer(t), startServer(t)
+	registry.Heartbeat(reg, a, time.Minute, "Foo")
+	h := registry.Heartbeat(reg, b+"?zone=z2&weight=3", time.Minute, "Foo")
+
+	d := NewTinyRegistryDiscovery(reg, time.Millisecond*10)
+	servers, err := d.GetService("Foo")
+	_assert(err == nil && len(servers) == 2, "expect 2 servers, but got %v %v", servers, err)
+	_assert(d.Weight(b) == 3 && d.Metadata(b)[registry.MetaZone] == "z2", "unexpected metadata of %s: %v", b, d.Metadata(b))
+
+	// 离开注册中心的服务实例，权重和元数据不再保留
+	_ = h.Deregister()
+	time.Sleep(time.Millisecond * 20)
+	servers, err = d.GetService("Foo")
+	_assert(err == nil && len(servers) == 1 && servers[0] == a, "expect only %s, but got %v %v", a, servers, err)
+	_assert(d.Metadata(b) == nil && d.Weight(b) == DefaultWeight, "metadata of %s should be removed, but got %v", b, d.Metadata(b))
+	_assert(len(d.weights) == 1 && len(d.metadata) == 1, "expect metadata of only %s, but got %v", a, d.metadata)
+}
+
+func TestTinyRegistryDiscovery_WatchTimeout(t *testing.T) {
+	// 注册中心接受连接之后一直不响应
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-hang
+	}))
+	defer ts.Close()
+	defer close(hang)
+
+	timeout := watchClient.Timeout
+	watchClient.Timeout = time.Millisecond * 100
+	defer func() { watchClient.Timeout = timeout }()
+
+	d := NewTinyRegistryDiscovery(ts.URL+"/_tinyrpc_/registry", 0)
+	start := time.Now()
+	_, _, err := d.watchOnce(context.Background(), 1, true)
+	_assert(err != nil && time.Since(start) < time.Second, "watch should time out, but got %v after %v", err, time.Since(start))
+}
@@ -0,0 +1,213 @@
+package xclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Asolmn/tinyrpc/registry"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWatchWait  = time.Second * 30 // watch请求在注册中心的等待时间
+	maxWatchRetryWait = time.Second * 30 // watch失败后的最长重试间隔
+)
+
+// watchClient 发送watch请求使用的客户端，超时时间比等待时间多出一段余量
+// 注册中心接受连接之后一直不响应时，请求超时返回错误，由watchLoop切换到下一个注册中心
+var watchClient = &http.Client{Timeout: defaultWatchWait + registryClient.Timeout}
+
+// errWatchUnsupported 注册中心不支持watch请求
+var errWatchUnsupported = errors.New("rpc registry: watch is not supported")
+
+// registryWatcher 后台watch注册中心的状态
+type registryWatcher struct {
+	cancel    context.CancelFunc
+	done      chan struct{}        // 后台协程退出后关闭
+	synced    bool                 // 是否已经成功获取过服务列表
+	err       error                // 最近一次watch的错误，成功后清空
+	index     uint64               // 注册中心的修订号
+	instances []*registry.Instance // 注册中心的所有服务实例
+}
+
+// Watch 启动后台协程watch注册中心，服务列表发生变化时立即更新
+// 启用watch后，GetAll和GetService不再在调用路径上请求注册中心
+// 返回首次获取服务列表的错误，出错时后台协程仍然会继续重试
+func (d *TinyRegistryDiscory) Watch() error {
+	d.mu.Lock()
+	if d.watcher != nil {
+		d.mu.Unlock()
+		return nil
+	}
+	if len(d.registries) == 0 {
+		d.mu.Unlock()
+		return errors.New("rpc registry: no registry address")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &registryWatcher{cancel: cancel, done: make(chan struct{})}
+	d.watcher = w
+	d.mu.Unlock()
+
+	// 首次获取服务列表，不等待变化，出错时依次尝试其他注册中心
+	var instances []*registry.Instance
+	var index uint64
+	var err error
+	for i := 0; i < len(d.registries); i++ {
+		if instances, index, err = d.watchOnce(ctx, 0, false); err == nil || err == errWatchUnsupported {
+			break
+		}
+		d.current.Store(int32((int(d.current.Load()) + 1) % len(d.registries)))
+	}
+	if err == errWatchUnsupported {
+		instances, err = d.fetch("", "")
+	}
+	d.apply(w, instances, index, err)
+
+	go d.watchLoop(ctx, w)
+	return err
+}
+
+// Close 停止后台watch协程
+func (d *TinyRegistryDiscory) Close() error {
+	d.mu.Lock()
+	w := d.watcher
+	d.watcher = nil
+	d.mu.Unlock()
+
+	if w != nil {
+		w.cancel()
+		<-w.done
+	}
+	return nil
+}
+
+// Revision 返回最近一次从注册中心获取的修订号，用于调试，没有启用watch时返回0
+func (d *TinyRegistryDiscory) Revision() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.watcher == nil {
+		return 0
+	}
+	return d.watcher.index
+}
+
+// watchLoop 循环发送watch请求，注册中心不支持watch时，按照过期时间定时获取服务列表
+func (d *TinyRegistryDiscory) watchLoop(ctx context.Context, w *registryWatcher) {
+	defer close(w.done)
+
+	retry := time.Second
+	for {
+		d.mu.RLock()
+		index, synced := w.index, w.synced
+		d.mu.RUnlock()
+
+		instances, next, err := d.watchOnce(ctx, index, synced)
+		wait := time.Duration(0)
+		if err == errWatchUnsupported {
+			instances, err = d.fetch("", "")
+			wait = d.timeout
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		d.apply(w, instances, next, err)
+
+		// 出错时切换到下一个注册中心，并按照指数退避重试
+		if err != nil {
+			d.current.Store(int32((int(d.current.Load()) + 1) % len(d.registries)))
+			wait = retry
+			if retry *= 2; retry > maxWatchRetryWait {
+				retry = maxWatchRetryWait
+			}
+		} else {
+			retry = time.Second
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// watchOnce 发送一次watch请求，wait为false时立即返回当前的服务列表
+func (d *TinyRegistryDiscory) watchOnce(ctx context.Context, index uint64, wait bool) ([]*registry.Instance, uint64, error) {
+	// watch请求只发送给当前的注册中心，出错时由watchLoop切换到下一个
+	current := int(d.current.Load()) % len(d.registries)
+	registryAddr := strings.TrimSuffix(d.registries[current], "/") + "/v1/instances"
+	if wait {
+		query := url.Values{
+			"index": {strconv.FormatUint(index, 10)},
+			"wait":  {defaultWatchWait.String()},
+		}
+		registryAddr += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", registryAddr, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := watchClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// 旧版本的注册中心没有REST API，或者不返回修订号
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") || resp.Header.Get("X-Tinyrpc-Index") == "" {
+		return nil, 0, errWatchUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("rpc registry: watch " + resp.Status)
+	}
+
+	var body struct {
+		Index     uint64               `json:"index"`
+		Instances []*registry.Instance `json:"instances"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, 0, err
+	}
+	return body.Instances, body.Index, nil
+}
+
+// apply 更新watch获取的服务列表
+func (d *TinyRegistryDiscory) apply(w *registryWatcher, instances []*registry.Instance, index uint64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.watcher != w { // watch已经被停止
+		return
+	}
+	if err != nil {
+		d.log().Warn("rpc registry: watch error", "err", err)
+		w.err = err
+		return
+	}
+	w.synced, w.err, w.index, w.instances = true, nil, index, instances
+	d.setInstances(versioned(instances, d.version))
+	d.lastUpdate = time.Now()
+}
+
+// watched 判断服务列表是否由watch维护，watch出错时回退到按需请求注册中心，调用方需要持有锁
+func (d *TinyRegistryDiscory) watched() bool {
+	return d.watcher != nil && d.watcher.synced && d.watcher.err == nil
+}
+
+// filter 从watch维护的服务列表中返回提供service的服务实例，调用方需要持有锁
+func (d *TinyRegistryDiscory) filter(service string) []string {
+	var servers []string
+	for _, inst := range d.watcher.instances {
+		if inst.Addr != "" && inst.Provides(service, d.version) {
+			servers = append(servers, inst.Addr)
+		}
+	}
+	return servers
+}
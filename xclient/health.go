@@ -0,0 +1,137 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "github.com/Asolmn/tinyrpc"
+	"sync"
+	"time"
+)
+
+// healthKey 服务实例上的一个服务
+type healthKey struct {
+	addr    string
+	service string
+}
+
+// healthEntry 服务实例上一个服务的健康状态
+type healthEntry struct {
+	status   ServingStatus
+	lastUsed time.Time // 最后一次被选择的时间，长时间没有使用的服务不再检查
+}
+
+// healthChecker 定时调用服务实例的Health.Check，选择服务实例时跳过NOT_SERVING的实例
+type healthChecker struct {
+	interval time.Duration
+	mu       sync.Mutex
+	entries  map[healthKey]*healthEntry
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// EnableHealthCheck 每隔interval调用一次服务实例的内置健康检查服务，选择服务实例时跳过NOT_SERVING的实例
+// 首次选择某个服务实例时状态未知，视为可用，没有注册健康检查服务的服务实例始终视为可用
+// 需要在发起调用之前设置，Close时停止检查，重复启用时返回错误
+func (xc *XClient) EnableHealthCheck(interval time.Duration) error {
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+	hc := &healthChecker{
+		interval: interval,
+		entries:  make(map[healthKey]*healthEntry),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if !xc.health.CompareAndSwap(nil, hc) {
+		return errors.New("rpc xclient: health check already enabled")
+	}
+	go xc.healthCheckLoop(hc)
+	return nil
+}
+
+// serving 判断服务实例上的服务是否可用，并记录使用时间
+func (hc *healthChecker) serving(addr, service string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	key := healthKey{addr: addr, service: service}
+	e, ok := hc.entries[key]
+	if !ok {
+		e = &healthEntry{status: StatusUnknown}
+		hc.entries[key] = e
+	}
+	e.lastUsed = time.Now()
+	return e.status != StatusNotServing
+}
+
+// close 停止检查
+func (hc *healthChecker) close() {
+	close(hc.stop)
+	<-hc.done
+}
+
+// healthCheckLoop 定时检查最近使用过的服务
+func (xc *XClient) healthCheckLoop(hc *healthChecker) {
+	defer close(hc.done)
+
+	t := time.NewTicker(hc.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-hc.stop:
+			return
+		}
+
+		hc.mu.Lock()
+		keys := make([]healthKey, 0, len(hc.entries))
+		for key, e := range hc.entries {
+			// 超过10个周期没有使用的服务不再检查
+			if time.Since(e.lastUsed) > 10*hc.interval {
+				delete(hc.entries, key)
+				continue
+			}
+			keys = append(keys, key)
+		}
+		hc.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key healthKey) {
+				defer wg.Done()
+				status, ok := xc.checkHealth(key.addr, key.service, hc.interval)
+				if !ok {
+					return
+				}
+				hc.mu.Lock()
+				if e, ok := hc.entries[key]; ok {
+					e.status = status
+				}
+				hc.mu.Unlock()
+			}(key)
+		}
+		wg.Wait()
+	}
+}
+
+// checkHealth 调用服务实例的Health.Check，调用失败时返回false，由熔断器处理不可用的服务实例
+// 服务实例没有注册健康检查服务时返回SERVING
+func (xc *XClient) checkHealth(rpcAddr, service string, timeout time.Duration) (ServingStatus, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := xc.dial(ctx, rpcAddr)
+	if err != nil {
+		return StatusUnknown, false
+	}
+
+	var reply HealthCheckResponse
+	err = client.Call(ctx, HealthServiceName+".Check", HealthCheckRequest{Service: service}, &reply)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			return StatusServing, true
+		}
+		return StatusUnknown, false
+	}
+	return reply.Status, true
+}
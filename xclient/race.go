@@ -0,0 +1,90 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Race 将同一个调用发送给k个服务实例，返回最先成功的结果，并取消其余的调用
+// k个服务实例由负载均衡策略依次选出，服务实例不足k个时发送给所有可用实例
+// 返回给出回复的服务实例地址；所有实例都失败时，返回第一个错误
+func (xc *XClient) Race(ctx context.Context, k int, serviceMethod string, args, reply interface{}) (addr string, err error) {
+	if k <= 0 {
+		return "", errors.New("rpc xclient: race requires at least one server")
+	}
+	servers, err := xc.servers(serviceMethod)
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errors.New("rpc discovery: no available severs")
+	}
+
+	// 由负载均衡器依次选出k个不同的服务实例
+	info := xc.callInfo(ctx, serviceMethod, args)
+	picked := make([]string, 0, k)
+	generations := make(map[string]uint64, k) // 熔断器放行时的代数
+	for len(picked) < k && len(servers) > 0 {
+		rpcAddr, generation, err := xc.pick(ctx, servers, info)
+		if err != nil {
+			if len(picked) > 0 { // 已经选出部分实例，使用已选出的实例
+				break
+			}
+			return "", err
+		}
+		picked = append(picked, rpcAddr)
+		generations[rpcAddr] = generation
+		servers = without(servers, rpcAddr)
+	}
+
+	ctx, span := xc.startSpan(ctx, "xclient.Race", serviceMethod)
+	defer func() { span.End(err) }()
+
+	// 第一个成功的调用取消其余的调用
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		addr  string
+		reply interface{}
+		err   error
+	}
+	ch := make(chan result, len(picked))
+	for _, rpcAddr := range picked {
+		go func(rpcAddr string, generation uint64) {
+			// 每个实例使用各自的回复，避免并发写入reply
+			var cloneReply interface{}
+			if reply != nil {
+				cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			done := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply)
+			xc.balancer.Done(rpcAddr, info, done)
+			xc.done(rpcAddr, generation, done)
+			ch <- result{addr: rpcAddr, reply: cloneReply, err: done.Err}
+		}(rpcAddr, generations[rpcAddr])
+	}
+
+	var firstErr error
+	for range picked {
+		select {
+		case r := <-ch:
+			if r.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				return r.addr, nil
+			}
+			if ctx.Err() != nil { // 调用因为ctx结束而失败，与ctx.Done同时就绪时同样返回ctx的错误
+				return "", fmt.Errorf("rpc xclient: race failed: %w", ctx.Err())
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("rpc xclient: race failed: %w", ctx.Err())
+		}
+	}
+	return "", firstErr
+}
@@ -0,0 +1,53 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXClient_Race(t *testing.T) {
+	alive, dead := startServer(t), deadAddr(t)
+	d := NewMultiServerDiscovery([]string{dead, alive})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	t.Run("first success", func(t *testing.T) {
+		var reply int
+		rpcAddr, err := xc.Race(context.Background(), 2, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "race should succeed with one alive server: %v", err)
+		_assert(rpcAddr == alive, "expect the reply from %s, but got %s", alive, rpcAddr)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		d := NewMultiServerDiscovery([]string{dead})
+		xc := NewXClient(d, RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+
+		_, err := xc.Race(context.Background(), 2, "Foo.Sum", Args{}, nil)
+		_assert(err != nil, "expect an error when all servers failed")
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		var reply int
+		start := time.Now()
+		_, err := xc.Race(ctx, 2, "Foo.Sleep", Args{Num1: 1000}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "deadline"), "expect a deadline error, but got %v", err)
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect context.DeadlineExceeded, but got %v", err)
+		_assert(time.Since(start) < time.Millisecond*500, "race should return when ctx is done")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*100, cancel)
+
+		var reply int
+		_, err := xc.Race(ctx, 2, "Foo.Sleep", Args{Num1: 1000}, &reply)
+		_assert(errors.Is(err, context.Canceled), "expect context.Canceled, but got %v", err)
+	})
+}
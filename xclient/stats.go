@@ -0,0 +1,192 @@
+package xclient
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	ewmaDecay    = time.Second * 10 // EWMA的衰减时间常数，越久之前的延迟权重越低
+	errorPenalty = time.Second      // 调用失败时按照该延迟计入EWMA，避免快速失败的实例吸引流量
+)
+
+// ewma 随时间衰减的指数加权移动平均延迟
+type ewma struct {
+	mu    sync.Mutex
+	value float64   // 平均延迟，单位纳秒
+	last  time.Time // 最后一次更新的时间
+}
+
+// observe 记录一次调用的延迟
+func (e *ewma) observe(latency time.Duration, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.last.IsZero() {
+		e.value = float64(latency)
+	} else {
+		// 两次观测间隔越长，旧值的权重越低
+		w := math.Exp(-float64(now.Sub(e.last)) / float64(ewmaDecay))
+		e.value = e.value*w + float64(latency)*(1-w)
+	}
+	e.last = now
+}
+
+// get 返回当前的平均延迟
+func (e *ewma) get() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return time.Duration(e.value)
+}
+
+// ServerStat 单个服务实例的负载统计
+type ServerStat struct {
+	Addr    string
+	Pending int           // 未完成的调用数
+	Latency time.Duration // EWMA平均延迟
+}
+
+// load 服务实例的负载评分，平均延迟乘以未完成的调用数，没有统计数据的实例评分最低
+func load(stat ServerStat) float64 {
+	return float64(stat.Latency) * float64(stat.Pending+1)
+}
+
+// loadStats 按服务地址记录未完成的调用数和EWMA延迟
+// 每个XClient持有一份，在每次调用前后更新，负载均衡器通过CallInfo.Stat读取
+type loadStats struct {
+	mu      sync.Mutex
+	pending map[string]int
+	latency map[string]*ewma
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{
+		pending: make(map[string]int),
+		latency: make(map[string]*ewma),
+	}
+}
+
+// begin 开始调用服务实例，未完成的调用数加1
+func (s *loadStats) begin(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[addr]++
+}
+
+// release 选中的服务实例没有被调用，未完成的调用数减1，不记录延迟
+func (s *loadStats) release(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decr(addr)
+}
+
+// decr 未完成的调用数减1，需要持有锁
+func (s *loadStats) decr(addr string) {
+	if s.pending[addr]--; s.pending[addr] <= 0 {
+		delete(s.pending, addr)
+	}
+}
+
+// end 调用结束，记录调用延迟，调用失败时至少按照errorPenalty计入，主动取消的调用不计入延迟
+func (s *loadStats) end(addr string, done DoneInfo) {
+	s.mu.Lock()
+	s.decr(addr)
+	e, ok := s.latency[addr]
+	if !ok {
+		e = new(ewma)
+		s.latency[addr] = e
+	}
+	s.mu.Unlock()
+
+	if done.Canceled {
+		return
+	}
+	latency := done.Latency
+	if done.Err != nil && latency < errorPenalty {
+		latency = errorPenalty
+	}
+	e.observe(latency, time.Now())
+}
+
+// stat 返回服务实例的负载统计
+func (s *loadStats) stat(addr string) ServerStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat := ServerStat{Addr: addr, Pending: s.pending[addr]}
+	if e, ok := s.latency[addr]; ok {
+		stat.Latency = e.get()
+	}
+	return stat
+}
+
+// leastPendingBalancer 选择未完成调用数最少的实例
+// 负载统计由XClient记录，通过CallInfo.Stat获取，选中后通过CallInfo.Reserve占用
+type leastPendingBalancer struct {
+	mu sync.Mutex // 读取统计与占用之间不能有并发的Pick
+}
+
+func newLeastPendingBalancer() *leastPendingBalancer {
+	return &leastPendingBalancer{}
+}
+
+func (b *leastPendingBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 从随机位置开始遍历，未完成调用数相同时避免总是选中同一个实例
+	n := len(servers)
+	offset := rand.Intn(n)
+	best := info.Stat(servers[offset])
+	for k := 1; k < n; k++ {
+		stat := info.Stat(servers[(offset+k)%n])
+		if stat.Pending < best.Pending {
+			best = stat
+		}
+	}
+	info.Reserve(best.Addr)
+	return best.Addr, nil
+}
+
+func (b *leastPendingBalancer) Done(string, *CallInfo, DoneInfo) {}
+
+// p2cBalancer 随机选择两个实例，选择EWMA延迟与未完成调用数评分更低的实例
+// 负载统计由XClient记录，通过CallInfo.Stat获取，选中后通过CallInfo.Reserve占用
+type p2cBalancer struct {
+	mu sync.Mutex // 读取统计与占用之间不能有并发的Pick
+}
+
+func newP2CBalancer() *p2cBalancer {
+	return &p2cBalancer{}
+}
+
+func (b *p2cBalancer) Pick(_ context.Context, servers []string, info *CallInfo) (string, error) {
+	n := len(servers)
+	if n == 1 {
+		return servers[0], nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 随机选择两个不同的实例，返回负载评分更低的实例
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	best := info.Stat(servers[i])
+	if other := info.Stat(servers[j]); load(other) < load(best) {
+		best = other
+	}
+	info.Reserve(best.Addr)
+	return best.Addr, nil
+}
+
+func (b *p2cBalancer) Done(string, *CallInfo, DoneInfo) {}
@@ -2,20 +2,30 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	. "github.com/Asolmn/tinyrpc"
+	"github.com/Asolmn/tinyrpc/tracing"
 	"io"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // XClient 支持负载均衡的客户端
 type XClient struct {
-	d    Discovery  // 服务发现实例
-	mode SelectMode // 负载均衡模式
-	opt  *Option    // 协议选项
-	mu   sync.Mutex
+	d        Discovery  // 服务发现实例
+	mode     SelectMode // 负载均衡模式
+	balancer Balancer   // 负载均衡器，根据mode创建
+	opt      *Option    // 协议选项
+	mu       sync.Mutex
 	// 为例复用已经创建好的Socket连接，保存创建成功的Client实例
-	clients map[string]*Client
+	clients  map[string]*Client
+	breakers atomic.Pointer[Breakers]      // 每个服务实例的熔断器，为nil表示不启用熔断
+	hashKey  HashKeyFunc                   // 一致性哈希时从参数中提取key，context中没有key时使用
+	stats    *loadStats                    // 每个服务实例的未完成调用数和EWMA延迟，负载均衡器通过CallInfo.Stat读取
+	health   atomic.Pointer[healthChecker] // 服务实例的健康检查，为nil表示不启用
 }
 
 // 检验XClient是否提供Close方法
@@ -23,6 +33,10 @@ var _ io.Closer = (*XClient)(nil)
 
 // Close 关闭客户端
 func (xc *XClient) Close() error {
+	if hc := xc.health.Swap(nil); hc != nil {
+		hc.close()
+	}
+
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 
@@ -35,18 +49,49 @@ func (xc *XClient) Close() error {
 	return nil
 }
 
-// NewXClient 创建一个XClient实例
+// NewXClient 创建一个XClient实例，根据mode创建对应的负载均衡器
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
+	b, err := NewBalancer(mode)
+	if err != nil { // 不支持的负载均衡策略，调用时返回错误
+		b = errBalancer{err: err}
+	}
 	return &XClient{
-		d:       d,
-		mode:    mode,
-		opt:     opt,
-		clients: make(map[string]*Client),
+		d:        d,
+		mode:     mode,
+		balancer: b,
+		opt:      opt,
+		clients:  make(map[string]*Client),
+		stats:    newLoadStats(),
+	}
+}
+
+// SetBalancer 替换负载均衡器，需要在发起调用之前设置
+func (xc *XClient) SetBalancer(b Balancer) {
+	xc.balancer = b
+}
+
+// EnableBreaker 为每个服务实例启用熔断器，熔断器打开的服务实例不会被选中
+// 可以在发起调用之后启用，已经启用时返回错误
+func (xc *XClient) EnableBreaker(opt *BreakerOption) error {
+	if !xc.breakers.CompareAndSwap(nil, NewBreakers(opt)) {
+		return errors.New("rpc xclient: breaker already enabled")
 	}
+	return nil
 }
 
-// dial 发起连接方法
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+// Breakers 返回熔断器集合，用于获取熔断器的状态，未启用熔断时返回nil
+func (xc *XClient) Breakers() *Breakers {
+	return xc.breakers.Load()
+}
+
+// SetHashKeyFunc 设置一致性哈希key的提取函数
+// 使用ConsistentHashSelect策略时，优先使用WithHashKey在context中设置的key
+func (xc *XClient) SetHashKeyFunc(f HashKeyFunc) {
+	xc.hashKey = f
+}
+
+// dial 发起连接方法，ctx被取消时放弃连接
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*Client, error) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 
@@ -61,7 +106,7 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 	// 如果是新连接，通过XDial创建新的Client，并保存到clients中
 	if client == nil {
 		var err error
-		client, err = XDial(rpcAddr, xc.opt) // 发起连接，获取Client实例
+		client, err = XDialContext(ctx, rpcAddr, xc.opt) // 发起连接，获取Client实例
 		if err != nil {
 			return nil, err
 		}
@@ -71,35 +116,221 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 }
 
 // 根据传入的地址，发起客户端连接，并进行Call操作
-func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr) // 发起连接
+// 调用前需要已经为rpcAddr占用一个未完成的调用数，调用结束后释放
+func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) DoneInfo {
+	start := time.Now()
+
+	client, err := xc.dial(ctx, rpcAddr) // 发起连接
+	if err == nil {
+		err = client.Call(ctx, serviceMethod, args, reply)
+	}
+
+	// 调用方主动取消的请求不计入统计
+	done := DoneInfo{Err: err, Latency: time.Since(start), Canceled: err != nil && ctx.Err() == context.Canceled}
+	xc.stats.end(rpcAddr, done)
+	return done
+}
+
+// Stats 返回所有服务实例的负载统计
+func (xc *XClient) Stats() ([]ServerStat, error) {
+	servers, err := xc.d.GetAll()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	stats := make([]ServerStat, 0, len(servers))
+	for _, rpcAddr := range servers {
+		stats = append(stats, xc.stats.stat(rpcAddr))
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	return stats, nil
 }
 
 // Call 对XClient的call操作的一层封装
 // 调用call函数，等到完成，并返回其错误状态
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	info := xc.callInfo(ctx, serviceMethod, args)
 	// 根据指定的负载策略，选择一个服务，并返回服务地址
-	rpcAddr, err := xc.d.Get(xc.mode)
+	rpcAddr, generation, err := xc.selectServer(ctx, info)
 	if err != nil {
 		return err
 	}
 	// 传入地址，进行Call操作
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	done := xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	xc.balancer.Done(rpcAddr, info, done)
+	xc.done(rpcAddr, generation, done)
+	return done.Err
+}
+
+// callInfo 构建负载均衡器所需的调用信息
+func (xc *XClient) callInfo(ctx context.Context, serviceMethod string, args interface{}) *CallInfo {
+	info := &CallInfo{ServiceMethod: serviceMethod, Args: args, stat: xc.stats.stat, reserve: xc.stats.begin}
+	if key, ok := HashKeyFromContext(ctx); ok { // 优先使用context中的一致性哈希key
+		info.Key = key
+	} else if xc.hashKey != nil {
+		info.Key = xc.hashKey(serviceMethod, args)
+	}
+	if d, ok := xc.d.(WeightedDiscovery); ok {
+		info.weight = d.Weight
+	}
+	if d, ok := xc.d.(MetadataDiscovery); ok {
+		info.metadata = d.Metadata
+	}
+	return info
+}
+
+// selectServer 从服务发现返回的服务列表中，由负载均衡器选择一个服务实例，并跳过熔断器打开的服务实例
+// 启用熔断时，返回的服务实例已经通过熔断器的检查，调用结束后需要使用返回的代数调用done汇报结果
+func (xc *XClient) selectServer(ctx context.Context, info *CallInfo) (string, uint64, error) {
+	servers, err := xc.servers(info.ServiceMethod)
+	if err != nil {
+		return "", 0, err
+	}
+	return xc.pick(ctx, servers, info)
+}
+
+// servers 返回提供serviceMethod对应服务的服务实例
+// 服务发现不支持按服务名发现时，返回所有服务实例
+func (xc *XClient) servers(serviceMethod string) ([]string, error) {
+	if d, ok := xc.d.(ServiceDiscovery); ok {
+		if dot := strings.LastIndex(serviceMethod, "."); dot > 0 {
+			return d.GetService(serviceMethod[:dot])
+		}
+	}
+	return xc.d.GetAll()
+}
+
+// pick 由负载均衡器从servers中选择一个服务实例，并跳过熔断器打开的服务实例
+// 启用熔断时同时返回熔断器放行的代数
+func (xc *XClient) pick(ctx context.Context, servers []string, info *CallInfo) (string, uint64, error) {
+	if len(servers) == 0 {
+		return "", 0, errors.New("rpc discovery: no available severs")
+	}
+	if hc := xc.health.Load(); hc != nil {
+		// 去除健康检查为NOT_SERVING的服务实例
+		service := info.ServiceMethod
+		if dot := strings.LastIndex(service, "."); dot >= 0 {
+			service = service[:dot]
+		}
+		serving := make([]string, 0, len(servers))
+		for _, rpcAddr := range servers {
+			if hc.serving(rpcAddr, service) {
+				serving = append(serving, rpcAddr)
+			}
+		}
+		if len(serving) == 0 {
+			return "", 0, errors.New("rpc xclient: no serving servers")
+		}
+		servers = serving
+	}
+	breakers := xc.breakers.Load()
+	if breakers == nil {
+		rpcAddr, err := xc.balance(ctx, servers, info)
+		return rpcAddr, 0, err
+	}
+
+	// 去除熔断器打开的服务实例
+	candidates := make([]string, 0, len(servers))
+	for _, rpcAddr := range servers {
+		if breakers.Get(rpcAddr).Ready() {
+			candidates = append(candidates, rpcAddr)
+		}
+	}
+
+	for len(candidates) > 0 {
+		rpcAddr, err := xc.balance(ctx, candidates, info)
+		if err != nil {
+			return "", 0, err
+		}
+		if generation, ok := breakers.Get(rpcAddr).Allow(); ok {
+			return rpcAddr, generation, nil
+		}
+		// 选中之后熔断器才打开，没有发起调用，撤销这次选择后从剩余的实例中重新选择
+		xc.stats.release(rpcAddr)
+		release(xc.balancer, rpcAddr, info)
+		candidates = without(candidates, rpcAddr)
+	}
+	return "", 0, ErrCircuitOpen
+}
+
+// balance 由负载均衡器从servers中选择一个服务实例，返回时已经为它占用了一个未完成的调用数
+// 负载均衡器在Pick中通过CallInfo.Reserve占用时，选择与占用之间不会有并发的Pick
+func (xc *XClient) balance(ctx context.Context, servers []string, info *CallInfo) (string, error) {
+	info.reserved = ""
+	rpcAddr, err := xc.balancer.Pick(ctx, servers, info)
+	if info.reserved != "" && (err != nil || info.reserved != rpcAddr) {
+		// 占用的实例与返回的实例不一致，按照返回的实例重新占用
+		xc.stats.release(info.reserved)
+		info.reserved = ""
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.reserved == "" {
+		xc.stats.begin(rpcAddr)
+	}
+	return rpcAddr, nil
+}
+
+// without 返回去除了addr的服务列表，不修改原列表
+func without(servers []string, addr string) []string {
+	rest := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if s != addr {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+// done 向熔断器汇报调用结果，generation为熔断器放行时的代数，调用方主动取消的请求不计入统计
+func (xc *XClient) done(rpcAddr string, generation uint64, done DoneInfo) {
+	breakers := xc.breakers.Load()
+	if breakers == nil {
+		return
+	}
+	cb := breakers.Get(rpcAddr)
+	if done.Canceled {
+		cb.Cancel(generation)
+		return
+	}
+	cb.Done(generation, done.Err)
+}
+
+// callServer 调用指定的服务实例，熔断器打开的服务实例直接返回错误
+func (xc *XClient) callServer(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	var generation uint64
+	if breakers := xc.breakers.Load(); breakers != nil {
+		var ok bool
+		if generation, ok = breakers.Get(rpcAddr).Allow(); !ok {
+			return ErrCircuitOpen
+		}
+	}
+	xc.stats.begin(rpcAddr)
+	done := xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	xc.done(rpcAddr, generation, done)
+	return done.Err
+}
+
+// startSpan 启用追踪时为扇出的调用创建父span，发送给每个服务实例的调用都是它的子span
+func (xc *XClient) startSpan(ctx context.Context, name, serviceMethod string) (context.Context, *tracing.Span) {
+	if xc.opt == nil {
+		return ctx, nil
+	}
+	ctx, span := xc.opt.Tracer.Start(ctx, name, tracing.KindInternal)
+	span.SetAttribute(tracing.AttrMethod, serviceMethod)
+	return ctx, span
 }
 
 // Broadcaset 请求广播到所有的服务实例
 // 如果任意一个实例发生错误，则返回其中一个错误
 // 如果调用成功，则返回其中一个结果
-func (xc *XClient) Broadcaset(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+func (xc *XClient) Broadcaset(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
 	// 获取服务列表
-	servers, err := xc.d.GetAll()
+	servers, err := xc.servers(serviceMethod)
 	if err != nil {
 		return err
 	}
+	ctx, span := xc.startSpan(ctx, "xclient.Broadcast", serviceMethod)
+	defer func() { span.End(err) }()
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -127,7 +358,7 @@ func (xc *XClient) Broadcaset(ctx context.Context, serviceMethod string, args, r
 			}
 
 			// 进行call操作，传入rpc地址，上下文，方法名，参数，返回参数
-			err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply)
+			err := xc.callServer(rpcAddr, ctx, serviceMethod, args, cloneReply)
 
 			// 设置互斥锁，保证并发情况下error和reply能被正确赋值
 			mu.Lock()
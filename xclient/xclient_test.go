@@ -0,0 +1,221 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/Asolmn/tinyrpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct {
+	Num1, Num2 int
+}
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// startServer 启动一个注册了Foo服务的服务端，返回tcp@addr格式的地址
+func startServer(t *testing.T) string {
+	var foo Foo
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen tcp socket")
+	}
+	server := tinyrpc.NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+// deadAddr 返回一个没有服务端监听的地址
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen tcp socket")
+	}
+	addr := "tcp@" + l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestXClient_Breaker(t *testing.T) {
+	alive, dead := startServer(t), deadAddr(t)
+
+	d := NewMultiServerDiscovery([]string{dead, alive})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	_assert(xc.EnableBreaker(&BreakerOption{MaxFailures: 1, OpenTimeout: time.Minute}) == nil, "failed to enable breaker")
+	_assert(xc.EnableBreaker(nil) != nil, "enabling the breaker twice should fail")
+
+	var reply int
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply); err != nil {
+			failed++
+		}
+	}
+	_assert(failed == 1, "expect exactly 1 failed call before the breaker opens, but got %d", failed)
+	_assert(xc.Breakers().Get(dead).State() == StateOpen, "breaker of the dead server should be open")
+	_assert(xc.Breakers().Get(alive).State() == StateClosed, "breaker of the alive server should be closed")
+}
+
+func TestXClient_ConsistentHash(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startServer(t), startServer(t), startServer(t)})
+	xc := NewXClient(d, ConsistentHashSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect an error when no hash key is provided")
+
+	ctx := WithHashKey(context.Background(), "user-1")
+	err = xc.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum with hash key: %v", err)
+
+	xc.SetHashKeyFunc(func(serviceMethod string, args interface{}) string {
+		return fmt.Sprint(args.(Args).Num1)
+	})
+	err = xc.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "failed to call Foo.Sum with hash key func: %v", err)
+}
+
+func TestXClient_LoadAware(t *testing.T) {
+	busy, idle := startServer(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{busy, idle})
+
+	t.Run("least pending", func(t *testing.T) {
+		xc := NewXClient(d, LeastPendingSelect, nil)
+		defer func() { _ = xc.Close() }()
+
+		// 在busy上保持一个未完成的调用
+		xc.stats.begin(busy)
+		for i := 0; i < 10; i++ {
+			rpcAddr, _, err := xc.selectServer(context.Background(), xc.callInfo(context.Background(), "", nil))
+			_assert(err == nil && rpcAddr == idle, "expect the idle server, but got %s %v", rpcAddr, err)
+			xc.stats.release(rpcAddr) // 选中时占用了未完成的调用数，没有发起调用
+		}
+		xc.stats.end(busy, DoneInfo{})
+	})
+
+	t.Run("p2c", func(t *testing.T) {
+		xc := NewXClient(d, P2CSelect, nil)
+		defer func() { _ = xc.Close() }()
+
+		xc.stats.begin(busy)
+		xc.stats.end(busy, DoneInfo{Latency: time.Second})
+		xc.stats.begin(idle)
+		xc.stats.end(idle, DoneInfo{Latency: time.Millisecond})
+		for i := 0; i < 10; i++ {
+			rpcAddr, _, err := xc.selectServer(context.Background(), xc.callInfo(context.Background(), "", nil))
+			_assert(err == nil && rpcAddr == idle, "expect the faster server, but got %s %v", rpcAddr, err)
+			xc.stats.release(rpcAddr)
+		}
+
+		var reply int
+		err := xc.Call(context.Background(), "Foo.Sleep", Args{Num1: 50, Num2: 2}, &reply)
+		_assert(err == nil && reply == 52, "failed to call Foo.Sleep: %v", err)
+		stats, _ := xc.Stats()
+		_assert(len(stats) == 2 && stats[0].Pending == 0 && stats[1].Pending == 0, "unexpected stats %v", stats)
+		_assert(stats[0].Latency+stats[1].Latency >= time.Millisecond*50, "latency should be recorded, but got %v", stats)
+	})
+}
+
+func TestXClient_ConcurrentPick(t *testing.T) {
+	a, b := startServer(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{a, b})
+
+	for _, mode := range []SelectMode{LeastPendingSelect, P2CSelect} {
+		xc := NewXClient(d, mode, nil)
+		// 两个实例的延迟相同，P2C只按照未完成的调用数区分
+		xc.stats.begin(a)
+		xc.stats.end(a, DoneInfo{Latency: time.Millisecond})
+		xc.stats.begin(b)
+		xc.stats.end(b, DoneInfo{Latency: time.Millisecond})
+
+		// 并发选择且都没有完成调用，选中时已经占用了未完成的调用数，后来的选择不会集中到同一个实例
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// 放慢统计的读取，使并发的选择在读取统计时重叠
+				info := xc.callInfo(context.Background(), "Foo.Sum", nil)
+				stat := info.stat
+				info.stat = func(addr string) ServerStat {
+					time.Sleep(time.Millisecond)
+					return stat(addr)
+				}
+				_, _, err := xc.selectServer(context.Background(), info)
+				_assert(err == nil, "failed to select: %v", err)
+			}()
+		}
+		wg.Wait()
+		pa, pb := xc.stats.stat(a).Pending, xc.stats.stat(b).Pending
+		_assert(pa == 10 && pb == 10, "mode %d: expect 10 pending picks on each server, but got %d and %d", mode, pa, pb)
+		_ = xc.Close()
+	}
+}
+
+func TestXClient_HealthCheck(t *testing.T) {
+	// notServing启用了健康检查服务，Foo服务设置为NOT_SERVING
+	var foo Foo
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := tinyrpc.NewServer()
+	_ = server.Register(&foo)
+	server.EnableHealth().SetServingStatus("Foo", tinyrpc.StatusNotServing)
+	go server.Accept(l)
+	defer func() { _ = l.Close() }()
+	notServing := "tcp@" + l.Addr().String()
+
+	// plain没有注册健康检查服务，视为可用
+	plain := startServer(t)
+
+	d := NewMultiServerDiscovery([]string{notServing, plain})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBalancer(new(firstBalancer))
+	_assert(xc.EnableHealthCheck(20*time.Millisecond) == nil, "failed to enable health check")
+	_assert(xc.EnableHealthCheck(time.Second) != nil, "enabling the health check twice should fail")
+
+	// 第一次选择时状态未知，视为可用
+	rpcAddr, _, err := xc.selectServer(context.Background(), &CallInfo{ServiceMethod: "Foo.Sum"})
+	_assert(err == nil && rpcAddr == notServing, "unknown status should be treated as serving")
+
+	for i := 0; i < 100 && rpcAddr == notServing; i++ {
+		time.Sleep(10 * time.Millisecond)
+		rpcAddr, _, err = xc.selectServer(context.Background(), &CallInfo{ServiceMethod: "Foo.Sum"})
+	}
+	_assert(err == nil && rpcAddr == plain, "NOT_SERVING server should be skipped, but got %s %v", rpcAddr, err)
+
+	var reply int
+	_assert(xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "call should succeed")
+
+	// Close停止健康检查时，可以与选择服务实例并发执行
+	closed := make(chan struct{})
+	go func() {
+		_ = xc.Close()
+		close(closed)
+	}()
+	_, _, _ = xc.selectServer(context.Background(), &CallInfo{ServiceMethod: "Foo.Sum"})
+	<-closed
+}